@@ -0,0 +1,99 @@
+/*
+Fsck checks that the photos in the database of an instance and the images in its storage match: it lists the photos
+whose image is missing, the images used by more than one photo and the images that no photo refers to (orphans). With
+-repair, the photos without image and the orphans are deleted. It can run while webapi is serving.
+
+Usage:
+
+	fsck [flags]
+
+The flags are:
+
+	-db <path>
+		The database of the instance (default /tmp/wasaPhoto.db, like webapi).
+	-backend <local|s3|memory>
+		The storage of the images, like Storage.Backend of webapi (default local).
+	-images <path>
+		The folder of the images, with the local storage (default /tmp/images).
+	-s3-endpoint, -s3-region, -s3-bucket, -s3-access-key, -s3-path-style
+		The bucket of the images, with the s3 storage. The secret key is read from CFG_STORAGE_S3_SECRETKEY, like
+		webapi.
+	-repair
+		Delete the photos without image and the orphan images.
+	-grace <duration>
+		Ignore the images younger than this, which may belong to uploads in progress (default 1h).
+
+The report is printed to the standard output as JSON.
+
+Return values (exit codes):
+
+	0
+		The database and the storage match, or everything was repaired
+
+	1
+		The check failed
+
+	2
+		Some problems are left: run again with -repair, and fix the shared images by hand
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/integrity"
+	"wasaPhoto/service/storage"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	consistent, err := run()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+	if !consistent {
+		os.Exit(2)
+	}
+}
+
+func run() (bool, error) {
+	dbFilename := flag.String("db", "/tmp/wasaPhoto.db", "database of the instance")
+	var storageCfg storage.Config
+	storageCfg.RegisterFlags(flag.CommandLine)
+	repair := flag.Bool("repair", false, "delete the photos without image and the orphan images")
+	grace := flag.Duration("grace", time.Hour, "ignore the images younger than this")
+	flag.Parse()
+	storageCfg.S3.SecretKey = os.Getenv("CFG_STORAGE_S3_SECRETKEY")
+
+	store, err := storage.Open(storageCfg)
+	if err != nil {
+		return false, fmt.Errorf("opening the image storage: %w", err)
+	}
+
+	dbconn, err := sql.Open("sqlite3", *dbFilename)
+	if err != nil {
+		return false, fmt.Errorf("opening SQLite DB: %w", err)
+	}
+	defer dbconn.Close()
+	db, err := database.New(dbconn)
+	if err != nil {
+		return false, fmt.Errorf("creating AppDatabase: %w", err)
+	}
+
+	report, err := integrity.Check(context.Background(), db, store, integrity.Options{Repair: *repair, Grace: *grace})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(report); err == nil {
+		err = encErr
+	}
+	return report.Consistent(), err
+}
@@ -1,7 +1,8 @@
 /*
 Healthcheck is a simple program that sends an HTTP request to the local host (self) to a configured port number.
 It's used in environment where you need a simple probe for health checks (e.g., an empty container in docker).
-The probe URL is http://localhost:3000/liveness . Only the port can be changed.
+The probe URL is http://localhost:3000/liveness , or http://localhost:3000/readiness to check the dependencies of the
+service too (database, image storage, background workers). Only the port and the scheme (with -tls) can be changed.
 
 Usage:
 
@@ -12,6 +13,13 @@ The flags are:
 	-port <1-65535>
 		Change the port where the request is sent.
 
+	-readiness
+		Probe /readiness instead of /liveness: the service must be ready to serve requests, not just running.
+
+	-tls
+		Use HTTPS, for a service serving TLS directly. The certificate is not verified, since it's not issued for
+		localhost.
+
 Return values (exit codes):
 
 	0
@@ -23,6 +31,7 @@ Return values (exit codes):
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net/http"
@@ -31,18 +40,45 @@ import (
 
 func main() {
 	var port = flag.Int("port", 3000, "HTTP port for healthcheck")
+	var readiness = flag.Bool("readiness", false, "probe readiness instead of liveness")
+	var useTLS = flag.Bool("tls", false, "use HTTPS, without verifying the certificate")
 
 	flag.Parse()
 
-	res, err := http.Get(fmt.Sprintf("http://localhost:%d/liveness", *port))
-	if err != nil {
+	probe := "liveness"
+	if *readiness {
+		probe = "readiness"
+	}
+	scheme := "http"
+	if *useTLS {
+		scheme = "https"
+	}
+	if err := check(newClient(*useTLS), fmt.Sprintf("%s://localhost:%d/%s", scheme, *port, probe)); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
-	} else if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
-		_ = res.Body.Close()
-		_, _ = fmt.Fprintln(os.Stderr, "Healthcheck request not OK: ", res.Status)
-		os.Exit(1)
 	}
-	_ = res.Body.Close()
 	os.Exit(0)
 }
+
+// newClient returns the client of the probe. With useTLS, the certificate is not verified.
+func newClient(useTLS bool) *http.Client {
+	if !useTLS {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+}
+
+// check sends the probe to url, and fails unless the service answers 200 or 204
+func check(client *http.Client, url string) error {
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("healthcheck request not OK: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		status int
+		ok     bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNoContent, true},
+		{http.StatusServiceUnavailable, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusNotFound, false},
+		{http.StatusMovedPermanently, false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/readiness" {
+				w.Header().Set("Location", "/elsewhere")
+				w.WriteHeader(tt.status)
+			}
+		}))
+		client := server.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		if err := check(client, server.URL+"/readiness"); (err == nil) != tt.ok {
+			t.Errorf("status %d: error %v", tt.status, err)
+		}
+		server.Close()
+	}
+
+	// the service is not listening
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	if err := check(http.DefaultClient, server.URL+"/liveness"); err == nil {
+		t.Error("no error without the service")
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	// the certificate is not trusted, and is verified only without -tls
+	if err := check(newClient(false), server.URL+"/liveness"); err == nil {
+		t.Error("no error with an untrusted certificate")
+	}
+	if err := check(newClient(true), server.URL+"/liveness"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Importer recreates a user from a WASA-Photo export archive (the ZIP of "download your data"), e.g. to move a user
+between instances. It writes directly into the database of the target instance: photos keep their creation time, and
+comments, likes, follows and bans are matched by username to the users that exist there.
+
+Usage:
+
+	importer [flags] <archive.zip>
+
+The flags are:
+
+	-db <path>
+		The database of the target instance (default /tmp/wasaPhoto.db, like webapi).
+	-backend <local|s3|memory>
+		The storage of the images of the target instance, like Storage.Backend of webapi (default local).
+	-images <path>
+		The folder of the images, with the local storage (default /tmp/images).
+	-s3-endpoint, -s3-region, -s3-bucket, -s3-access-key, -s3-path-style
+		The bucket of the images, with the s3 storage. The secret key is read from CFG_STORAGE_S3_SECRETKEY, like
+		webapi.
+	-username <name>
+		Use this username instead of the one in the archive, e.g. when it's already taken.
+	-username-min-length, -username-max-length, -username-pattern, -username-reserved
+		The username policy of the target instance, like Usernames of webapi (with the same defaults): the username
+		must respect it. The reserved names are separated by ";".
+	-max-pixels <n>
+		The maximum width times height of the images, like Photos.MaxPixels of webapi (default 50000000). Larger
+		photos are skipped.
+
+The report of the import is printed to the standard output as JSON: Skipped lists what could not be mapped.
+
+Return values (exit codes):
+
+	0
+		The user was imported (some items may be skipped, see the report)
+
+	> 0
+		The import failed: nothing was imported
+*/
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"wasaPhoto/service/archive"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/storage"
+	"wasaPhoto/service/usernames"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	dbFilename := flag.String("db", "/tmp/wasaPhoto.db", "database of the target instance")
+	var storageCfg storage.Config
+	storageCfg.RegisterFlags(flag.CommandLine)
+	username := flag.String("username", "", "username of the imported user, instead of the one in the archive")
+	minLength := flag.Int("username-min-length", 3, "minimum length of the usernames")
+	maxLength := flag.Int("username-max-length", 20, "maximum length of the usernames")
+	pattern := flag.String("username-pattern", `^[\p{L}\p{N}_.-]+$`, "regular expression that the usernames must match")
+	reserved := flag.String("username-reserved", "admin;administrator;root;support;moderator;wasaphoto", "names that can't be used, separated by ;")
+	maxPixels := flag.Int("max-pixels", 50000000, "maximum width times height of the images")
+	flag.Parse()
+	storageCfg.S3.SecretKey = os.Getenv("CFG_STORAGE_S3_SECRETKEY")
+	if flag.NArg() != 1 {
+		return fmt.Errorf("usage: %s [flags] <archive.zip>", os.Args[0])
+	}
+	usernamePolicy, err := usernames.NewPolicy(*minLength, *maxLength, *pattern, strings.Split(*reserved, ";"))
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.OpenReader(flag.Arg(0))
+	if err != nil {
+		return fmt.Errorf("opening the archive: %w", err)
+	}
+	defer zr.Close()
+
+	dbconn, err := sql.Open("sqlite3", *dbFilename)
+	if err != nil {
+		return fmt.Errorf("opening SQLite DB: %w", err)
+	}
+	defer dbconn.Close()
+	db, err := database.New(dbconn)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	store, err := storage.Open(storageCfg)
+	if err != nil {
+		return fmt.Errorf("opening the image storage: %w", err)
+	}
+
+	report, err := archive.Import(&zr.Reader, db, archive.ImportOptions{
+		Storage:        store,
+		Username:       *username,
+		UsernamePolicy: usernamePolicy,
+		MaxPixels:      *maxPixels,
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
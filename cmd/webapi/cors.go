@@ -1,21 +1,118 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gorilla/handlers"
 )
 
+const (
+	// corsDevelopment allows every origin, without credentials: for the web UI served by the development server
+	corsDevelopment = "development"
+
+	// corsProduction allows only the origins in the allow-list
+	corsProduction = "production"
+)
+
+// corsExposedHeaders are the response headers readable by the web UI: the ones set by the handlers (the photo
+// metadata of GET /photos/:id, rate limiting, request ID) besides those always exposed by browsers
+var corsExposedHeaders = []string{
+	"Content-Length", "Content-Disposition", "Retry-After", "X-Request-ID",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+	"Filename", "Description", "CreatedAt",
+}
+
 // applyCORSHandler applies a CORS policy to the router. CORS stands for Cross-Origin Resource Sharing: it's a security
 // feature present in web browsers that blocks JavaScript requests going across different domains if not specified in a
-// policy. This function sends the policy of this API server.
-func applyCORSHandler(h http.Handler) http.Handler {
-	return handlers.CORS(
-		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT"}),
-		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedHeaders([]string{"Access-Control-Allow-Origin", "Content-Type", "Authorization"}),
-		handlers.ExposedHeaders([]string{"Content-Length", "Access-Control-Allow-Origin", "Content-Type", "Authorization"}),
-		handlers.AllowCredentials(),
-		handlers.MaxAge(10),
-	)(h)
+// policy. This function sends the policy of this API server, built from the configuration.
+func applyCORSHandler(cfg WebAPIConfiguration, h http.Handler) (http.Handler, error) {
+	c := cfg.CORS
+	opts := []handlers.CORSOption{
+		handlers.AllowedMethods(c.AllowedMethods),
+		handlers.AllowedHeaders(c.AllowedHeaders),
+		handlers.ExposedHeaders(corsExposedHeaders),
+		handlers.MaxAge(int(c.MaxAge.Seconds())),
+	}
+	switch c.Profile {
+	case corsDevelopment:
+		if c.AllowCredentials {
+			return nil, errors.New("credentials can't be allowed in the development profile, which allows every origin")
+		}
+		opts = append(opts, handlers.AllowedOrigins([]string{"*"}))
+	case corsProduction:
+		allowed, err := newOriginMatcher(c.AllowedOrigins)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, handlers.AllowedOriginValidator(allowed))
+		if c.AllowCredentials {
+			opts = append(opts, handlers.AllowCredentials())
+		}
+	default:
+		return nil, fmt.Errorf("unknown CORS profile %q", c.Profile)
+	}
+
+	cors := handlers.CORS(opts...)(h)
+	if c.Profile == corsDevelopment {
+		return cors, nil
+	}
+	// the allowed origin is echoed back, so the responses depend on the Origin header
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		cors.ServeHTTP(w, r)
+	}), nil
+}
+
+// originPattern is an allowed origin. A host starting with "*." matches its subdomains, at any depth, but not the
+// domain itself.
+type originPattern struct {
+	scheme string
+	host   string
+	port   string
+	suffix bool
+}
+
+// newOriginMatcher parses the allow-list of the production profile, where each origin is "scheme://host[:port]" and
+// the host can be "*.domain". The returned function reports whether an Origin header is allowed.
+func newOriginMatcher(origins []string) (func(string) bool, error) {
+	patterns := make([]originPattern, 0, len(origins))
+	for _, origin := range origins {
+		if origin == "*" {
+			return nil, errors.New(`the origin "*" is allowed only in the development profile`)
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return nil, fmt.Errorf("allowed origin %q is not scheme://host[:port]", origin)
+		}
+		p := originPattern{scheme: strings.ToLower(u.Scheme), host: strings.ToLower(u.Hostname()), port: u.Port()}
+		if strings.HasPrefix(p.host, "*.") {
+			p.suffix = true
+			p.host = p.host[1:]
+		}
+		if strings.Contains(p.host, "*") {
+			return nil, fmt.Errorf("allowed origin %q can have a wildcard only as the first label", origin)
+		}
+		patterns = append(patterns, p)
+	}
+
+	return func(origin string) bool {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+		for _, p := range patterns {
+			if p.scheme != scheme || p.port != port {
+				continue
+			}
+			if (!p.suffix && host == p.host) || (p.suffix && strings.HasSuffix(host, p.host) && len(host) > len(p.host)) {
+				return true
+			}
+		}
+		return false
+	}, nil
 }
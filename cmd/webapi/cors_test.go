@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestOriginMatcher(t *testing.T) {
+	allowed, err := newOriginMatcher([]string{"https://*.example.com", "https://app.example.org:8443", "http://localhost:3000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://a.example.com", true},
+		{"https://a.b.example.com", true},
+		{"HTTPS://A.Example.COM", true},
+		{"https://example.com", false},
+		{"https://evil-example.com", false},
+		{"https://a.example.com.evil.com", false},
+		{"http://a.example.com", false},
+		{"https://a.example.com:8443", false},
+		{"https://app.example.org:8443", true},
+		{"https://app.example.org", false},
+		{"https://app.example.org:443", false},
+		{"https://other.example.org:8443", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"http://localhost", false},
+		{"", false},
+		{"null", false},
+		{"a.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := allowed(tt.origin); got != tt.allowed {
+			t.Errorf("origin %q: allowed %v, want %v", tt.origin, got, tt.allowed)
+		}
+	}
+}
+
+func TestOriginMatcherInvalid(t *testing.T) {
+	for _, origin := range []string{
+		"*",
+		"example.com",
+		"https://",
+		"https://example.com/path",
+		"https://example.com?query",
+		"https://a.*.example.com",
+		"https://*example.com",
+		"https://example.*",
+		"://example.com",
+	} {
+		if _, err := newOriginMatcher([]string{"https://example.org", origin}); err == nil {
+			t.Errorf("origin %q: no error", origin)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"wasaPhoto/service/metrics"
+
+	"github.com/ardanlabs/conf"
+	"github.com/sirupsen/logrus"
+)
+
+// buildInfo is the response of /debug/build
+type buildInfo struct {
+	GoVersion string
+	Path      string
+	Version   string
+	Settings  map[string]string
+}
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// debugHandler returns the handler of the debug server. It has no authentication: the debug server must listen on an
+// address reachable only by the operators.
+//
+//	/metrics        metrics of the service in the Prometheus text format
+//	/debug/pprof/   profiles of net/http/pprof
+//	/debug/vars     variables of expvar
+//	/debug/build    Go version, module version and VCS information of the executable
+//	/debug/config   the configuration in use, with the secrets (conf:"mask") redacted
+//	/debug/loglevel the level of the logger: GET reads it, PUT changes it (body: "debug", "info", ...)
+func debugHandler(cfg WebAPIConfiguration, logger *logrus.Logger, reg *metrics.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/build", func(w http.ResponseWriter, r *http.Request) {
+		info := buildInfo{GoVersion: runtime.Version(), Settings: map[string]string{}}
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			info.Path = bi.Main.Path
+			info.Version = bi.Main.Version
+			for _, s := range bi.Settings {
+				info.Settings[s.Key] = s.Value
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+	})
+
+	mux.HandleFunc("/debug/config", func(w http.ResponseWriter, r *http.Request) {
+		out, err := conf.String(&cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprintln(w, out)
+	})
+
+	mux.HandleFunc("/debug/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			level, err := logrus.ParseLevel(strings.TrimSpace(string(body)))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.WithField("newlevel", level.String()).Warning("log level changed from the debug server")
+			logger.SetLevel(level)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprintln(w, logger.GetLevel().String())
+	})
+	return mux
+}
@@ -17,17 +17,148 @@ type WebAPIConfiguration struct {
 	Config struct {
 		Path string `conf:"default:/service/config.yml"`
 	}
+	// Web servers: DebugHost is the address of the debug server (pprof, expvar, configuration, log level, metrics),
+	// which has no authentication, so it must be reachable only by the operators: it listens on the loopback interface
+	// by default. Empty disables it.
 	Web struct {
 		APIHost         string        `conf:"default:0.0.0.0:3000"`
-		DebugHost       string        `conf:"default:0.0.0.0:4000"`
+		DebugHost       string        `conf:"default:127.0.0.1:4000"`
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
+		BehindProxy     bool
+	}
+	// TLS makes the API server serve HTTPS when CertFile is set. The certificate is reloaded on SIGHUP, and when the
+	// files change (checked every ReloadInterval, zero disables the check). MinVersion is "1.2" or "1.3";
+	// CipherSuites restricts the TLS 1.2 suites (Go names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), empty keeps the
+	// secure defaults. RedirectHost is an address (e.g. 0.0.0.0:80) where plain HTTP is redirected to HTTPS, on the host
+	// of APIHost (the host of the request if APIHost is 0.0.0.0 or [::]).
+	TLS struct {
+		CertFile       string
+		KeyFile        string
+		MinVersion     string `conf:"default:1.2"`
+		CipherSuites   []string
+		RedirectHost   string
+		ReloadInterval time.Duration `conf:"default:1m"`
+	}
+	// CORS policy, for the web UI served from another origin. The "development" profile allows every origin, without
+	// credentials. The "production" profile allows only AllowedOrigins ("scheme://host[:port]", where the host can be
+	// "*.example.com" for its subdomains): if empty, only the web UI served by this server works. MaxAge is how long
+	// browsers cache a preflight response (at most 10m).
+	CORS struct {
+		Profile          string `conf:"default:production"`
+		AllowedOrigins   []string
+		AllowedMethods   []string `conf:"default:GET;POST;PUT;DELETE;OPTIONS"`
+		AllowedHeaders   []string `conf:"default:Content-Type;Authorization;X-Request-ID;traceparent"`
+		AllowCredentials bool
+		MaxAge           time.Duration `conf:"default:10m"`
 	}
 	Debug bool
-	DB    struct {
+	// AccessLog writes a line per request in Format "combined" (Apache combined log format, followed by the route,
+	// the duration in milliseconds and the request ID) or "json", to File (the standard output if empty). "none"
+	// disables it. SampleRatio is the fraction of the successful requests that are logged: the failed ones (status
+	// 400 and more) are always logged.
+	AccessLog struct {
+		Format      string `conf:"default:json"`
+		File        string
+		SampleRatio float64 `conf:"default:1"`
+	}
+	DB struct {
 		Filename string `conf:"default:/tmp/wasaPhoto.db"`
 	}
+	Accounts struct {
+		// DeletionGracePeriod keeps deleted accounts deactivated, so that they can be restored by logging in, before
+		// deleting them for good. Zero deletes them immediately.
+		DeletionGracePeriod time.Duration `conf:"default:0s"`
+	}
+	// Usernames policy: length in characters, a regular expression that the usernames must match after the Unicode
+	// normalization, and names that can't be used (nor names that look like them)
+	Usernames struct {
+		MinLength int      `conf:"default:3"`
+		MaxLength int      `conf:"default:20"`
+		Pattern   string   `conf:"default:^[\\p{L}\\p{N}_.-]+$"`
+		Reserved  []string `conf:"default:admin;administrator;root;support;moderator;wasaphoto"`
+	}
+	// Storage of the images of the photos. Backend is "local" (files in Directory), "s3" (a bucket of AWS S3 or of a
+	// compatible service like MinIO: PathStyle puts the bucket in the path, as most of them need) or "memory" (lost on
+	// restart: for demos and tests).
+	Storage struct {
+		Backend   string `conf:"default:local"`
+		Directory string `conf:"default:/tmp/images"`
+		S3        struct {
+			Endpoint  string `conf:"default:https://s3.amazonaws.com"`
+			Region    string `conf:"default:us-east-1"`
+			Bucket    string
+			AccessKey string
+			SecretKey string        `conf:"mask"`
+			PathStyle bool          `conf:"default:true"`
+			Timeout   time.Duration `conf:"default:30s"`
+		}
+	}
+	// Photos are JPEG, PNG, GIF or WebP images of at most MaxSize bytes and MaxPixels pixels (width times height)
+	Photos struct {
+		MaxSize   int64 `conf:"default:10485760"`
+		MaxPixels int   `conf:"default:50000000"`
+	}
+	// Exports are the "download your data" archives, built in Directory. The download link is valid for TTL.
+	Exports struct {
+		Directory string        `conf:"default:/tmp/exports"`
+		TTL       time.Duration `conf:"default:24h"`
+	}
+	// RateLimit policies: each group of routes allows Burst requests at once, then Requests every Period, per user
+	// and per client IP
+	RateLimit struct {
+		Enabled bool `conf:"default:true"`
+		Default struct {
+			Requests int           `conf:"default:600"`
+			Period   time.Duration `conf:"default:1m"`
+			Burst    int           `conf:"default:100"`
+		}
+		Login struct {
+			Requests int           `conf:"default:10"`
+			Period   time.Duration `conf:"default:1m"`
+			Burst    int           `conf:"default:5"`
+		}
+		Upload struct {
+			Requests int           `conf:"default:30"`
+			Period   time.Duration `conf:"default:1h"`
+			Burst    int           `conf:"default:10"`
+		}
+		Comments struct {
+			Requests int           `conf:"default:30"`
+			Period   time.Duration `conf:"default:1m"`
+			Burst    int           `conf:"default:10"`
+		}
+	}
+	Auth struct {
+		// Mode is "username" (accounts are created on the first login, no credentials: for demos) or "password"
+		Mode string `conf:"default:username"`
+		// Secret signs the session tokens. If empty, a random one is generated at startup (sessions will not survive
+		// a restart).
+		Secret     string        `conf:"mask"`
+		SessionTTL time.Duration `conf:"default:720h"`
+		// OIDC enables the OpenID Connect login when Issuer is set
+		OIDC struct {
+			Issuer       string
+			ClientID     string
+			ClientSecret string `conf:"mask"`
+			RedirectURL  string
+			Scopes       []string `conf:"default:profile;email"`
+			UIRedirect   string
+		}
+	}
+	// Tracing exports a span for each request, call to the database and SQL statement. Exporter is "none", "stdout",
+	// "file" (JSON lines appended to File) or "otlp" (OTLP over HTTP to OTLPEndpoint, sending OTLPHeaders given as
+	// "name=value"). SampleRatio is the fraction of the traces started here that are recorded: requests with a
+	// traceparent header follow the decision of the caller.
+	Tracing struct {
+		Exporter     string   `conf:"default:none"`
+		File         string   `conf:"default:/tmp/wasaphoto-traces.jsonl"`
+		OTLPEndpoint string   `conf:"default:http://localhost:4318/v1/traces"`
+		OTLPHeaders  []string `conf:"mask"`
+		SampleRatio  float64  `conf:"default:1"`
+		ServiceName  string   `conf:"default:wasaphoto"`
+	}
 }
 
 // loadConfiguration creates a WebAPIConfiguration starting from flags, environment variables and configuration file.
@@ -2,7 +2,9 @@
 Webapi is the executable for the main web server.
 It builds a web server around APIs from `service/api`.
 Webapi connects to external resources needed (database) and starts two web servers: the API web server, and the debug.
-Everything is served via the API web server, except debug variables (/debug/vars) and profiler infos (pprof).
+Everything is served via the API web server, except debug variables (/debug/vars), profiler infos (pprof) and the
+metrics (/metrics), which are on the debug web server.
+With a TLS certificate configured, the API web server serves HTTPS, and a third server can redirect plain HTTP to it.
 
 Usage:
 
@@ -25,18 +27,26 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"fmt"
-	"math/rand"
+	"io"
+	mathrand "math/rand"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"wasaPhoto/service/api"
 	"wasaPhoto/service/database"
 	"wasaPhoto/service/globaltime"
+	"wasaPhoto/service/metrics"
+	"wasaPhoto/service/oidc"
+	"wasaPhoto/service/ratelimit"
+	"wasaPhoto/service/storage"
+	"wasaPhoto/service/usernames"
 
 	"github.com/ardanlabs/conf"
 	_ "github.com/mattn/go-sqlite3"
@@ -57,11 +67,12 @@ func main() {
 // * creates and configure the logger
 // * connects to any external resources (like databases, authenticators, etc.)
 // * creates an instance of the service/api package
-// * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers)
+// * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers), with TLS if configured
+// * starts the debug web server, unless Web.DebugHost is empty
 // * waits for any termination event: SIGTERM signal (UNIX), non-recoverable server error, etc.
-// * closes the principal web server
+// * closes the principal and the debug web servers
 func run() error {
-	rand.Seed(globaltime.Now().UnixNano())
+	mathrand.Seed(globaltime.Now().UnixNano())
 	// Load Configuration and defaults
 	cfg, err := loadConfiguration()
 	if err != nil {
@@ -82,6 +93,24 @@ func run() error {
 
 	logger.Infof("application initializing")
 
+	// Tracing of the requests, the database calls and the SQL statements
+	tracer, err := newTracer(cfg, logger)
+	if err != nil {
+		logger.WithError(err).Error("error configuring tracing")
+		return fmt.Errorf("configuring tracing: %w", err)
+	}
+	if tracer != nil {
+		logger.Infof("tracing enabled, exporting to %s", cfg.Tracing.Exporter)
+		defer func() {
+			// export the spans of the last requests
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+			defer cancel()
+			if err := tracer.Shutdown(ctx); err != nil {
+				logger.WithError(err).Warning("error exporting the last spans")
+			}
+		}()
+	}
+
 	// Start Database
 	logger.Println("initializing database support")
 	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
@@ -93,12 +122,79 @@ func run() error {
 		logger.Debug("database stopping")
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+	var statements database.Observer
+	if tracer != nil {
+		statements = traceStatement
+	}
+	db, err := database.NewObserved(dbconn, statements)
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
 	}
 
+	// Storage of the images
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		logger.WithError(err).Error("error opening the image storage")
+		return fmt.Errorf("opening the image storage: %w", err)
+	}
+	if cfg.Storage.Backend == storage.BackendMemory {
+		logger.Warning("images are stored in memory: they will be lost on restart")
+	}
+
+	// Session tokens are signed with this secret
+	sessionSecret := []byte(cfg.Auth.Secret)
+	if len(sessionSecret) == 0 {
+		logger.Warning("no session secret configured, generating a random one: sessions will be lost on restart")
+		sessionSecret = make([]byte, 32)
+		if _, err := rand.Read(sessionSecret); err != nil {
+			return fmt.Errorf("generating session secret: %w", err)
+		}
+	}
+
+	// Discover the OpenID Connect provider, if configured
+	var oidcProvider *oidc.Provider
+	if cfg.Auth.OIDC.Issuer != "" {
+		logger.Infof("discovering OpenID Connect provider %s", cfg.Auth.OIDC.Issuer)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		oidcProvider, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.Auth.OIDC.Issuer,
+			ClientID:     cfg.Auth.OIDC.ClientID,
+			ClientSecret: cfg.Auth.OIDC.ClientSecret,
+			RedirectURL:  cfg.Auth.OIDC.RedirectURL,
+			Scopes:       cfg.Auth.OIDC.Scopes,
+		})
+		cancel()
+		if err != nil {
+			logger.WithError(err).Error("error discovering the OpenID Connect provider")
+			return fmt.Errorf("discovering OpenID Connect provider: %w", err)
+		}
+	}
+
+	usernamePolicy, err := usernames.NewPolicy(cfg.Usernames.MinLength, cfg.Usernames.MaxLength, cfg.Usernames.Pattern, cfg.Usernames.Reserved)
+	if err != nil {
+		logger.WithError(err).Error("error configuring the username policy")
+		return fmt.Errorf("configuring the username policy: %w", err)
+	}
+
+	// Rate limiting, with counters kept in memory
+	var rateLimiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		rateLimiter, err = ratelimit.New(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+			api.RateLimitDefault:  ratelimit.Limit(cfg.RateLimit.Default),
+			api.RateLimitLogin:    ratelimit.Limit(cfg.RateLimit.Login),
+			api.RateLimitUpload:   ratelimit.Limit(cfg.RateLimit.Upload),
+			api.RateLimitComments: ratelimit.Limit(cfg.RateLimit.Comments),
+		})
+		if err != nil {
+			logger.WithError(err).Error("error configuring rate limits")
+			return fmt.Errorf("configuring rate limits: %w", err)
+		}
+		defer func() {
+			_ = rateLimiter.Close()
+		}()
+	}
+
 	// Start (main) API server
 	logger.Info("initializing API server")
 
@@ -107,14 +203,54 @@ func run() error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	// Make a channel to listen for errors coming from the listeners (API, HTTPS redirect and debug). Use a
+	// buffered channel so the goroutines can exit if we don't collect these errors.
+	serverErrors := make(chan error, 3)
+
+	// Access log, on the standard output or in its own file
+	var accessLog io.Writer
+	if cfg.AccessLog.Format != "none" {
+		accessLog = os.Stdout
+		if cfg.AccessLog.File != "" {
+			f, err := os.OpenFile(cfg.AccessLog.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				logger.WithError(err).Error("error opening the access log")
+				return fmt.Errorf("opening the access log: %w", err)
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			accessLog = f
+		}
+	}
+
+	// Metrics of the API, served by the debug server
+	metricsRegistry := metrics.NewRegistry()
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:               logger,
+		Database:             db,
+		Storage:              blobs,
+		SessionSecret:        sessionSecret,
+		SessionTTL:           cfg.Auth.SessionTTL,
+		AuthMode:             cfg.Auth.Mode,
+		OIDC:                 oidcProvider,
+		OIDCUIRedirect:       cfg.Auth.OIDC.UIRedirect,
+		RateLimiter:          rateLimiter,
+		BehindProxy:          cfg.Web.BehindProxy,
+		RequestTimeout:       cfg.Web.WriteTimeout,
+		DeletionGracePeriod:  cfg.Accounts.DeletionGracePeriod,
+		PhotoMaxSize:         cfg.Photos.MaxSize,
+		PhotoMaxPixels:       cfg.Photos.MaxPixels,
+		ExportDir:            cfg.Exports.Directory,
+		ExportTTL:            cfg.Exports.TTL,
+		UsernamePolicy:       usernamePolicy,
+		Metrics:              metricsRegistry,
+		Tracer:               tracer,
+		AccessLog:            accessLog,
+		AccessLogFormat:      cfg.AccessLog.Format,
+		AccessLogSampleRatio: cfg.AccessLog.SampleRatio,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -129,7 +265,16 @@ func run() error {
 	}
 
 	// Apply CORS policy
-	router = applyCORSHandler(router)
+	router, err = applyCORSHandler(cfg, router)
+	if err != nil {
+		logger.WithError(err).Error("error configuring the CORS policy")
+		return fmt.Errorf("configuring the CORS policy: %w", err)
+	}
+	if cfg.CORS.Profile == corsDevelopment {
+		logger.Warning("CORS development profile: every origin is allowed, don't use it in production")
+	} else if len(cfg.CORS.AllowedOrigins) == 0 {
+		logger.Info("no CORS origin allowed: the web UI must be served from the same origin as the API")
+	}
 
 	// Create the API server
 	apiserver := http.Server{
@@ -140,12 +285,75 @@ func run() error {
 		WriteTimeout:      cfg.Web.WriteTimeout,
 	}
 
+	// Serve HTTPS directly if a certificate is configured, reloading it when it's renewed, and optionally redirect
+	// plain HTTP to it
+	var redirectserver *http.Server
+	if cfg.TLS.CertFile != "" {
+		if cfg.TLS.KeyFile == "" {
+			return errors.New("configuring TLS: the key file is required with the certificate file")
+		}
+		reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, logger)
+		if err != nil {
+			logger.WithError(err).Error("error loading the TLS certificate")
+			return fmt.Errorf("loading the TLS certificate: %w", err)
+		}
+		apiserver.TLSConfig, err = newTLSConfig(cfg, reloader)
+		if err != nil {
+			logger.WithError(err).Error("error configuring TLS")
+			return fmt.Errorf("configuring TLS: %w", err)
+		}
+		stopWatching := make(chan struct{})
+		defer close(stopWatching)
+		go reloader.watch(cfg.TLS.ReloadInterval, stopWatching)
+
+		if cfg.TLS.RedirectHost != "" {
+			redirect, err := redirectToHTTPS(cfg.Web.APIHost)
+			if err != nil {
+				return fmt.Errorf("configuring the HTTPS redirect: %w", err)
+			}
+			redirectserver = &http.Server{
+				Addr:              cfg.TLS.RedirectHost,
+				Handler:           redirect,
+				ReadTimeout:       cfg.Web.ReadTimeout,
+				ReadHeaderTimeout: cfg.Web.ReadTimeout,
+				WriteTimeout:      cfg.Web.WriteTimeout,
+			}
+		}
+	}
+
 	// Start the service listening for requests in a separate goroutine
 	go func() {
-		logger.Infof("API listening on %s", apiserver.Addr)
-		serverErrors <- apiserver.ListenAndServe()
+		if apiserver.TLSConfig != nil {
+			logger.Infof("API listening on %s with TLS", apiserver.Addr)
+			serverErrors <- apiserver.ListenAndServeTLS("", "")
+		} else {
+			logger.Infof("API listening on %s", apiserver.Addr)
+			serverErrors <- apiserver.ListenAndServe()
+		}
 		logger.Infof("stopping API server")
 	}()
+	if redirectserver != nil {
+		go func() {
+			logger.Infof("redirecting HTTP on %s to HTTPS", redirectserver.Addr)
+			serverErrors <- redirectserver.ListenAndServe()
+			logger.Infof("stopping HTTPS redirect server")
+		}()
+	}
+
+	// Start the debug server, without write timeout because profiles last longer than a request
+	var debugserver *http.Server
+	if cfg.Web.DebugHost != "" {
+		debugserver = &http.Server{
+			Addr:              cfg.Web.DebugHost,
+			Handler:           debugHandler(cfg, logger, metricsRegistry),
+			ReadHeaderTimeout: cfg.Web.ReadTimeout,
+		}
+		go func() {
+			logger.Infof("debug server listening on %s", debugserver.Addr)
+			serverErrors <- debugserver.ListenAndServe()
+			logger.Infof("stopping debug server")
+		}()
+	}
 
 	// Waiting for shutdown signal or POSIX signals
 	select {
@@ -173,6 +381,20 @@ func run() error {
 			err = apiserver.Close()
 		}
 
+		if redirectserver != nil {
+			if err := redirectserver.Shutdown(ctx); err != nil {
+				_ = redirectserver.Close()
+			}
+		}
+
+		// The debug server has nothing to complete: a running profile is cut at the deadline
+		if debugserver != nil {
+			if err := debugserver.Shutdown(ctx); err != nil {
+				logger.WithError(err).Warning("error during graceful shutdown of the debug server")
+				_ = debugserver.Close()
+			}
+		}
+
 		// Log the status of this shutdown.
 		switch {
 		case sig == syscall.SIGQUIT:
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"wasaPhoto/service/storage"
+)
+
+// newBlobStore creates the store of the images of the configured backend
+func newBlobStore(cfg WebAPIConfiguration) (storage.BlobStore, error) {
+	return storage.Open(storage.Config{
+		Backend:   cfg.Storage.Backend,
+		Directory: cfg.Storage.Directory,
+		S3: storage.S3Config{
+			Endpoint:  cfg.Storage.S3.Endpoint,
+			Region:    cfg.Storage.S3.Region,
+			Bucket:    cfg.Storage.S3.Bucket,
+			AccessKey: cfg.Storage.S3.AccessKey,
+			SecretKey: cfg.Storage.S3.SecretKey,
+			PathStyle: cfg.Storage.S3.PathStyle,
+			Timeout:   cfg.Storage.S3.Timeout,
+		},
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tlsVersions are the accepted values of TLS.MinVersion
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig returns the TLS configuration of the API server, whose certificate is read from the files by reloader
+func newTLSConfig(cfg WebAPIConfiguration, reloader *certReloader) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[cfg.TLS.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown minimum TLS version %q, use 1.2 or 1.3", cfg.TLS.MinVersion)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.getCertificate,
+	}
+	if len(cfg.TLS.CipherSuites) > 0 {
+		// only the secure suites can be chosen; TLS 1.3 suites are not configurable
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range cfg.TLS.CipherSuites {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// certReloader keeps the certificate loaded from the certificate and key files, and loads them again when they
+// change. If the new files can't be loaded (e.g., only one of them was replaced yet), the previous certificate is kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   logrus.FieldLogger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the certificate, which must be valid
+func newCertReloader(certFile string, keyFile string, logger logrus.FieldLogger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// lastModified returns the latest modification time of the two files
+func (cr *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the certificate from the files
+func (cr *certReloader) reload() error {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// watch reloads the certificate on SIGHUP, and when the files change (checked every interval, if positive), until
+// done is closed
+func (cr *certReloader) watch(interval time.Duration, done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// seen is the modification time of the files at the last check, so that files that can't be loaded are tried
+	// once per change
+	cr.mu.RLock()
+	seen := cr.modTime
+	cr.mu.RUnlock()
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			cr.reloadAndLog("SIGHUP received")
+		case <-tick:
+			modTime, err := cr.lastModified()
+			if err == nil && !modTime.Equal(seen) {
+				seen = modTime
+				cr.reloadAndLog("certificate files changed")
+			}
+		}
+	}
+}
+
+func (cr *certReloader) reloadAndLog(reason string) {
+	if err := cr.reload(); err != nil {
+		cr.logger.WithError(err).Errorf("%s, but the certificate can't be loaded: keeping the previous one", reason)
+		return
+	}
+	cr.logger.Infof("%s, certificate reloaded", reason)
+}
+
+// redirectToHTTPS answers every request with a permanent redirect to the same URL on the HTTPS server, which listens
+// on apiHost. The host of the redirect is the one of apiHost, never the Host header of the request, which the client
+// chooses; only when apiHost listens on every interface (e.g. 0.0.0.0:443), and so has no name, the host of the request
+// is kept.
+func redirectToHTTPS(apiHost string) (http.Handler, error) {
+	host, port, err := net.SplitHostPort(apiHost)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the API address: %w", err)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := host
+		if target == "" {
+			target = r.Host
+			if h, _, err := net.SplitHostPort(target); err == nil {
+				target = h
+			}
+		}
+		if port != "443" {
+			target = net.JoinHostPort(target, port)
+		} else if strings.Contains(target, ":") {
+			// an IPv6 address
+			target = "[" + target + "]"
+		}
+		http.Redirect(w, r, "https://"+target+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}), nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// writeCertificate writes a self-signed certificate for the common name and its key to the files
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commonName returns the common name of the certificate served by the reloader
+func commonName(t *testing.T, cr *certReloader) string {
+	t.Helper()
+	cert, err := cr.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	if _, err := newCertReloader(certFile, keyFile, logger); err == nil {
+		t.Error("no error without the files")
+	}
+	writeCertificate(t, certFile, keyFile, "first.example.com")
+	cr, err := newCertReloader(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := commonName(t, cr); name != "first.example.com" {
+		t.Errorf("certificate of %s", name)
+	}
+
+	writeCertificate(t, certFile, keyFile, "second.example.com")
+	if err := cr.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := commonName(t, cr); name != "second.example.com" {
+		t.Errorf("certificate of %s after the reload", name)
+	}
+
+	// only the certificate is replaced: the pair doesn't match, and the previous certificate is kept
+	otherDir := t.TempDir()
+	writeCertificate(t, filepath.Join(otherDir, "cert.pem"), filepath.Join(otherDir, "key.pem"), "third.example.com")
+	cert, err := os.ReadFile(filepath.Join(otherDir, "cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.reload(); err == nil {
+		t.Error("no error with a certificate that doesn't match the key")
+	}
+	if name := commonName(t, cr); name != "second.example.com" {
+		t.Errorf("certificate of %s after a failed reload", name)
+	}
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cr.reloadAndLog("test")
+	if name := commonName(t, cr); name != "second.example.com" {
+		t.Errorf("certificate of %s after a failed reload", name)
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	writeCertificate(t, certFile, keyFile, "first.example.com")
+	cr, err := newCertReloader(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go cr.watch(10*time.Millisecond, done)
+
+	writeCertificate(t, certFile, keyFile, "second.example.com")
+	// the modification time can have a coarse resolution
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for commonName(t, cr) != "second.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("the changed files were not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		apiHost string
+		host    string
+		target  string
+		want    string
+	}{
+		{"photos.example.com:443", "photos.example.com", "/photos?page=2&q=a%20b", "https://photos.example.com/photos?page=2&q=a%20b"},
+		{"photos.example.com:443", "evil.example.org", "/", "https://photos.example.com/"},
+		{"photos.example.com:8443", "evil.example.org:80", "/users/1", "https://photos.example.com:8443/users/1"},
+		{"192.0.2.1:443", "evil.example.org", "/", "https://192.0.2.1/"},
+		{"[2001:db8::1]:443", "evil.example.org", "/", "https://[2001:db8::1]/"},
+		{"0.0.0.0:443", "photos.example.com:80", "/photos", "https://photos.example.com/photos"},
+		{"0.0.0.0:8443", "photos.example.com", "/photos", "https://photos.example.com:8443/photos"},
+		{":443", "photos.example.com", "/", "https://photos.example.com/"},
+	}
+	for _, tt := range tests {
+		redirect, err := redirectToHTTPS(tt.apiHost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		redirect.ServeHTTP(w, r)
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.want {
+			t.Errorf("%s, Host %s, %s: status %d, Location %q, want %q", tt.apiHost, tt.host, tt.target, w.Code,
+				w.Header().Get("Location"), tt.want)
+		}
+	}
+
+	if _, err := redirectToHTTPS("photos.example.com"); err == nil {
+		t.Error("no error without the port")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"wasaPhoto/service/tracing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// otlpTimeout is the timeout of each request to the OTLP collector
+const otlpTimeout = 10 * time.Second
+
+// newTracer creates the tracer of the configured exporter, or returns nil if tracing is disabled
+func newTracer(cfg WebAPIConfiguration, logger logrus.FieldLogger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		exporter = tracing.NewStdoutExporter()
+	case "file":
+		fe, err := tracing.NewFileExporter(cfg.Tracing.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fe
+	case "otlp":
+		headers := make(map[string]string, len(cfg.Tracing.OTLPHeaders))
+		for i, header := range cfg.Tracing.OTLPHeaders {
+			name, value, found := strings.Cut(header, "=")
+			if !found {
+				// the header is not printed: it's likely a credential, like an API key without its name
+				return nil, fmt.Errorf("OTLP header #%d is not in the name=value format", i+1)
+			}
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+		exporter = tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint, headers, otlpTimeout)
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", cfg.Tracing.Exporter)
+	}
+	return tracing.New(tracing.Config{
+		ServiceName: cfg.Tracing.ServiceName,
+		Exporter:    exporter,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Logger:      logger,
+	})
+}
+
+// traceStatement starts a span for each SQL statement, named after its first keyword (SELECT, INSERT...)
+func traceStatement(ctx context.Context, query string) (context.Context, func(error)) {
+	operation := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	ctx, span := tracing.StartChild(ctx, operation, tracing.KindClient)
+	span.SetAttribute("db.system", "sqlite")
+	span.SetAttribute("db.statement", strings.Join(strings.Fields(query), " "))
+	return ctx, func(err error) {
+		span.SetError(err)
+		span.End()
+	}
+}
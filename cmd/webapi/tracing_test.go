@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewTracerOTLPHeaders(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	var cfg WebAPIConfiguration
+	cfg.Tracing.Exporter = "otlp"
+	cfg.Tracing.OTLPEndpoint = "http://localhost:4318/v1/traces"
+	cfg.Tracing.SampleRatio = 1
+	cfg.Tracing.ServiceName = "wasaphoto"
+
+	cfg.Tracing.OTLPHeaders = []string{"x-tenant=photos", "Bearer SECRET-TOKEN"}
+	_, err := newTracer(cfg, logger)
+	if err == nil || !strings.Contains(err.Error(), "#2") || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("error %v", err)
+	}
+
+	cfg.Tracing.OTLPHeaders = []string{"x-tenant=photos", "Authorization=Bearer SECRET-TOKEN"}
+	tracer, err := newTracer(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
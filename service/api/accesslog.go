@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// AccessLogCombined is the Apache combined log format, followed by the route pattern, the duration in
+	// milliseconds and the request ID
+	AccessLogCombined = "combined"
+
+	// AccessLogJSON writes a JSON object per line
+	AccessLogJSON = "json"
+)
+
+// accessLogger writes a line per request. The successful requests are logged with probability sampleRatio, the
+// failed ones (status 400 and more) always.
+type accessLogger struct {
+	mu          sync.Mutex
+	w           io.Writer
+	format      string
+	sampleRatio float64
+}
+
+// accessEntry is a line of the access log in the JSON format. The query string is not logged, since it can carry
+// secrets like the download token of an export.
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Route      string    `json:"route"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"durationMs"`
+	UserID     int       `json:"userId,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	RemoteIP   string    `json:"remoteIp"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+}
+
+type statusWriterKey struct{}
+
+// requestStatus returns the statusWriter of the request, to record the request ID and the caller once known. It's nil
+// if neither the metrics nor the access log are enabled.
+func requestStatus(ctx context.Context) *statusWriter {
+	sw, _ := ctx.Value(statusWriterKey{}).(*statusWriter)
+	return sw
+}
+
+// logAccess writes the line of a request served by instrumented
+func (rt *_router) logAccess(r *http.Request, sw *statusWriter, start time.Time, route string, status int) {
+	l := rt.accessLog
+	if status < http.StatusBadRequest && l.sampleRatio < 1 && rand.Float64() >= l.sampleRatio {
+		return
+	}
+	entry := accessEntry{
+		Time:       start.UTC(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Route:      route,
+		Status:     status,
+		Bytes:      sw.bytes,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		UserID:     sw.userID,
+		RequestID:  sw.requestID,
+		RemoteIP:   rt.clientIP(r),
+		UserAgent:  r.UserAgent(),
+		Referer:    r.Referer(),
+	}
+
+	var line bytes.Buffer
+	if l.format == AccessLogJSON {
+		if err := json.NewEncoder(&line).Encode(entry); err != nil {
+			rt.baseLogger.WithError(err).Warning("can't encode the access log entry")
+			return
+		}
+	} else {
+		user := "-"
+		if entry.UserID != 0 {
+			user = strconv.Itoa(entry.UserID)
+		}
+		requestID := entry.RequestID
+		if requestID == "" {
+			requestID = "-"
+		}
+		_, _ = fmt.Fprintf(&line, "%s - %s [%s] %q %d %d %q %q %q %.3f %s\n",
+			entry.RemoteIP, user, entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			entry.Method+" "+entry.Path+" "+r.Proto, entry.Status, entry.Bytes,
+			orDash(entry.Referer), orDash(entry.UserAgent), entry.Route, entry.DurationMs, requestID)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(line.Bytes()); err != nil {
+		rt.baseLogger.WithError(err).Warning("can't write the access log")
+	}
+}
+
+// orDash returns s, or "-" if empty, like the missing fields of the combined format
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
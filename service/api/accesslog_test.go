@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// accessLogLines returns the lines written to the access log, and empties it
+func accessLogLines(buf *bytes.Buffer) []string {
+	var lines []string
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	buf.Reset()
+	return lines
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	h := newTestRouter(t, func(cfg *Config) {
+		cfg.AccessLog = &buf
+		cfg.AccessLogFormat = AccessLogJSON
+		cfg.AccessLogSampleRatio = 1
+	}).Handler()
+	userID, token := login(t, h, "alice")
+	buf.Reset()
+
+	w := do(t, h, http.MethodGet, "/users/"+strconv.Itoa(userID), token, nil)
+	lines := accessLogLines(&buf)
+	if len(lines) != 1 {
+		t.Fatalf("%d lines: %q", len(lines), lines)
+	}
+	var entry accessEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Method != http.MethodGet || entry.Path != "/users/"+strconv.Itoa(userID) || entry.Route != "/users/:id" ||
+		entry.Status != w.Code || entry.Bytes != int64(w.Body.Len()) || entry.UserID != userID ||
+		entry.RequestID == "" || entry.RequestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("entry %+v, response %d of %d bytes, request ID %s", entry, w.Code, w.Body.Len(), w.Header().Get("X-Request-ID"))
+	}
+
+	// failed requests, with the status of the problem
+	w = do(t, h, http.MethodGet, "/users/999", token, nil)
+	lines = accessLogLines(&buf)
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &entry) != nil || entry.Status != http.StatusNotFound ||
+		entry.Bytes != int64(w.Body.Len()) {
+		t.Errorf("lines %q of a response %d of %d bytes", lines, w.Code, w.Body.Len())
+	}
+}
+
+func TestAccessLogSecrets(t *testing.T) {
+	for _, format := range []string{AccessLogJSON, AccessLogCombined} {
+		var buf bytes.Buffer
+		h := newTestRouter(t, func(cfg *Config) {
+			cfg.AccessLog = &buf
+			cfg.AccessLogFormat = format
+			cfg.AccessLogSampleRatio = 1
+		}).Handler()
+		userID, token := login(t, h, "alice")
+
+		do(t, h, http.MethodGet, "/users/"+strconv.Itoa(userID), token, nil)
+		do(t, h, http.MethodGet, "/users/"+strconv.Itoa(userID), "not-a-valid-token", nil)
+		w := do(t, h, http.MethodGet, "/exports/00000000-0000-4000-8000-000000000000?token=EXPORT-SECRET", "", nil)
+		if w.Code < http.StatusBadRequest {
+			t.Fatalf("%s: download without export: status %d", format, w.Code)
+		}
+
+		log := buf.String()
+		if n := strings.Count(log, "\n"); n != 4 {
+			t.Errorf("%s: %d lines: %s", format, n, log)
+		}
+		for _, secret := range []string{token, "not-a-valid-token", "Bearer", "EXPORT-SECRET", "token="} {
+			if strings.Contains(log, secret) {
+				t.Errorf("%s: %q logged: %s", format, secret, log)
+			}
+		}
+		if !strings.Contains(log, "/exports/00000000-0000-4000-8000-000000000000") {
+			t.Errorf("%s: path of the download not logged: %s", format, log)
+		}
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	var buf bytes.Buffer
+	h := newTestRouter(t, func(cfg *Config) {
+		cfg.AccessLog = &buf
+		cfg.AccessLogFormat = AccessLogCombined
+		cfg.AccessLogSampleRatio = 1
+	}).Handler()
+	userID, token := login(t, h, "alice")
+	buf.Reset()
+
+	w := do(t, h, http.MethodGet, "/users/"+strconv.Itoa(userID)+"/followers", token, nil)
+	lines := accessLogLines(&buf)
+	if len(lines) != 1 {
+		t.Fatalf("%d lines: %q", len(lines), lines)
+	}
+	// 192.0.2.1 - 1 [02/Jan/2006:15:04:05 +0000] "GET /users/1/followers HTTP/1.1" 200 3 "-" "-" "/users/:id/followers" 0.123 <request ID>
+	want := []string{
+		" - " + strconv.Itoa(userID) + " [",
+		`] "GET /users/` + strconv.Itoa(userID) + `/followers HTTP/1.1" ` + strconv.Itoa(w.Code) + " " + strconv.Itoa(w.Body.Len()) + ` "-" "-" "/users/:id/followers" `,
+	}
+	for _, part := range want {
+		if !strings.Contains(lines[0], part) {
+			t.Errorf("line %q without %q", lines[0], part)
+		}
+	}
+	if !strings.HasSuffix(lines[0], " "+w.Header().Get("X-Request-ID")) {
+		t.Errorf("line %q without the request ID %s", lines[0], w.Header().Get("X-Request-ID"))
+	}
+}
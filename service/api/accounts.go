@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// accountDeletion is the body returned by DELETE /users/:id when the account is only deactivated
+type accountDeletion struct {
+	Status  string
+	PurgeAt time.Time
+}
+
+// deleteUserHandler deletes the account of the caller. With a grace period the account is deactivated instead: it
+// disappears for the other users, every session and API key is revoked, and logging in again restores it.
+func (rt *_router) deleteUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	userID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if myID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't delete another user")
+		return
+	}
+	if rt.deletionGracePeriod == 0 {
+		output, err := rt.deleteAccount(ctx.Context, ctx.DB, ctx.Logger, userID)
+		finalize(ctx, output, err, w, 200)
+		return
+	}
+	deactivatedAt, err := ctx.DB.DeactivateUser(userID)
+	finalize(ctx, accountDeletion{
+		Status:  database.DEACTIVATED,
+		PurgeAt: deactivatedAt.Add(rt.deletionGracePeriod),
+	}, err, w, 202)
+}
+
+// deleteAccount deletes the user, then the images of its photos and their derivatives from the storage: an image that
+// can't be deleted is only logged, as the account is already gone
+func (rt *_router) deleteAccount(ctx context.Context, db database.AppDatabase, logger logrus.FieldLogger, userID int) (database.Status, error) {
+	keys, err := db.GetPhotoKeys(userID)
+	if err != nil {
+		return database.Status{}, err
+	}
+	status, err := db.DeleteUser(userID)
+	if err != nil {
+		return status, err
+	}
+	for _, key := range keys {
+		rt.deleteImages(ctx, logger, key)
+	}
+	return status, nil
+}
+
+// restoreUser cancels the deletion of an account deactivated during the grace period. It's called on every login.
+func (rt *_router) restoreUser(ctx reqcontext.RequestContext, userID int) error {
+	deactivatedAt, err := ctx.DB.GetDeactivatedAt(userID)
+	if err != nil || deactivatedAt.IsZero() {
+		return err
+	}
+	_, err = ctx.DB.RestoreUser(userID)
+	if err == nil {
+		ctx.Logger.WithField("userid", userID).Info("deactivated account restored by login")
+	}
+	return err
+}
+
+// purgeDeactivatedUsers deletes the accounts deactivated for longer than the grace period
+func (rt *_router) purgeDeactivatedUsers() {
+	users, err := rt.db.GetUsersDeactivatedBefore(globaltime.Now().Add(-rt.deletionGracePeriod))
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't list the accounts to delete")
+		return
+	}
+	for _, user := range users {
+		if _, err := rt.deleteAccount(context.Background(), rt.db, rt.baseLogger, user.ID); err != nil {
+			rt.baseLogger.WithError(err).WithField("userid", user.ID).Error("can't delete a deactivated account")
+			continue
+		}
+		rt.baseLogger.WithField("userid", user.ID).Info("deactivated account deleted at the end of the grace period")
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+	"wasaPhoto/service/database"
+)
+
+func TestDeactivateUser(t *testing.T) {
+	rt := newTestRouter(t, func(cfg *Config) { cfg.DeletionGracePeriod = time.Hour })
+	h := rt.Handler()
+	aliceID, aliceToken := login(t, h, "alice")
+	_, bobToken := login(t, h, "bob")
+	profile := "/users/" + strconv.Itoa(aliceID)
+
+	if w := do(t, h, http.MethodDelete, profile, bobToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("deletion of another user: status %d, want 403", w.Code)
+	}
+	if w := do(t, h, http.MethodDelete, profile, aliceToken, nil); w.Code != http.StatusAccepted {
+		t.Fatalf("deactivation: status %d", w.Code)
+	}
+	if w := do(t, h, http.MethodGet, profile, aliceToken, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("session of the deactivated user: status %d, want 401", w.Code)
+	}
+	if w := do(t, h, http.MethodGet, profile, bobToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("profile of the deactivated user: status %d, want 404", w.Code)
+	}
+
+	// logging in again during the grace period restores the account
+	if id, _ := login(t, h, "alice"); id != aliceID {
+		t.Fatalf("login after the deactivation: user %d, want %d", id, aliceID)
+	}
+	if w := do(t, h, http.MethodGet, profile, bobToken, nil); w.Code != http.StatusOK {
+		t.Errorf("profile of the restored user: status %d, want 200", w.Code)
+	}
+}
+
+func TestDeactivatedUserContent(t *testing.T) {
+	var photo database.Photo
+	rt := newTestRouter(t, func(cfg *Config) {
+		cfg.DeletionGracePeriod = time.Hour
+		photo = addPhoto(t, cfg.Database, "alice")
+	})
+	h := rt.Handler()
+	aliceID, aliceToken := login(t, h, "alice")
+	bobID, bobToken := login(t, h, "bob")
+	carolID, carolToken := login(t, h, "carol")
+	for _, userID := range []int{bobID, carolID} {
+		if _, err := rt.db.AddComment(photo.ID, userID, "nice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rt.db.AddLike(photo.ID, bobID); err != nil {
+		t.Fatal(err)
+	}
+	photoPath := "/photos/" + strconv.Itoa(photo.ID)
+	photosPath := "/users/" + strconv.Itoa(aliceID) + "/photos"
+
+	tests := []struct {
+		name        string
+		deactivated int
+		token       string
+		status      int
+		comments    int
+		likes       int
+	}{
+		{"the commenter is deactivated", bobID, bobToken, http.StatusOK, 1, 0},
+		{"the owner is deactivated", aliceID, aliceToken, http.StatusNotFound, 0, 0},
+	}
+	for _, tt := range tests {
+		if w := do(t, h, http.MethodDelete, "/users/"+strconv.Itoa(tt.deactivated), tt.token, nil); w.Code != http.StatusAccepted {
+			t.Fatalf("%s: deactivation status %d", tt.name, w.Code)
+		}
+		for _, target := range []string{photoPath + "/comments", photoPath + "/derivatives", photosPath} {
+			w := do(t, h, http.MethodGet, target, carolToken, nil)
+			if w.Code != tt.status {
+				t.Errorf("%s: GET %s status %d, want %d", tt.name, target, w.Code, tt.status)
+				continue
+			}
+			if tt.status != http.StatusOK {
+				continue
+			}
+			switch target {
+			case photoPath + "/comments":
+				var comments database.JsonificaComments
+				if err := json.NewDecoder(w.Body).Decode(&comments); err != nil || len(comments.Items) != tt.comments {
+					t.Errorf("%s: comments %+v, %v", tt.name, comments.Items, err)
+				}
+			case photosPath:
+				var photos database.JsonificaPhotos
+				err := json.NewDecoder(w.Body).Decode(&photos)
+				if err != nil || len(photos.Items) != 1 || photos.Items[0].Comments != tt.comments || photos.Items[0].Likes != tt.likes {
+					t.Errorf("%s: photos %+v, %v", tt.name, photos.Items, err)
+				}
+			}
+		}
+	}
+}
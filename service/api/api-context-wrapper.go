@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/globaltime"
+	"wasaPhoto/service/tracing"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// httpRouterHandler is the signature for functions that accepts a reqcontext.RequestContext in addition to those
+// required by the httprouter package.
+type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext)
+
+// responseWriter records whether the response was started, and logs the write errors with the request logger
+type responseWriter struct {
+	http.ResponseWriter
+	logger      logrus.FieldLogger
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	if err != nil {
+		w.logger.WithError(err).Debug("write failed")
+	}
+	return n, err
+}
+
+// wrap parses the request and adds a reqcontext.RequestContext instance related to the request. The request ID is sent
+// back in the X-Request-ID header; behind a reverse proxy, the ID set by the proxy is kept. A panic in the handler is
+// logged and answered with a 500 response.
+func (rt *_router) wrap(fn httpRouterHandler) httprouter.Handle {
+	return rt.wrapWithTimeout(fn, rt.requestTimeout)
+}
+
+// wrapWithTimeout is wrap with another request deadline, zero means none: for the downloads, which can take much
+// longer than the other requests
+func (rt *_router) wrapWithTimeout(fn httpRouterHandler, timeout time.Duration) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		reqUUID, err := uuid.FromString(r.Header.Get("X-Request-ID"))
+		if !rt.behindProxy || err != nil {
+			reqUUID, err = uuid.NewV4()
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("can't generate a request UUID")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		var ctx = reqcontext.RequestContext{
+			ReqUUID: reqUUID,
+		}
+		w.Header().Set("X-Request-ID", reqUUID.String())
+		if sw := requestStatus(r.Context()); sw != nil {
+			sw.requestID = reqUUID.String()
+		}
+
+		// Create a request-specific logger
+		ctx.Logger = rt.baseLogger.WithFields(logrus.Fields{
+			"reqid":     ctx.ReqUUID.String(),
+			"remote-ip": rt.clientIP(r),
+		})
+
+		if timeout > 0 {
+			ctx.Deadline = globaltime.Now().Add(timeout)
+			c, cancel := context.WithDeadline(r.Context(), ctx.Deadline)
+			defer cancel()
+			r = r.WithContext(c)
+		}
+
+		ctx.Context = r.Context()
+		ctx.DB = rt.db.WithContext(ctx.Context)
+		if sc := tracing.SpanFromContext(ctx.Context).SpanContext(); sc.Sampled {
+			ctx.Logger = ctx.Logger.WithField("traceid", sc.TraceID.String())
+		}
+
+		rw := &responseWriter{ResponseWriter: w, logger: ctx.Logger}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// used by the handlers to abort the response on purpose
+				panic(v)
+			}
+			ctx.Logger.WithFields(logrus.Fields{
+				"panic": v,
+				"stack": string(debug.Stack()),
+			}).Error("panic while serving the request")
+			if !rw.wroteHeader {
+				sendProblem(rw, http.StatusInternalServerError, codeInternalError, "")
+			}
+		}()
+
+		// Call the next handler in chain (usually, the handler function for the path)
+		fn(rw, r, ps, ctx)
+	}
+}
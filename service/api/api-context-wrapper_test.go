@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wasaPhoto/service/api/reqcontext"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// serveWrapped serves the request with fn wrapped by the router
+func serveWrapped(rt *_router, fn httpRouterHandler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	rt.wrap(fn)(w, r, nil)
+	return w
+}
+
+func TestWrapPanic(t *testing.T) {
+	rt := newTestRouter(t, nil)
+	w := serveWrapped(rt, func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext) {
+		panic("handler bug")
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError || problemCode(t, w) != codeInternalError {
+		t.Errorf("status %d, code %q, body %q", w.Code, problemCode(t, w), w.Body)
+	}
+	if _, err := uuid.FromString(w.Header().Get("X-Request-ID")); err != nil {
+		t.Errorf("X-Request-ID %q: %v", w.Header().Get("X-Request-ID"), err)
+	}
+
+	// once the response is started, it can only be cut
+	w = serveWrapped(rt, func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, _ reqcontext.RequestContext) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("partial"))
+		panic("handler bug")
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("started response: status %d, body %q", w.Code, w.Body)
+	}
+}
+
+func TestWrapAbortHandler(t *testing.T) {
+	rt := newTestRouter(t, nil)
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	serveWrapped(rt, func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext) {
+		panic(http.ErrAbortHandler)
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	t.Error("the panic was not propagated")
+}
+
+func TestWrapRequestID(t *testing.T) {
+	const proxyID = "5f0c1a2b-3c4d-4e5f-8a6b-7c8d9e0f1a2b"
+	tests := []struct {
+		name        string
+		behindProxy bool
+		header      string
+		// echoed is true if the ID of the request is kept
+		echoed bool
+	}{
+		{"no ID", false, "", false},
+		{"ID from the client", false, proxyID, false},
+		{"ID from the proxy", true, proxyID, true},
+		{"invalid ID from the proxy", true, "not-an-id", false},
+		{"no ID behind the proxy", true, "", false},
+	}
+	for _, tt := range tests {
+		rt := newTestRouter(t, func(cfg *Config) { cfg.BehindProxy = tt.behindProxy })
+		var seen string
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Request-ID", tt.header)
+		}
+		w := serveWrapped(rt, func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+			seen = ctx.ReqUUID.String()
+			w.WriteHeader(http.StatusNoContent)
+		}, r)
+
+		id := w.Header().Get("X-Request-ID")
+		if _, err := uuid.FromString(id); err != nil || id != seen {
+			t.Errorf("%s: X-Request-ID %q, ID of the request context %q", tt.name, id, seen)
+		}
+		if echoed := id == tt.header; echoed != tt.echoed {
+			t.Errorf("%s: X-Request-ID %q, echoed should be %v", tt.name, id, tt.echoed)
+		}
+	}
+}
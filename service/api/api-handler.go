@@ -7,29 +7,50 @@ import (
 // Handler returns an instance of httprouter.Router that handle APIs registered here
 func (rt *_router) Handler() http.Handler {
 	// POST REQUEST
-	rt.router.POST("/session", rt.loginHandler)
-	rt.router.POST("/photos", rt.uploadPhotoHandler)
-	rt.router.POST("/photos/:id/comments", rt.addCommentHandler)
+	rt.handle(http.MethodPost, "/session", rt.wrap(rt.loginHandler))
+	rt.handle(http.MethodPost, "/users", rt.wrap(rt.registerHandler))
+	rt.handle(http.MethodPost, "/users/:id/identities", rt.authenticated(scopeSession, rt.linkIdentityHandler))
+	rt.handle(http.MethodPost, "/users/:id/apikeys", rt.authenticated(scopeSession, rt.addAPIKeyHandler))
+	rt.handle(http.MethodPost, "/users/:id/exports", rt.authenticated(scopeSession, rt.addExportHandler))
+	rt.handle(http.MethodPost, "/photos", rt.authenticated(scopePhotosWrite, rt.uploadPhotoHandler))
+	rt.handle(http.MethodPost, "/photos/:id/comments", rt.authenticated(scopeCommentsWrite, rt.addCommentHandler))
 	// PUT REQUEST
-	rt.router.PUT("/users/:id", rt.changeMyNameHandler)
-	rt.router.PUT("/users/:id/follow/:followId", rt.followUserHandler)
-	rt.router.PUT("/users/:id/ban/:banId", rt.banUserHandler)
-	rt.router.PUT("/photos/:id/like/:userId", rt.likePhotoHandler)
+	rt.handle(http.MethodPut, "/users/:id", rt.authenticated(scopeUsersWrite, rt.changeMyNameHandler))
+	rt.handle(http.MethodPut, "/users/:id/password", rt.authenticated(scopeSession, rt.changePasswordHandler))
+	rt.handle(http.MethodPut, "/users/:id/settings", rt.authenticated(scopeSession, rt.setSettingsHandler))
+	rt.handle(http.MethodPut, "/users/:id/follow/:followId", rt.authenticated(scopeSocialWrite, rt.followUserHandler))
+	rt.handle(http.MethodPut, "/users/:id/ban/:banId", rt.authenticated(scopeSocialWrite, rt.banUserHandler))
+	rt.handle(http.MethodPut, "/photos/:id/like/:userId", rt.authenticated(scopeLikesWrite, rt.likePhotoHandler))
 	// GET REQUEST
-	rt.router.GET("/feed", rt.getFeedHandler)
-	rt.router.GET("/users/:id", rt.getUserHandler)
-	rt.router.GET("/users/:id/photos", rt.getUserPhotosHandler)
-	rt.router.GET("/users/:id/following", rt.getFollowingHandler)
-	rt.router.GET("/users/:id/followers", rt.getFollowersHandler)
-	rt.router.GET("/users", rt.searchUserHandler)
-	rt.router.GET("/photos/:id", rt.getPhotoHandler)
-	rt.router.GET("/photos/:id/comments", rt.getAllCommentsHandler)
+	rt.handle(http.MethodGet, "/session/oidc", rt.wrap(rt.oidcLoginHandler))
+	rt.handle(http.MethodGet, "/session/oidc/callback", rt.wrap(rt.oidcCallbackHandler))
+	rt.handle(http.MethodGet, "/feed", rt.authenticated(scopeFeedRead, rt.getFeedHandler))
+	rt.handle(http.MethodGet, "/users/:id", rt.authenticated(scopeUsersRead, rt.getUserHandler))
+	rt.handle(http.MethodGet, "/users/:id/photos", rt.authenticated(scopePhotosRead, rt.getUserPhotosHandler))
+	rt.handle(http.MethodGet, "/users/:id/following", rt.authenticated(scopeUsersRead, rt.getFollowingHandler))
+	rt.handle(http.MethodGet, "/users/:id/followers", rt.authenticated(scopeUsersRead, rt.getFollowersHandler))
+	rt.handle(http.MethodGet, "/users", rt.authenticated(scopeUsersRead, rt.searchUserHandler))
+	rt.handle(http.MethodGet, "/photos/:id", rt.authenticated(scopePhotosRead, rt.getPhotoHandler))
+	rt.handle(http.MethodGet, "/photos/:id/derivatives", rt.authenticated(scopePhotosRead, rt.getDerivativesHandler))
+	rt.handle(http.MethodGet, "/photos/:id/comments", rt.authenticated(scopeCommentsRead, rt.getAllCommentsHandler))
+	rt.handle(http.MethodGet, "/users/:id/settings", rt.authenticated(scopeSession, rt.getSettingsHandler))
+	rt.handle(http.MethodGet, "/users/:id/sessions", rt.authenticated(scopeSession, rt.getSessionsHandler))
+	rt.handle(http.MethodGet, "/users/:id/apikeys", rt.authenticated(scopeSession, rt.getAPIKeysHandler))
+	rt.handle(http.MethodGet, "/users/:id/exports/:exportId", rt.authenticated(scopeSession, rt.getExportHandler))
+	rt.handle(http.MethodGet, "/exports/:exportId", rt.wrapWithTimeout(rt.downloadExportHandler, 0))
 	// DELETE REQUEST
-	rt.router.DELETE("/users/:id/follow/:followId", rt.unfollowUserHandler)
-	rt.router.DELETE("/users/:id/ban/:banId", rt.unbanUserHandler)
-	rt.router.DELETE("/photos/:id", rt.deletePhotoHandler)
-	rt.router.DELETE("/photos/:id/like/:userId", rt.unlikePhotoHandler)
-	rt.router.DELETE("/comments/:commentId", rt.deleteCommentHandler)
+	rt.handle(http.MethodDelete, "/session", rt.authenticated(scopeSession, rt.logoutHandler))
+	rt.handle(http.MethodDelete, "/users/:id", rt.authenticated(scopeSession, rt.deleteUserHandler))
+	rt.handle(http.MethodDelete, "/users/:id/sessions", rt.authenticated(scopeSession, rt.deleteAllSessionsHandler))
+	rt.handle(http.MethodDelete, "/users/:id/sessions/:sessionId", rt.authenticated(scopeSession, rt.deleteSessionHandler))
+	rt.handle(http.MethodDelete, "/users/:id/apikeys/:keyId", rt.authenticated(scopeSession, rt.deleteAPIKeyHandler))
+	rt.handle(http.MethodDelete, "/users/:id/follow/:followId", rt.authenticated(scopeSocialWrite, rt.unfollowUserHandler))
+	rt.handle(http.MethodDelete, "/users/:id/ban/:banId", rt.authenticated(scopeSocialWrite, rt.unbanUserHandler))
+	rt.handle(http.MethodDelete, "/photos/:id", rt.authenticated(scopePhotosWrite, rt.deletePhotoHandler))
+	rt.handle(http.MethodDelete, "/photos/:id/like/:userId", rt.authenticated(scopeLikesWrite, rt.unlikePhotoHandler))
+	rt.handle(http.MethodDelete, "/comments/:commentId", rt.authenticated(scopeCommentsWrite, rt.deleteCommentHandler))
 	// Special routes
-	return rt.router
+	rt.handle(http.MethodGet, "/liveness", rt.wrap(rt.livenessHandler))
+	rt.handle(http.MethodGet, "/readiness", rt.wrap(rt.readinessHandler))
+	return rt.instrumented(rt.rateLimitedByIP(rt.router))
 }
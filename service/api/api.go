@@ -2,13 +2,32 @@ package api
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 	"wasaPhoto/service/database"
+	"wasaPhoto/service/metrics"
+	"wasaPhoto/service/oidc"
+	"wasaPhoto/service/ratelimit"
+	"wasaPhoto/service/storage"
+	"wasaPhoto/service/tracing"
+	"wasaPhoto/service/usernames"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// AuthModeUsername creates an account for any unknown username on login, without credentials
+	AuthModeUsername = "username"
+
+	// AuthModePassword requires accounts to be registered with a password, which is checked on login
+	AuthModePassword = "password"
+)
+
 // Config is used to provide dependencies and configuration to the New function.
 type Config struct {
 	// Logger where log entries are sent
@@ -16,6 +35,68 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// Storage is where the images of the photos are saved, by the keys stored in the database
+	Storage storage.BlobStore
+
+	// SessionSecret is the key used to sign session tokens
+	SessionSecret []byte
+
+	// SessionTTL is how long a session token is valid after the login
+	SessionTTL time.Duration
+
+	// AuthMode is how users log in, either AuthModeUsername or AuthModePassword
+	AuthMode string
+
+	// OIDC is the OpenID Connect provider used as an alternative login. Nil disables it
+	OIDC *oidc.Provider
+
+	// OIDCUIRedirect is the web UI page where browsers are sent after the OpenID Connect login, with the session
+	// token in the URL fragment. If empty, the callback answers with JSON like POST /session
+	OIDCUIRedirect string
+
+	// RateLimiter limits requests by client IP and by user, with a policy per group of routes (RateLimitDefault,
+	// RateLimitLogin...). Nil disables rate limiting
+	RateLimiter *ratelimit.Limiter
+
+	// BehindProxy makes the client IP address be read from X-Forwarded-For, where the proxy must append it
+	BehindProxy bool
+
+	// RequestTimeout is the deadline of each request, zero means no deadline
+	RequestTimeout time.Duration
+
+	// DeletionGracePeriod is how long a deleted account stays deactivated, and can be restored by logging in, before
+	// being purged. Zero deletes accounts immediately
+	DeletionGracePeriod time.Duration
+
+	// PhotoMaxSize is the maximum size in bytes of an uploaded image, PhotoMaxPixels its maximum width times height
+	PhotoMaxSize   int64
+	PhotoMaxPixels int
+
+	// ExportDir is the folder where the "download your data" archives are built
+	ExportDir string
+
+	// ExportTTL is how long the download link of an archive is valid
+	ExportTTL time.Duration
+
+	// UsernamePolicy is checked on the usernames chosen by the users
+	UsernamePolicy *usernames.Policy
+
+	// Metrics is where the metrics of the requests, the database and the storage are registered. Nil disables them
+	Metrics *metrics.Registry
+
+	// Tracer records a span for each request and each call to the database. Nil disables tracing
+	Tracer *tracing.Tracer
+
+	// AccessLog receives a line per request, in AccessLogFormat. Nil disables the access log
+	AccessLog io.Writer
+
+	// AccessLogFormat is AccessLogCombined or AccessLogJSON
+	AccessLogFormat string
+
+	// AccessLogSampleRatio is the fraction of the successful requests that are logged, from 0 to 1. The failed ones
+	// (status 400 and more) are always logged
+	AccessLogSampleRatio float64
 }
 
 // Router is the package API interface representing an API handler builder
@@ -36,6 +117,55 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.Storage == nil {
+		return nil, errors.New("storage is required")
+	}
+	if len(cfg.SessionSecret) == 0 {
+		return nil, errors.New("session secret is required")
+	}
+	if cfg.SessionTTL <= 0 {
+		return nil, errors.New("session TTL must be positive")
+	}
+	if cfg.AuthMode != AuthModeUsername && cfg.AuthMode != AuthModePassword {
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.AuthMode)
+	}
+	if cfg.RequestTimeout < 0 {
+		return nil, errors.New("request timeout can't be negative")
+	}
+	if cfg.DeletionGracePeriod < 0 {
+		return nil, errors.New("deletion grace period can't be negative")
+	}
+	if cfg.UsernamePolicy == nil {
+		return nil, errors.New("username policy is required")
+	}
+	if cfg.PhotoMaxSize <= 0 || cfg.PhotoMaxPixels <= 0 {
+		return nil, errors.New("photo size limits must be positive")
+	}
+	if cfg.ExportDir == "" {
+		return nil, errors.New("export folder is required")
+	}
+	if cfg.ExportTTL <= 0 {
+		return nil, errors.New("export TTL must be positive")
+	}
+	var accessLog *accessLogger
+	if cfg.AccessLog != nil {
+		if cfg.AccessLogFormat != AccessLogCombined && cfg.AccessLogFormat != AccessLogJSON {
+			return nil, fmt.Errorf("unknown access log format %q", cfg.AccessLogFormat)
+		}
+		if cfg.AccessLogSampleRatio < 0 || cfg.AccessLogSampleRatio > 1 {
+			return nil, errors.New("access log sample ratio must be between 0 and 1")
+		}
+		accessLog = &accessLogger{w: cfg.AccessLog, format: cfg.AccessLogFormat, sampleRatio: cfg.AccessLogSampleRatio}
+	}
+	err := os.MkdirAll(cfg.ExportDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("creating the export folder: %w", err)
+	}
+	// archives still being built when the service stopped will never be completed
+	err = cfg.Database.FailPendingExports("interrupted by a restart")
+	if err != nil {
+		return nil, fmt.Errorf("updating pending exports: %w", err)
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
@@ -43,11 +173,39 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	rt := &_router{
+		router:              router,
+		baseLogger:          cfg.Logger,
+		db:                  cfg.Database,
+		blobs:               cfg.Storage,
+		sessionSecret:       cfg.SessionSecret,
+		sessionTTL:          cfg.SessionTTL,
+		authMode:            cfg.AuthMode,
+		oidc:                cfg.OIDC,
+		oidcUIRedirect:      cfg.OIDCUIRedirect,
+		rateLimiter:         cfg.RateLimiter,
+		behindProxy:         cfg.BehindProxy,
+		requestTimeout:      cfg.RequestTimeout,
+		deletionGracePeriod: cfg.DeletionGracePeriod,
+		photoMaxSize:        cfg.PhotoMaxSize,
+		photoMaxPixels:      cfg.PhotoMaxPixels,
+		exportDir:           cfg.ExportDir,
+		exportTTL:           cfg.ExportTTL,
+		exportQueue:         make(chan string, exportQueueSize),
+		derivativeQueue:     make(chan int, derivativeQueueSize),
+		usernamePolicy:      cfg.UsernamePolicy,
+		tracer:              cfg.Tracer,
+		accessLog:           accessLog,
+		done:                make(chan struct{}),
+	}
+	if cfg.Metrics != nil {
+		rt.registerMetrics(cfg.Metrics)
+	}
+	if cfg.Tracer != nil {
+		rt.db = database.Observe(rt.db, traceDatabase)
+	}
+	rt.startWorkers()
+	return rt, nil
 }
 
 type _router struct {
@@ -58,4 +216,53 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	// blobs are the images of the photos
+	blobs storage.BlobStore
+
+	sessionSecret []byte
+	sessionTTL    time.Duration
+	authMode      string
+
+	oidc           *oidc.Provider
+	oidcUIRedirect string
+
+	rateLimiter *ratelimit.Limiter
+	behindProxy bool
+
+	requestTimeout time.Duration
+
+	deletionGracePeriod time.Duration
+
+	photoMaxSize   int64
+	photoMaxPixels int
+
+	exportDir   string
+	exportTTL   time.Duration
+	exportQueue chan string
+
+	// derivativeQueue are the IDs of the photos whose derivatives must be rendered
+	derivativeQueue chan int
+
+	usernamePolicy *usernames.Policy
+
+	// metrics are nil if disabled
+	metrics *routerMetrics
+
+	// tracer is nil if disabled
+	tracer *tracing.Tracer
+
+	// accessLog is nil if disabled
+	accessLog *accessLogger
+
+	// done is closed by Close (once, by closeOnce) to stop the background goroutines, which are tracked by workers
+	done      chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
+
+	// runningWorkers is the number of background goroutines still running, and lastMaintenance the time (in Unix
+	// nanoseconds) of the end of the last maintenance run. Both are accessed atomically, and read by the readiness
+	// probe
+	runningWorkers  int32
+	lastMaintenance int64
 }
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/storage"
+	"wasaPhoto/service/usernames"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestRouter returns a router on a new database and a memory store, in username mode. configure can change the
+// configuration before the router is built.
+func newTestRouter(t *testing.T, configure func(*Config)) *_router {
+	t.Helper()
+	dir := t.TempDir()
+	conn, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := usernames.NewPolicy(3, 20, `^[\p{L}\p{N}_.-]+$`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := Config{
+		Logger:         logger,
+		Database:       db,
+		Storage:        storage.NewMemoryStore(),
+		SessionSecret:  []byte("test secret"),
+		SessionTTL:     time.Hour,
+		AuthMode:       AuthModeUsername,
+		PhotoMaxSize:   1 << 20,
+		PhotoMaxPixels: 1 << 20,
+		ExportDir:      filepath.Join(dir, "exports"),
+		ExportTTL:      time.Hour,
+		UsernamePolicy: policy,
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+	router, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+	return router.(*_router)
+}
+
+// do sends a request with a JSON body (if not nil) and the token (if not empty) to the handler
+func do(t *testing.T, h http.Handler, method string, target string, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// login logs in the user, creating it, and returns its ID and session token
+func login(t *testing.T, h http.Handler, username string) (int, string) {
+	t.Helper()
+	w := do(t, h, http.MethodPost, "/session", "", map[string]string{"Username": username})
+	var session loginResponse
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil || session.Token == "" {
+		t.Fatalf("login of %s: status %d, %v", username, w.Code, err)
+	}
+	return session.ID, session.Token
+}
+
+// addPhoto adds the user, if missing, and a photo of it to the database. The derivatives of the photo are marked as
+// rendered, otherwise a worker would update the photo while the test runs: call it from the configure function of
+// newTestRouter, before the workers start.
+func addPhoto(t *testing.T, db database.AppDatabase, username string) database.Photo {
+	t.Helper()
+	user, err := db.GetUserByUsername(username)
+	if errors.Is(err, database.ErrNotFound) {
+		user, err = db.AddUser(username)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := db.AddPhoto(user.ID, strconv.Itoa(user.ID)+"_photo.jpg", "image/jpeg", 1, 1, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetDerivativesStatus(photo.ID, database.READY); err != nil {
+		t.Fatal(err)
+	}
+	return photo
+}
+
+// problemCode returns the code of the problem+json body of the response, or "" if the body is not a problem
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if w.Header().Get("Content-Type") != "application/problem+json" {
+		return ""
+	}
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("invalid problem %q: %v", w.Body, err)
+	}
+	return p.Code
+}
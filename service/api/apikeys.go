@@ -0,0 +1,124 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/globaltime"
+)
+
+// Scopes of the routes registered in api-handler.go. API keys are created with a subset of the public ones.
+const (
+	scopeUsersRead     = "users:read"
+	scopeUsersWrite    = "users:write"
+	scopePhotosRead    = "photos:read"
+	scopePhotosWrite   = "photos:write"
+	scopeFeedRead      = "feed:read"
+	scopeCommentsRead  = "comments:read"
+	scopeCommentsWrite = "comments:write"
+	scopeLikesWrite    = "likes:write"
+	scopeSocialWrite   = "social:write"
+
+	// scopeSession is for account management routes (sessions, passwords, API keys...), which need a real login
+	scopeSession = "session"
+)
+
+// apiKeyScopes are the scopes that can be given to an API key
+var apiKeyScopes = []string{
+	scopeUsersRead, scopeUsersWrite, scopePhotosRead, scopePhotosWrite, scopeFeedRead,
+	scopeCommentsRead, scopeCommentsWrite, scopeLikesWrite, scopeSocialWrite,
+}
+
+// apiKeyPrefix starts every API key, to tell them apart from session tokens: "wpk_<key id>_<secret>"
+const apiKeyPrefix = "wpk_"
+
+// apiKeyRequest is the body of POST /users/:id/apikeys
+type apiKeyRequest struct {
+	Name   string
+	Scopes []string
+}
+
+// apiKeyInfo describes an API key. Key, the full secret, is returned only when the key is created.
+type apiKeyInfo struct {
+	ID        string
+	Name      string
+	Scopes    []string
+	CreatedAt time.Time
+	LastUsed  *time.Time `json:",omitempty"`
+	Key       string     `json:",omitempty"`
+}
+
+type apiKeyList struct{ Items []apiKeyInfo }
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashSecret returns the hash stored in place of a random secret (API keys, download links). Secrets have enough
+// entropy that a fast hash is fine.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKeyInfo(key database.APIKey) apiKeyInfo {
+	info := apiKeyInfo{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+	}
+	if !key.LastUsed.IsZero() {
+		info.LastUsed = &key.LastUsed
+	}
+	return info
+}
+
+// newAPIKey stores a new key for the user and returns it with the full secret, which is not stored
+func (rt *_router) newAPIKey(ctx reqcontext.RequestContext, userID int, name string, scopes []string) (apiKeyInfo, error) {
+	random := make([]byte, 6+32)
+	if _, err := rand.Read(random); err != nil {
+		return apiKeyInfo{}, err
+	}
+	id := hex.EncodeToString(random[:6])
+	secret := hex.EncodeToString(random[6:])
+	key, err := ctx.DB.AddAPIKey(id, userID, name, scopes, hashSecret(secret))
+	if err != nil {
+		return apiKeyInfo{}, err
+	}
+	info := newAPIKeyInfo(key)
+	info.Key = apiKeyPrefix + id + "_" + secret
+	return info, nil
+}
+
+// currentAPIKey resolves the API key of the Authorization header, which must exist and not be revoked
+func (rt *_router) currentAPIKey(ctx reqcontext.RequestContext, token string) (database.APIKey, error) {
+	id, secret, found := strings.Cut(strings.TrimPrefix(token, apiKeyPrefix), "_")
+	if !found {
+		return database.APIKey{}, errNotLogged
+	}
+	key, err := ctx.DB.GetAPIKey(id)
+	if err != nil || key.Revoked {
+		return database.APIKey{}, errNotLogged
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(secret))) != 1 {
+		return database.APIKey{}, errNotLogged
+	}
+	// like for sessions, last used is not updated on every request
+	if globaltime.Since(key.LastUsed) > lastSeenPrecision {
+		if err := ctx.DB.TouchAPIKey(key.ID); err != nil {
+			ctx.Logger.WithError(err).Warning("can't update API key last used")
+		}
+	}
+	return key, nil
+}
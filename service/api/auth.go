@@ -0,0 +1,217 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/globaltime"
+	"wasaPhoto/service/tracing"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the minimum number of bytes of a password
+const minPasswordLength = 8
+
+// lastSeenPrecision is how stale the last seen time of a session can be
+const lastSeenPrecision = time.Minute
+
+var errNotLogged = errors.New("missing or invalid session token")
+
+// credentials is the body of POST /session and POST /users. Password is ignored in username mode.
+type credentials struct {
+	Username string
+	Password string
+}
+
+// passwordChange is the body of PUT /users/:id/password
+type passwordChange struct {
+	OldPassword string
+	NewPassword string
+}
+
+// sessionInfo describes a session in GET /users/:id/sessions, Current is true for the session making the request
+type sessionInfo struct {
+	ID        string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+	UserAgent string
+	IP        string
+	Current   bool
+}
+
+type sessionList struct{ Items []sessionInfo }
+
+// loginResponse is the body returned by POST /session: the user and the token to send in the Authorization header
+type loginResponse struct {
+	ID        int
+	Username  string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// sign returns the HMAC of data with the session secret, encoded for use inside a token
+func (rt *_router) sign(data string) string {
+	mac := hmac.New(sha256.New, rt.sessionSecret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether signature is the one returned by sign for data
+func (rt *_router) verify(data string, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(rt.sign(data)))
+}
+
+// clientIP returns the address of the client, without the port. Behind a reverse proxy, the address is the last one
+// in X-Forwarded-For, the one appended by the proxy: the entries before it are sent by the client, and can be forged.
+func (rt *_router) clientIP(r *http.Request) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); rt.behindProxy && len(forwarded) > 0 {
+		hops := strings.Split(forwarded[len(forwarded)-1], ",")
+		if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newSession stores a new session for the user and returns the signed token ("<session id>.<signature>"). Logging in
+// restores an account deactivated during the deletion grace period.
+func (rt *_router) newSession(ctx reqcontext.RequestContext, r *http.Request, userID int) (database.Session, string, error) {
+	err := rt.restoreUser(ctx, userID)
+	if err != nil {
+		return database.Session{}, "", err
+	}
+	sessionID, err := uuid.NewV4()
+	if err != nil {
+		return database.Session{}, "", err
+	}
+	session, err := ctx.DB.AddSession(sessionID.String(), userID, globaltime.Now().Add(rt.sessionTTL), r.UserAgent(), rt.clientIP(r))
+	if err != nil {
+		return database.Session{}, "", err
+	}
+	return session, session.ID + "." + rt.sign(session.ID), nil
+}
+
+// currentSession resolves the session from the bearer token in the Authorization header. The token signature is
+// checked before touching the database, then the session must exist, not be revoked and not be expired.
+func (rt *_router) currentSession(ctx reqcontext.RequestContext, r *http.Request) (database.Session, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	sessionID, signature, found := strings.Cut(token, ".")
+	if !found || !rt.verify(sessionID, signature) {
+		return database.Session{}, errNotLogged
+	}
+	session, err := ctx.DB.GetSession(sessionID)
+	if err != nil {
+		return database.Session{}, errNotLogged
+	}
+	if session.Revoked || !globaltime.Now().Before(session.ExpiresAt) {
+		return database.Session{}, errNotLogged
+	}
+	// last seen is informative only, so it is not updated on every request
+	if globaltime.Since(session.LastSeen) > lastSeenPrecision {
+		if err := ctx.DB.TouchSession(session.ID); err != nil {
+			ctx.Logger.WithError(err).Warning("can't update session last seen")
+		}
+	}
+	return session, nil
+}
+
+// authenticate resolves the caller from the bearer token, which is either a session token or an API key, and adds
+// it to ctx
+func (rt *_router) authenticate(r *http.Request, ctx *reqcontext.RequestContext) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		key, err := rt.currentAPIKey(*ctx, token)
+		if err != nil {
+			return err
+		}
+		ctx.UserID = key.UserID
+		ctx.APIKey = key
+	} else {
+		session, err := rt.currentSession(*ctx, r)
+		if err != nil {
+			return err
+		}
+		ctx.UserID = session.UserID
+		ctx.Session = session
+	}
+	ctx.Logger = ctx.Logger.WithField("userid", ctx.UserID)
+	tracing.SpanFromContext(ctx.Context).SetAttribute("enduser.id", ctx.UserID)
+	if sw := requestStatus(r.Context()); sw != nil {
+		sw.userID = ctx.UserID
+	}
+	return nil
+}
+
+// authenticated wraps the handler of a route that needs a logged caller. The caller must have the scope of the
+// route: sessions have every scope, API keys only the ones they were created with and never scopeSession.
+func (rt *_router) authenticated(scope string, fn httpRouterHandler) httprouter.Handle {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		if err := rt.authenticate(r, &ctx); err != nil {
+			sendProblem(w, http.StatusUnauthorized, codeNotAuthenticated, errNotLogged.Error())
+			return
+		}
+		if rt.rateLimited(w, r, "user:"+strconv.Itoa(ctx.UserID)) {
+			return
+		}
+		if ctx.APIKey.ID != "" && scope == scopeSession {
+			sendProblem(w, http.StatusForbidden, codeAPIKeyNotAllowed, "API keys can't be used for account management")
+			return
+		}
+		if ctx.APIKey.ID != "" && !hasScope(ctx.APIKey.Scopes, scope) {
+			sendProblem(w, http.StatusForbidden, codeInsufficientScope, "API key without the "+scope+" scope")
+			return
+		}
+		fn(w, r, ps, ctx)
+	})
+}
+
+// hashPassword returns the bcrypt hash of the password, refusing passwords that are too short
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyPasswordHash is compared with the passwords of the users that don't exist or have no password, so that a login
+// takes as long for them as for the others, and the response time doesn't tell which usernames exist
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// checkDummyPassword takes as long as checking a password, and never matches
+func checkDummyPassword(password string) bool {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+	return false
+}
+
+// checkPassword reports whether password matches the stored hash of the user. Accounts without a password never match.
+func (rt *_router) checkPassword(ctx reqcontext.RequestContext, userID int, password string) (bool, error) {
+	hash, err := ctx.DB.GetPasswordHash(userID)
+	if err != nil {
+		return false, err
+	}
+	if hash == "" {
+		return checkDummyPassword(password), nil
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, nil
+}
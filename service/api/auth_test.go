@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		behindProxy bool
+		forwarded   []string
+		want        string
+	}{
+		{"direct", false, nil, "192.0.2.1"},
+		{"direct ignores the header", false, []string{"203.0.113.7"}, "192.0.2.1"},
+		{"proxy without header", true, nil, "192.0.2.1"},
+		{"proxy", true, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"forged entries", true, []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"forged header", true, []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"empty entry", true, []string{"198.51.100.1,"}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		rt := &_router{behindProxy: tt.behindProxy}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := rt.clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// passwordSession registers the user with the password, or logs it in if it exists, and returns its ID and token
+func passwordSession(t *testing.T, h http.Handler, target string, username string, password string) (int, string) {
+	t.Helper()
+	w := do(t, h, http.MethodPost, target, "", credentials{Username: username, Password: password})
+	var session loginResponse
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil || session.Token == "" {
+		t.Fatalf("%s as %s: status %d, %v", target, username, w.Code, err)
+	}
+	return session.ID, session.Token
+}
+
+func TestChangePassword(t *testing.T) {
+	rt := newTestRouter(t, func(cfg *Config) { cfg.AuthMode = AuthModePassword })
+	h := rt.Handler()
+	userID, current := passwordSession(t, h, "/users", "alice", "old password")
+	_, other := passwordSession(t, h, "/session", "alice", "old password")
+	key := addAPIKey(t, h, userID, current, scopePhotosRead)
+
+	w := do(t, h, http.MethodPut, "/users/"+strconv.Itoa(userID)+"/password", current,
+		passwordChange{OldPassword: "old password", NewPassword: "new password"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("password change: status %d", w.Code)
+	}
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"session changing the password", current, http.StatusOK},
+		{"other session", other, http.StatusUnauthorized},
+		{"API key", key.Key, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := do(t, h, http.MethodGet, "/users/"+strconv.Itoa(userID)+"/photos", tt.token, nil); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestPasswordLogin(t *testing.T) {
+	// unknown users are checked against the dummy hash, which must cost as much as the real ones
+	if cost, err := bcrypt.Cost(dummyPasswordHash); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost %d, %v", cost, err)
+	}
+	rt := newTestRouter(t, func(cfg *Config) { cfg.AuthMode = AuthModePassword })
+	h := rt.Handler()
+	passwordSession(t, h, "/users", "alice", "right password")
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{"right password", "alice", "right password", http.StatusOK},
+		{"wrong password", "alice", "wrong password", http.StatusUnauthorized},
+		{"unknown user", "bob", "right password", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := do(t, h, http.MethodPost, "/session", "", credentials{Username: tt.username, Password: tt.password})
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+// addAPIKey creates an API key of the user with the scopes
+func addAPIKey(t *testing.T, h http.Handler, userID int, token string, scopes ...string) apiKeyInfo {
+	t.Helper()
+	w := do(t, h, http.MethodPost, "/users/"+strconv.Itoa(userID)+"/apikeys", token, apiKeyRequest{Name: "bot", Scopes: scopes})
+	var key apiKeyInfo
+	if err := json.NewDecoder(w.Body).Decode(&key); err != nil || key.Key == "" {
+		t.Fatalf("API key: status %d, %v", w.Code, err)
+	}
+	return key
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	rt := newTestRouter(t, nil)
+	h := rt.Handler()
+	userID, session := login(t, h, "alice")
+	key := addAPIKey(t, h, userID, session, scopePhotosRead)
+	revoked := addAPIKey(t, h, userID, session, scopePhotosRead)
+	if w := do(t, h, http.MethodDelete, "/users/"+strconv.Itoa(userID)+"/apikeys/"+revoked.ID, session, nil); w.Code != http.StatusOK {
+		t.Fatalf("revocation: status %d", w.Code)
+	}
+	user := "/users/" + strconv.Itoa(userID)
+	wrongSecret := apiKeyPrefix + key.ID + "_" + strings.Repeat("0", 64)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		status int
+		code   string
+	}{
+		{"scope of the key", http.MethodGet, user + "/photos", key.Key, http.StatusOK, ""},
+		{"scope missing", http.MethodGet, "/feed", key.Key, http.StatusForbidden, codeInsufficientScope},
+		{"scope missing, write", http.MethodDelete, "/photos/1", key.Key, http.StatusForbidden, codeInsufficientScope},
+		{"session route", http.MethodGet, user + "/sessions", key.Key, http.StatusForbidden, codeAPIKeyNotAllowed},
+		{"session route, new key", http.MethodPost, user + "/apikeys", key.Key, http.StatusForbidden, codeAPIKeyNotAllowed},
+		{"session route, password", http.MethodPut, user + "/password", key.Key, http.StatusForbidden, codeAPIKeyNotAllowed},
+		{"session", http.MethodGet, "/feed", session, http.StatusOK, ""},
+		{"session on a session route", http.MethodGet, user + "/sessions", session, http.StatusOK, ""},
+		{"revoked key", http.MethodGet, user + "/photos", revoked.Key, http.StatusUnauthorized, codeNotAuthenticated},
+		{"wrong secret", http.MethodGet, user + "/photos", wrongSecret, http.StatusUnauthorized, codeNotAuthenticated},
+		{"unknown key", http.MethodGet, user + "/photos", apiKeyPrefix + "000000000000_" + strings.Repeat("0", 64), http.StatusUnauthorized, codeNotAuthenticated},
+		{"malformed key", http.MethodGet, user + "/photos", apiKeyPrefix + key.ID, http.StatusUnauthorized, codeNotAuthenticated},
+		{"empty key", http.MethodGet, user + "/photos", apiKeyPrefix, http.StatusUnauthorized, codeNotAuthenticated},
+	}
+	for _, tt := range tests {
+		w := do(t, h, tt.method, tt.target, tt.token, nil)
+		if w.Code != tt.status || problemCode(t, w) != tt.code {
+			t.Errorf("%s: %s %s: status %d, code %q, want %d and %q", tt.name, tt.method, tt.target, w.Code,
+				problemCode(t, w), tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/derivatives"
+	"wasaPhoto/service/imaging"
+	"wasaPhoto/service/tracing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// derivativeQueueSize is the number of photos that can wait for a worker. Uploads that find the queue full are
+// rendered later by the scheduler.
+const derivativeQueueSize = 256
+
+// derivativeScanInterval is how often the scheduler looks for photos whose derivatives were not rendered, like the
+// ones uploaded before the derivatives or while the queue was full
+const derivativeScanInterval = 10 * time.Minute
+
+// derivativesInfo describes the derivatives of a photo in the API. URLs are the paths of the sizes, by name.
+type derivativesInfo struct {
+	PhotoID int
+	Status  string
+	URLs    map[string]string
+}
+
+// getDerivativesHandler returns the status of the derivatives of the photo, for clients waiting after the upload
+func (rt *_router) getDerivativesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	photo, err := ctx.DB.GetPhoto(id)
+	if err != nil {
+		sendDatabaseError(ctx, w, err)
+		return
+	}
+	if rt.securityChecker(ctx, photo.UserID, w) {
+		return
+	}
+	info := derivativesInfo{PhotoID: photo.ID, Status: photo.Derivatives, URLs: make(map[string]string)}
+	for _, size := range derivatives.Sizes {
+		info.URLs[size.Name] = "/photos/" + strconv.Itoa(photo.ID) + "?size=" + size.Name
+	}
+	if photo.Derivatives == database.PENDING {
+		w.Header().Set("Retry-After", "5")
+	}
+	finalize(ctx, info, nil, w, 200)
+}
+
+// queueDerivatives asks a worker to render the derivatives of the photo. If the queue is full the photo stays
+// PENDING, and it's queued again by the scheduler.
+func (rt *_router) queueDerivatives(photoID int) {
+	select {
+	case rt.derivativeQueue <- photoID:
+	default:
+	}
+}
+
+// derivativeScheduler queues the photos whose derivatives are PENDING, at startup and then every
+// derivativeScanInterval, waiting for room in the queue
+func (rt *_router) derivativeScheduler() {
+	ticker := time.NewTicker(derivativeScanInterval)
+	defer ticker.Stop()
+	for {
+		ids, err := rt.db.GetPhotoIDsByDerivatives(database.PENDING)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("can't list the photos without derivatives")
+		}
+		for _, id := range ids {
+			select {
+			case <-rt.done:
+				return
+			case rt.derivativeQueue <- id:
+			}
+		}
+
+		select {
+		case <-rt.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// derivativeWorker renders the derivatives of the photos in the queue until Close is called
+func (rt *_router) derivativeWorker() {
+	for {
+		select {
+		case <-rt.done:
+			return
+		case id := <-rt.derivativeQueue:
+			rt.renderDerivatives(id)
+		}
+	}
+}
+
+// renderDerivatives renders the derivatives of the photo from its original image, and updates their status. Photos
+// already rendered, e.g. queued twice, are skipped.
+func (rt *_router) renderDerivatives(photoID int) {
+	ctx, span := rt.tracer.Start(context.Background(), "render derivatives", tracing.KindInternal)
+	defer span.End()
+	span.SetAttribute("photo.id", photoID)
+	db := rt.db.WithContext(ctx)
+	logger := rt.baseLogger.WithField("photo", photoID)
+
+	photo, err := db.GetPhoto(photoID)
+	if errors.Is(err, database.ErrNotFound) {
+		// deleted while waiting
+		return
+	} else if err != nil {
+		span.SetError(err)
+		logger.WithError(err).Error("can't read the photo to render its derivatives")
+		return
+	}
+	if photo.Derivatives != database.PENDING {
+		return
+	}
+
+	status := database.READY
+	err = rt.writeDerivatives(ctx, photo)
+	span.SetError(err)
+	if err != nil {
+		logger.WithError(err).Error("can't render the derivatives")
+		status = database.FAILED
+	}
+	if err := db.SetDerivativesStatus(photoID, status); err != nil {
+		logger.WithError(err).Error("can't update the status of the derivatives")
+	}
+}
+
+func (rt *_router) writeDerivatives(ctx context.Context, photo database.Photo) error {
+	blob, _, err := rt.blobs.Get(ctx, photo.Photourl)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(blob)
+	_ = blob.Close()
+	if err != nil {
+		return err
+	}
+	img, _, err := imaging.Decode(data, rt.photoMaxPixels)
+	if err != nil {
+		return err
+	}
+	return derivatives.Generate(ctx, rt.blobs, photo.Photourl, img)
+}
+
+// deleteImages deletes the image of a deleted photo, or of a failed upload, with its derivatives and original upload.
+// Errors are only logged, as the photo is already gone: fsck finds what is left.
+func (rt *_router) deleteImages(ctx context.Context, logger logrus.FieldLogger, key string) {
+	if err := rt.blobs.Delete(ctx, key); err != nil {
+		logger.WithError(err).WithField("key", key).Warning("can't delete the image of a photo")
+	}
+	if err := derivatives.Delete(ctx, rt.blobs, key); err != nil {
+		logger.WithError(err).WithField("key", key).Warning("can't delete the derivatives of a photo")
+	}
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/archive"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/globaltime"
+	"wasaPhoto/service/tracing"
+
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// exportQueueSize is the number of archives that can wait for a worker
+const exportQueueSize = 16
+
+// exportWriteTimeout is how long the download of an archive can go without progress
+const exportWriteTimeout = time.Minute
+
+// exportInfo describes an export in the API. DownloadURL is returned only when the export is requested: the link works
+// once, as soon as Status is READY, until ExpiresAt.
+type exportInfo struct {
+	ID          string
+	Status      string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	Size        int64  `json:",omitempty"`
+	Error       string `json:",omitempty"`
+	DownloadURL string `json:",omitempty"`
+}
+
+func newExportInfo(export database.Export) exportInfo {
+	return exportInfo{
+		ID:        export.ID,
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+		ExpiresAt: export.ExpiresAt,
+		Size:      export.Size,
+		Error:     export.Error,
+	}
+}
+
+// addExportHandler requests the archive with the data of the user, which is built in background
+func (rt *_router) addExportHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	userID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if myID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't export the data of another user")
+		return
+	}
+	pending, err := ctx.DB.UserHasPendingExport(userID)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	if pending {
+		sendProblem(w, http.StatusConflict, codeExportInProgress, "an export is already being prepared")
+		return
+	}
+
+	exportID, err := uuid.NewV4()
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	token := hex.EncodeToString(random)
+	export, err := ctx.DB.AddExport(exportID.String(), userID, hashSecret(token), globaltime.Now().Add(rt.exportTTL))
+	if err != nil {
+		finalize(ctx, nil, err, w, 202)
+		return
+	}
+	select {
+	case rt.exportQueue <- export.ID:
+	default:
+		_ = ctx.DB.SetExportFailed(export.ID, "too many exports in progress")
+		w.Header().Set("Retry-After", "60")
+		sendProblem(w, http.StatusServiceUnavailable, codeUnavailable, "too many exports in progress, retry later")
+		return
+	}
+	info := newExportInfo(export)
+	info.DownloadURL = "/exports/" + export.ID + "?token=" + token
+	finalize(ctx, info, err, w, 202)
+}
+
+func (rt *_router) getExportHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	userID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if myID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't see the data of another user")
+		return
+	}
+	export, err := ctx.DB.GetExport(ps.ByName("exportId"))
+	if err == nil && export.UserID != userID {
+		err = &database.Error{Kind: database.ErrNotFound, What: "export"}
+	}
+	if err != nil {
+		sendDatabaseError(ctx, w, err)
+		return
+	}
+	finalize(ctx, newExportInfo(export), err, w, 200)
+}
+
+// downloadExportHandler serves the archive to whoever has the download link, until a download completes. The link does
+// not need the Authorization header, so that it can be opened by a browser.
+func (rt *_router) downloadExportHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	export, err := ctx.DB.GetExport(ps.ByName("exportId"))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if err != nil || subtle.ConstantTimeCompare([]byte(export.TokenHash), []byte(hashSecret(token))) != 1 ||
+		!globaltime.Now().Before(export.ExpiresAt) {
+		sendProblem(w, http.StatusNotFound, codeNotFound, "export not found or expired")
+		return
+	}
+	switch export.Status {
+	case database.PENDING:
+		w.Header().Set("Retry-After", "10")
+		sendProblem(w, http.StatusConflict, codeExportNotReady, "the export is not ready yet")
+		return
+	case database.READY:
+	default:
+		sendProblem(w, http.StatusGone, codeExportGone, "the export is "+export.Status)
+		return
+	}
+
+	f, err := os.Open(export.Path)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+	w.Header().Set("Content-Disposition", `attachment; filename="wasaphoto-`+export.CreatedAt.Format("2006-01-02")+`.zip"`)
+	w.WriteHeader(200)
+	// the download has no deadline, but it can't stall for more than exportWriteTimeout
+	n, err := io.Copy(deadlineWriter{w: w, rc: http.NewResponseController(w), timeout: exportWriteTimeout}, f)
+	if err != nil || n != export.Size {
+		// the link still works, to try again
+		ctx.Logger.WithError(err).WithField("export", export.ID).Warning("export download interrupted")
+		return
+	}
+	// the client may be gone already: the request context is not used
+	consumed, err := rt.db.ConsumeExport(export.ID)
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("export", export.ID).Error("can't mark an export as downloaded")
+		return
+	}
+	if !consumed {
+		// downloaded at the same time by another request, which removes it
+		return
+	}
+	if err := os.Remove(export.Path); err != nil {
+		ctx.Logger.WithError(err).WithField("export", export.ID).Error("can't remove a downloaded export")
+	}
+}
+
+// deadlineWriter moves the write deadline of the connection before every write, so that only a stalled download
+// times out
+type deadlineWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (d deadlineWriter) Write(b []byte) (int, error) {
+	// not supported by every writer (e.g. in the tests): the server deadline applies then
+	_ = d.rc.SetWriteDeadline(time.Now().Add(d.timeout))
+	return d.w.Write(b)
+}
+
+// exportWorker builds the archives in the queue until Close is called
+func (rt *_router) exportWorker() {
+	for {
+		select {
+		case <-rt.done:
+			return
+		case id := <-rt.exportQueue:
+			rt.buildExport(id)
+		}
+	}
+}
+
+// buildExport writes the archive into a temporary file, renamed when complete, and updates the status of the export
+func (rt *_router) buildExport(id string) {
+	ctx, span := rt.tracer.Start(context.Background(), "build export", tracing.KindInternal)
+	defer span.End()
+	span.SetAttribute("export.id", id)
+	db := rt.db.WithContext(ctx)
+
+	logger := rt.baseLogger.WithField("export", id)
+	path, size, err := rt.writeExport(db, id)
+	span.SetError(err)
+	if err != nil {
+		logger.WithError(err).Error("can't build the export")
+		if err := db.SetExportFailed(id, "internal error building the archive"); err != nil {
+			logger.WithError(err).Error("can't update the export status")
+		}
+		return
+	}
+	if err := db.SetExportReady(id, path, size); err != nil {
+		logger.WithError(err).Error("can't update the export status")
+		_ = os.Remove(path)
+		return
+	}
+	logger.WithField("size", size).Info("export ready")
+}
+
+func (rt *_router) writeExport(db database.AppDatabase, id string) (string, int64, error) {
+	export, err := db.GetExport(id)
+	if err != nil {
+		return "", 0, err
+	}
+	path := filepath.Join(rt.exportDir, export.ID+".zip")
+	tmp, err := os.CreateTemp(rt.exportDir, export.ID+".*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	err = archive.Write(tmp, db, rt.blobs, export.UserID)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return path, info.Size(), nil
+}
+
+// deleteExpiredExports removes the exports whose download link expired, with their archive
+func (rt *_router) deleteExpiredExports() {
+	exports, err := rt.db.GetExportsExpiredBefore(globaltime.Now())
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't list the expired exports")
+		return
+	}
+	for _, export := range exports {
+		if export.Path != "" {
+			if err := os.Remove(export.Path); err != nil && !os.IsNotExist(err) {
+				rt.baseLogger.WithError(err).WithField("export", export.ID).Error("can't remove an expired export")
+				continue
+			}
+		}
+		if _, err := rt.db.DeleteExport(export.ID); err != nil {
+			rt.baseLogger.WithError(err).WithField("export", export.ID).Error("can't delete an expired export")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+	"wasaPhoto/service/archive"
+	"wasaPhoto/service/database"
+)
+
+// requestExport asks for an export of the user and waits until it's built. It returns the download URL and the export.
+func requestExport(t *testing.T, rt *_router, h http.Handler, userID int, token string) (string, database.Export) {
+	t.Helper()
+	w := do(t, h, http.MethodPost, "/users/"+strconv.Itoa(userID)+"/exports", token, nil)
+	var info exportInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("export request: status %d, %v", w.Code, err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		export, err := rt.db.GetExport(info.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if export.Status != database.PENDING {
+			if export.Status != database.READY {
+				t.Fatalf("export %s: %s", export.Status, export.Error)
+			}
+			return info.DownloadURL, export
+		}
+	}
+	t.Fatal("the export was not built in time")
+	return "", database.Export{}
+}
+
+// brokenWriter is a client that disconnects after limit bytes
+type brokenWriter struct {
+	header  http.Header
+	status  int
+	written int
+	limit   int
+}
+
+func (w *brokenWriter) Header() http.Header    { return w.header }
+func (w *brokenWriter) WriteHeader(status int) { w.status = status }
+
+func (w *brokenWriter) Write(b []byte) (int, error) {
+	if w.written+len(b) > w.limit {
+		return 0, errors.New("connection reset by peer")
+	}
+	w.written += len(b)
+	return len(b), nil
+}
+
+func TestDownloadExport(t *testing.T) {
+	var photo database.Photo
+	rt := newTestRouter(t, func(cfg *Config) { photo = addPhoto(t, cfg.Database, "bob") })
+	h := rt.Handler()
+	userID, token := login(t, h, "alice")
+	if _, err := rt.db.AddComment(photo.ID, userID, "nice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.db.AddLike(photo.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	url, export := requestExport(t, rt, h, userID, token)
+
+	// an interrupted download doesn't use the link
+	broken := &brokenWriter{header: http.Header{}, limit: 10}
+	r, _ := http.NewRequest(http.MethodGet, url, nil)
+	h.ServeHTTP(broken, r)
+	if broken.status != http.StatusOK {
+		t.Fatalf("interrupted download: status %d", broken.status)
+	}
+	if _, err := os.Stat(export.Path); err != nil {
+		t.Fatalf("the archive was removed after an interrupted download: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"wrong token", "/exports/" + export.ID + "?token=wrong", http.StatusNotFound},
+		{"unknown export", "/exports/unknown?token=wrong", http.StatusNotFound},
+		{"after an interrupted download", url, http.StatusOK},
+		{"second download", url, http.StatusGone},
+	}
+	for _, tt := range tests {
+		w := do(t, h, http.MethodGet, tt.target, "", nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		body := w.Body.Bytes()
+		if int64(len(body)) != export.Size || w.Header().Get("Content-Type") != "application/zip" {
+			t.Errorf("%s: %d bytes of %s, want %d", tt.name, len(body), w.Header().Get("Content-Type"), export.Size)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Errorf("%s: invalid archive: %v", tt.name, err)
+		} else {
+			checkUserActivity(t, zr, photo.ID)
+		}
+		if _, err := os.Stat(export.Path); !os.IsNotExist(err) {
+			t.Errorf("%s: the archive was not removed after the download", tt.name)
+		}
+	}
+}
+
+// checkUserActivity checks that the archive has the comment and the like of the user on the photo of another user
+func checkUserActivity(t *testing.T, zr *zip.Reader, photoID int) {
+	t.Helper()
+	var comments database.JsonificaComments
+	var likes archive.LikeList
+	for name, v := range map[string]interface{}{archive.UserCommentsFile: &comments, archive.UserLikesFile: &likes} {
+		f, err := zr.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(f).Decode(v)
+		_ = f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(comments.Items) != 1 || comments.Items[0].PhotoID != photoID || comments.Items[0].Content != "nice" {
+		t.Errorf("comments of the user: %+v", comments.Items)
+	}
+	if len(likes.Items) != 1 || likes.Items[0].PhotoID != photoID || likes.Items[0].Username != "alice" {
+		t.Errorf("likes of the user: %+v", likes.Items)
+	}
+}
@@ -1,237 +1,180 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
-	"strings"
-	"time"
+	"wasaPhoto/service/api/reqcontext"
 	"wasaPhoto/service/database"
-
-	"github.com/sirupsen/logrus"
+	"wasaPhoto/service/derivatives"
+	"wasaPhoto/service/imaging"
+	"wasaPhoto/service/storage"
+	"wasaPhoto/service/tracing"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-// log the error wrapper
-func logerr(n int, err error) {
-	if err != nil {
-		logrus.Printf("Write failed: %v", err)
-	}
-}
-
 // PRIMITIVE FUNCTIONS TO HELP THE HANDLERS
-// add responce code of success in the parameter
-func finalize(output interface{}, err error, w http.ResponseWriter, code int) {
-	// set response code of success
-	w.WriteHeader(code)
-	if err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		if json.NewEncoder(w).Encode(output) != nil {
-			w.WriteHeader(500)
-			logerr(w.Write([]byte("Internal Server Error")))
-		}
-	} else {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte(err.Error())))
-	}
-}
-func (rt *_router) youAreLogged(r *http.Request, w http.ResponseWriter) (bool, int) {
-	myID, err := strconv.Atoi(strings.Split(r.Header.Get("Authorization"), " ")[1])
+// finalize writes output as JSON with the response code of success, or answers err with sendDatabaseError
+func finalize(ctx reqcontext.RequestContext, output interface{}, err error, w http.ResponseWriter, code int) {
 	if err != nil {
-		w.WriteHeader(401)
-		logerr(w.Write([]byte("Non sei loggato")))
-		return true, 0
+		sendDatabaseError(ctx, w, err)
+		return
 	}
-	bool, err := rt.db.UserIsPresent(myID)
+	_, span := tracing.StartChild(ctx.Context, "encode JSON", tracing.KindInternal)
+	body, err := json.Marshal(output)
+	span.SetAttribute("json.bytes", len(body))
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		w.WriteHeader(500)
-		logerr(w.Write([]byte("server error")))
-		return true, 0
-	}
-	if !bool {
-		w.WriteHeader(401)
-		logerr(w.Write([]byte("non sei loggato")))
-		return true, 0
+		sendProblem(w, http.StatusInternalServerError, codeInternalError, "")
+		return
 	}
-	return false, myID
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	// a write error is logged by the responseWriter of the request
+	_, _ = w.Write(append(body, '\n'))
 }
 
-func (rt *_router) youAreBanned(myID int, banner int, r *http.Request, w http.ResponseWriter) bool {
-	bool, err := rt.db.UserIsBanned(banner, myID)
+// securityChecker answers 403 if the caller was banned by the user bannerID (or 500 if it can't be checked), and
+// returns true if the request must stop
+func (rt *_router) securityChecker(ctx reqcontext.RequestContext, bannerID int, w http.ResponseWriter) bool {
+	banned, err := ctx.DB.UserIsBanned(bannerID, ctx.UserID)
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("server error")))
-		return false
-	}
-	return bool
-}
-func (rt *_router) securityChecker(bannerID int, r *http.Request, w http.ResponseWriter) bool {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
+		sendInternalError(ctx, w, err)
 		return true
 	}
-	if rt.youAreBanned(myID, bannerID, r, w) {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("sei bannato")))
+	if banned {
+		sendProblem(w, http.StatusForbidden, codeBanned, "you were banned by this user")
 		return true
 	}
 	return false
 }
 
-func (rt *_router) getUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, _ := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) getUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	userID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	if rt.securityChecker(userID, r, w) {
-		return
-	}
-	user, err := rt.db.GetUserExtendedByID(userID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("user id not exist")))
+	if rt.securityChecker(ctx, userID, w) {
 		return
 	}
-	finalize(user, err, w, 200)
+	user, err := ctx.DB.GetUserExtendedByID(userID)
+	finalize(ctx, user, err, w, 200)
 }
-func (rt *_router) getUserPhotosHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, iAmId := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) getUserPhotosHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	iAmId := ctx.UserID
 	userID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	if rt.securityChecker(userID, r, w) {
+	if rt.securityChecker(ctx, userID, w) {
 		return
 	}
-	output, err := rt.db.GetPhotos(userID, iAmId)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("user id not exist")))
-		return
-	}
-	finalize(rt.db.JsonificaPhotosFun(output), err, w, 200)
+	output, err := ctx.DB.GetPhotos(userID, iAmId)
+	finalize(ctx, ctx.DB.JsonificaPhotosFun(output), err, w, 200)
 }
-func (rt *_router) getFollowersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, _ := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) getFollowersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	userID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	if rt.securityChecker(userID, r, w) {
+	if rt.securityChecker(ctx, userID, w) {
 		return
 	}
-	output, err := rt.db.GetFollowersID(userID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("user id not exist")))
-		return
-	}
-	finalize(output, err, w, 200)
+	output, err := ctx.DB.GetFollowersID(userID)
+	finalize(ctx, output, err, w, 200)
 }
 
-func (rt *_router) getFollowingHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, _ := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) getFollowingHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	userID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
-		return
-	}
-	if rt.securityChecker(userID, r, w) {
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	output, err := rt.db.GetFollowingID(userID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("user id not exist")))
+	if rt.securityChecker(ctx, userID, w) {
 		return
 	}
-	finalize(output, err, w, 200)
+	output, err := ctx.DB.GetFollowingID(userID)
+	finalize(ctx, output, err, w, 200)
 }
 
-func (rt *_router) searchUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (rt *_router) searchUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	// read params query
-	flag, userID := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+	userID := ctx.UserID
 
 	username_searched := r.URL.Query().Get("query")
 	if username_searched == "" {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("query is empty")))
+		invalidParameter(w, "query", "must not be empty")
 		return
 	}
-	output, err := rt.db.SearchUser(username_searched, userID)
-	finalize(rt.db.JsonificaUsersFun(output), err, w, 200)
+	output, err := ctx.DB.SearchUser(username_searched, userID)
+	finalize(ctx, ctx.DB.JsonificaUsersFun(output), err, w, 200)
 }
-func (rt *_router) getFeedHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, userID := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
-	output, err := rt.db.GetFeed(userID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("user id not exist")))
-		return
-	}
-	finalize(output, err, w, 200)
+func (rt *_router) getFeedHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ctx.UserID
+	output, err := ctx.DB.GetFeed(userID)
+	finalize(ctx, output, err, w, 200)
 }
-func (rt *_router) getPhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, _ := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) getPhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	id, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	photo, err := rt.db.GetPhoto(id)
+	photo, err := ctx.DB.GetPhoto(id)
 	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("photo not found in db")))
+		sendDatabaseError(ctx, w, err)
 		return
 	}
 	// check if user is authorized to see the photo
-	if rt.securityChecker(photo.UserID, r, w) {
+	if rt.securityChecker(ctx, photo.UserID, w) {
 		return
 	}
-	// read photo from disk
-	file, err := os.Open(photo.Photourl)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("photo not found")))
-		return
+	// the original, or a derivative when it's ready
+	key, contentType := photo.Photourl, photo.MimeType
+	name := r.URL.Query().Get("size")
+	if name == "original" {
+		// the upload with its metadata, kept only if it differs from the published image
+		if ctx.UserID != photo.UserID {
+			sendProblem(w, http.StatusForbidden, codeForbidden, "only the owner can download the original of a photo")
+			return
+		}
+		key = derivatives.OriginalKey(photo.Photourl)
+		_, err := rt.blobs.Stat(ctx.Context, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			key = photo.Photourl
+		} else if err != nil {
+			sendInternalError(ctx, w, err)
+			return
+		}
+	} else if name != "" {
+		size, ok := derivatives.ByName(name)
+		if !ok {
+			invalidParameter(w, "size", "must be one of thumb, small, large, original")
+			return
+		}
+		switch photo.Derivatives {
+		case database.READY:
+			key, contentType = derivatives.Key(photo.Photourl, size), "image/jpeg"
+		case database.PENDING:
+			w.Header().Set("Retry-After", "5")
+			sendProblem(w, http.StatusConflict, codeDerivativeNotReady, "the "+size.Name+" size is not ready yet")
+			return
+		}
+		// FAILED: the original is served instead
 	}
-	fi, err := file.Stat()
+	// read photo from the storage
+	file, info, err := rt.blobs.Get(ctx.Context, key)
 	if err != nil {
-		w.WriteHeader(500)
-		logerr(w.Write([]byte("internal error reading data of the photo")))
+		sendInternalError(ctx, w, err)
 		return
 	}
 	defer file.Close()
@@ -241,92 +184,217 @@ func (rt *_router) getPhotoHandler(w http.ResponseWriter, r *http.Request, ps ht
 	w.Header().Set("Filename", photo.Title)
 	w.Header().Set("Description", photo.Description)
 	w.Header().Set("CreatedAt", photo.CreatedAt.String())
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Content-Length", strconv.Itoa(int(fi.Size())))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
 	if _, err := io.Copy(w, file); err != nil {
-		w.WriteHeader(500)
-		logerr(w.Write([]byte("internal error writing photo to response")))
+		// the response was already started
+		ctx.Logger.WithError(err).Warning("photo download interrupted")
+	}
+}
+func (rt *_router) getAllCommentsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
+	output, err := ctx.DB.GetCommentsByPhotoID(id)
+	finalize(ctx, ctx.DB.JsonificaCommentsFun(output), err, w, 200)
 }
-func (rt *_router) getAllCommentsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, _ := rt.youAreLogged(r, w)
-	if flag {
+
+func (rt *_router) getSessionsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	id, err := strconv.Atoi(ps.ByName("id"))
+	if ctx.UserID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't see the sessions of another user")
+		return
+	}
+	sessions, err := ctx.DB.GetSessionsByUserID(userID)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	output := sessionList{Items: []sessionInfo{}}
+	for _, session := range sessions {
+		output.Items = append(output.Items, sessionInfo{
+			ID:        session.ID,
+			CreatedAt: session.CreatedAt,
+			LastSeen:  session.LastSeen,
+			ExpiresAt: session.ExpiresAt,
+			UserAgent: session.UserAgent,
+			IP:        session.IP,
+			Current:   session.ID == ctx.Session.ID,
+		})
+	}
+	finalize(ctx, output, err, w, 200)
+}
+
+func (rt *_router) getAPIKeysHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	userID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("photo id is empty")))
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if myID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't see the API keys of another user")
 		return
 	}
-	output, err := rt.db.GetCommentsByPhotoID(id)
+	keys, err := ctx.DB.GetAPIKeysByUserID(userID)
 	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("photo not found in db")))
+		sendInternalError(ctx, w, err)
 		return
 	}
-	finalize(rt.db.JsonificaCommentsFun(output), err, w, 200)
+	output := apiKeyList{Items: []apiKeyInfo{}}
+	for _, key := range keys {
+		output.Items = append(output.Items, newAPIKeyInfo(key))
+	}
+	finalize(ctx, output, err, w, 200)
 }
 
 // POST REQUEST
-func (rt *_router) loginHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	user1 := database.User{}
-	err := json.NewDecoder(r.Body).Decode(&user1)
+func (rt *_router) loginHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	credentials := credentials{}
+	err := json.NewDecoder(r.Body).Decode(&credentials)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		invalidBody(w, err)
 		return
 	}
 	code := 200
-	user, err := rt.db.GetUserByUsername(user1.Username)
-	if err != nil {
-		user, err = rt.db.AddUser(user1.Username)
+	user, err := ctx.DB.GetUserByUsername(credentials.Username)
+	if rt.authMode == AuthModePassword {
+		// in password mode accounts are only created by registerHandler
+		valid := false
+		if err == nil {
+			valid, err = rt.checkPassword(ctx, user.ID, credentials.Password)
+		} else if errors.Is(err, database.ErrNotFound) {
+			valid = checkDummyPassword(credentials.Password)
+		}
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			sendInternalError(ctx, w, err)
+			return
+		}
+		if !valid {
+			sendProblem(w, http.StatusUnauthorized, codeInvalidCredentials, "wrong username or password")
+			return
+		}
+	} else if errors.Is(err, database.ErrNotFound) {
+		// in username mode unknown usernames are new accounts, so they must respect the policy
+		var username string
+		username, err = rt.checkUsername(ctx, credentials.Username, 0)
+		if err != nil {
+			usernameError(ctx, w, err)
+			return
+		}
+		user, err = ctx.DB.AddUser(username)
+		if err != nil {
+			usernameError(ctx, w, err)
+			return
+		}
 		code = 201
 	}
-	finalize(user, err, w, code)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	rt.sendSession(ctx, w, r, user, code)
 }
 
-func (rt *_router) changeMyNameHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
+// sendSession opens a new session for the user and writes it as the response
+func (rt *_router) sendSession(ctx reqcontext.RequestContext, w http.ResponseWriter, r *http.Request, user database.User, code int) {
+	session, token, err := rt.newSession(ctx, r, user.ID)
+	if err != nil {
+		sendInternalError(ctx, w, err)
 		return
 	}
-	id, err := strconv.Atoi(ps.ByName("id"))
-	if myID != id {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi cambiare il nome di un altro utente")))
+	finalize(ctx, loginResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Token:     token,
+		ExpiresAt: session.ExpiresAt,
+	}, err, w, code)
+}
+
+func (rt *_router) registerHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if rt.authMode != AuthModePassword {
+		sendProblem(w, http.StatusForbidden, codeFeatureDisabled, "registration is disabled, log in with POST /session")
+		return
+	}
+	credentials := credentials{}
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		invalidBody(w, err)
+		return
+	}
+	username, err := rt.checkUsername(ctx, credentials.Username, 0)
+	if err != nil {
+		usernameError(ctx, w, err)
+		return
+	}
+	hash, err := hashPassword(credentials.Password)
+	if err != nil {
+		sendProblem(w, http.StatusBadRequest, codeValidationFailed, err.Error(),
+			fieldError{Field: "Password", Message: err.Error()})
 		return
 	}
+	user, err := ctx.DB.AddUserWithPassword(username, hash)
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		usernameError(ctx, w, err)
+		return
+	}
+	rt.sendSession(ctx, w, r, user, 201)
+}
+
+func (rt *_router) changeMyNameHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if myID != id {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't change the username of another user")
 		return
 	}
 	user := database.User{}
 	err = json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		invalidBody(w, err)
 		return
 	}
-	output, err := rt.db.UpdateUser(id, user.Username)
+	username, err := rt.checkUsername(ctx, user.Username, id)
 	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("user not found in db")))
+		usernameError(ctx, w, err)
 		return
 	}
-	finalize(output, err, w, 201)
-}
-func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, userID := rt.youAreLogged(r, w)
-	if flag {
+	output, err := ctx.DB.UpdateUser(id, username)
+	if err != nil {
+		usernameError(ctx, w, err)
 		return
 	}
-	// send a form multipart with 3 fields: title, description, photo(jpeg)
-	// read the form
+	finalize(ctx, output, err, w, 201)
+}
+
+// photoFormOverhead is the size allowed to an upload in addition to the photo: the multipart headers, the title and
+// the description
+const photoFormOverhead = 64 << 10
+
+func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ctx.UserID
+	// send a form multipart with 3 fields: title, description, photo(jpeg, png, gif or webp)
+	// read the form, without writing more than the largest photo to the temporary files
+	maxBody := rt.photoMaxSize + photoFormOverhead
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
 	err := r.ParseMultipartForm(100)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		sendProblem(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, "the request is larger than "+strconv.FormatInt(maxBody, 10)+" bytes")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("error parsing form")))
+		invalidBody(w, err)
 		return
 	}
 	// read the fields
@@ -335,311 +403,414 @@ func (rt *_router) uploadPhotoHandler(w http.ResponseWriter, r *http.Request, ps
 	// write the file
 	file, _, err := r.FormFile("photo")
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("error reading photo")))
+		sendProblem(w, http.StatusBadRequest, codeValidationFailed, "the photo is missing", fieldError{Field: "photo", Message: "is required"})
 		return
 	}
 	defer file.Close()
-	// store uploaded file into local path
-	imageUrl := "/tmp/images/" + strconv.Itoa(userID) + "_" + strconv.Itoa(int(time.Now().Unix())) + ".jpg"
-	f, err := os.Create(imageUrl)
+	// the format is detected from the content, and the whole image is decoded to reject corrupt files
+	data, err := io.ReadAll(io.LimitReader(file, rt.photoMaxSize+1))
 	if err != nil {
-		w.WriteHeader(500)
-		logerr(w.Write([]byte("internal error create file")))
+		sendInternalError(ctx, w, err)
 		return
 	}
-	defer f.Close()
-
-	// copy the uploaded file into the local filesystem
-	_, err = io.Copy(f, file)
+	if int64(len(data)) > rt.photoMaxSize {
+		sendProblem(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, "the photo is larger than "+strconv.FormatInt(rt.photoMaxSize, 10)+" bytes")
+		return
+	}
+	img, image, err := imaging.Decode(data, rt.photoMaxPixels)
+	if err != nil {
+		sendImageError(ctx, w, err)
+		return
+	}
+	settings, err := ctx.DB.GetUserSettings(userID)
+	if err != nil {
+		sendDatabaseError(ctx, w, err)
+		return
+	}
+	// the published image has no metadata (unless the user keeps it) and is already oriented
+	clean, cleanInfo, err := imaging.Sanitize(data, img, image, imaging.SanitizeOptions{KeepExif: settings.KeepMetadata})
 	if err != nil {
-		w.WriteHeader(500)
-		logerr(w.Write([]byte("internal error copy file")))
+		sendImageError(ctx, w, err)
 		return
 	}
-	output, err := rt.db.AddPhoto(userID, imageUrl, title, description)
-	finalize(output, err, w, 201)
+	output, err := rt.storePhoto(ctx, userID, clean, cleanInfo, data, title, description)
+	if err == nil {
+		// the derivatives are rendered in background: their status is in output.Derivatives
+		rt.queueDerivatives(output.ID)
+	}
+	finalize(ctx, output, err, w, 201)
 }
 
-func (rt *_router) addCommentHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, userID := rt.youAreLogged(r, w)
-	if flag {
-		return
+// storePhoto writes the image under a new key, and the original upload next to it if it's different, then adds the
+// photo. If the photo can't be added the images are deleted, so a failed upload leaves nothing behind; fsck removes
+// what a crash between the steps leaves.
+func (rt *_router) storePhoto(ctx reqcontext.RequestContext, userID int, data []byte, image imaging.Info, original []byte, title string, description string) (database.Photo, error) {
+	key, err := storage.NewKey(strconv.Itoa(userID), image.Format.Ext)
+	if err != nil {
+		return database.Photo{}, err
+	}
+	info, err := rt.blobs.Put(ctx.Context, key, bytes.NewReader(data))
+	if err != nil {
+		return database.Photo{}, err
+	}
+	rt.countUpload(info.Size)
+	if !bytes.Equal(data, original) {
+		_, err = rt.blobs.Put(ctx.Context, derivatives.OriginalKey(key), bytes.NewReader(original))
+	}
+	var photo database.Photo
+	if err == nil {
+		photo, err = ctx.DB.AddPhoto(userID, key, image.Format.MimeType, image.Width, image.Height, title, description)
 	}
+	if err != nil {
+		rt.deleteImages(context.Background(), ctx.Logger, key)
+		return database.Photo{}, err
+	}
+	return photo, nil
+}
+
+func (rt *_router) addCommentHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ctx.UserID
 	id, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
 	comment := database.Comment{}
 	err = json.NewDecoder(r.Body).Decode(&comment)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		invalidBody(w, err)
+		return
+	}
+	output, err := ctx.DB.AddComment(id, userID, comment.Content)
+	finalize(ctx, output, err, w, 201)
+}
+
+func (rt *_router) addAPIKeyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	userID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	output, err := rt.db.AddComment(id, userID, comment.Content)
-	finalize(output, err, w, 201)
+	if myID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't create API keys for another user")
+		return
+	}
+	request := apiKeyRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		invalidBody(w, err)
+		return
+	}
+	var fields []fieldError
+	if request.Name == "" {
+		fields = append(fields, fieldError{Field: "Name", Message: "is required"})
+	}
+	if len(request.Scopes) == 0 {
+		fields = append(fields, fieldError{Field: "Scopes", Message: "is required"})
+	}
+	for _, scope := range request.Scopes {
+		if !hasScope(apiKeyScopes, scope) {
+			fields = append(fields, fieldError{Field: "Scopes", Message: "unknown scope " + scope})
+		}
+	}
+	if len(fields) > 0 {
+		sendProblem(w, http.StatusBadRequest, codeValidationFailed, "the API key is not valid", fields...)
+		return
+	}
+	output, err := rt.newAPIKey(ctx, userID, request.Name, request.Scopes)
+	finalize(ctx, output, err, w, 201)
 }
 
 // PUT REQUEST
-func (rt *_router) likePhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
+func (rt *_router) changePasswordHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
+	if myID != id {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't change the password of another user")
+		return
+	}
+	if rt.authMode != AuthModePassword {
+		sendProblem(w, http.StatusForbidden, codeFeatureDisabled, "passwords are disabled")
+		return
+	}
+	change := passwordChange{}
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		invalidBody(w, err)
+		return
+	}
+	// accounts created in username mode have no password yet, so they can set one without the old one
+	oldHash, err := ctx.DB.GetPasswordHash(id)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	if oldHash != "" {
+		valid, err := rt.checkPassword(ctx, id, change.OldPassword)
+		if err != nil {
+			sendInternalError(ctx, w, err)
+			return
+		}
+		if !valid {
+			sendProblem(w, http.StatusForbidden, codeWrongPassword, "the old password is wrong", fieldError{Field: "OldPassword", Message: "is wrong"})
+			return
+		}
+	}
+	hash, err := hashPassword(change.NewPassword)
+	if err != nil {
+		sendProblem(w, http.StatusBadRequest, codeValidationFailed, err.Error(),
+			fieldError{Field: "NewPassword", Message: err.Error()})
+		return
+	}
+	// the other sessions and the API keys may have been obtained by someone who knew the old password
+	output, err := ctx.DB.SetPasswordHash(id, hash, ctx.Session.ID)
+	finalize(ctx, output, err, w, 200)
+}
+
+func (rt *_router) likePhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	userID, err := strconv.Atoi(ps.ByName("userId"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "userId", "must be an integer")
 		return
 	}
 	if myID != userID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi mettere like da un altro utente")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't like on behalf of another user")
 		return
 	}
 	photoID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	output, err := rt.db.AddLike(photoID, userID)
-	finalize(output, err, w, 201)
-}
-func (rt *_router) followUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
+	output, err := ctx.DB.AddLike(photoID, userID)
+	if errors.Is(err, database.ErrConflict) {
+		// PUT is idempotent: liking again is not an error
+		finalize(ctx, database.Like{UserID: userID, PhotoID: photoID}, nil, w, 200)
 		return
 	}
+	finalize(ctx, output, err, w, 201)
+}
+func (rt *_router) followUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	followerID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
 	followingID, err := strconv.Atoi(ps.ByName("followId"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("follow id is empty")))
+		invalidParameter(w, "followId", "must be an integer")
 		return
 	}
 	if myID != followerID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi seguire utilizzando un altro utente")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't follow on behalf of another user")
 		return
 	}
-	if rt.youAreBanned(myID, followingID, r, w) {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi seguire un utente che ti ha bannato")))
+	if rt.securityChecker(ctx, followingID, w) {
 		return
 	}
-	output, err := rt.db.AddFollow(followerID, followingID)
-	finalize(output, err, w, 201)
-}
-
-func (rt *_router) banUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
+	output, err := ctx.DB.AddFollow(followerID, followingID)
+	if errors.Is(err, database.ErrConflict) {
+		// PUT is idempotent: following again is not an error
+		finalize(ctx, database.Follow{FollowerID: followerID, FollowingID: followingID}, nil, w, 200)
 		return
 	}
+	finalize(ctx, output, err, w, 201)
+}
+
+func (rt *_router) banUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	bannerID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
 	if myID != bannerID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi bannare utilizzando un altro utente")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't ban on behalf of another user")
 		return
 	}
 	bannedID, err := strconv.Atoi(ps.ByName("banId"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("ban id is empty")))
+		invalidParameter(w, "banId", "must be an integer")
 		return
 	}
-	output, err := rt.db.AddBan(bannedID, bannerID)
-	finalize(output, err, w, 201)
+	output, err := ctx.DB.AddBan(bannedID, bannerID)
+	if errors.Is(err, database.ErrConflict) {
+		// PUT is idempotent: banning again is not an error
+		finalize(ctx, database.Ban{BannedID: bannedID, BannerID: bannerID}, nil, w, 200)
+		return
+	}
+	finalize(ctx, output, err, w, 201)
 }
 
 // DELETE REQUEST
-/*func (rt *_router) deleteUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
+func (rt *_router) logoutHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	output, err := ctx.DB.RevokeSession(ctx.Session.ID)
+	finalize(ctx, output, err, w, 200)
+}
+
+func (rt *_router) deleteSessionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if ctx.UserID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't close the sessions of another user")
+		return
+	}
+	session, err := ctx.DB.GetSession(ps.ByName("sessionId"))
+	if err == nil && session.UserID != userID {
+		err = &database.Error{Kind: database.ErrNotFound, What: "session"}
+	}
+	if err != nil {
+		sendDatabaseError(ctx, w, err)
 		return
 	}
+	output, err := ctx.DB.RevokeSession(session.ID)
+	finalize(ctx, output, err, w, 200)
+}
+
+func (rt *_router) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	userID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
 	if myID != userID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi cancellare un utente che non ti appartiene")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't revoke the API keys of another user")
 		return
 	}
-	output, err := rt.db.DeleteUser(userID)
+	key, err := ctx.DB.GetAPIKey(ps.ByName("keyId"))
+	if err == nil && key.UserID != userID {
+		err = &database.Error{Kind: database.ErrNotFound, What: "API key"}
+	}
 	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("user id not exist")))
+		sendDatabaseError(ctx, w, err)
 		return
 	}
-	finalize(output, err, w, 200)
+	output, err := ctx.DB.RevokeAPIKey(key.ID)
+	finalize(ctx, output, err, w, 200)
 }
-*/
 
-func (rt *_router) deleteCommentHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
+// deleteAllSessionsHandler logs the user out everywhere, including the session making the request
+func (rt *_router) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
+	if ctx.UserID != userID {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't close the sessions of another user")
+		return
+	}
+	output, err := ctx.DB.RevokeUserSessions(userID)
+	finalize(ctx, output, err, w, 200)
+}
+
+func (rt *_router) deleteCommentHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	commentID, err := strconv.Atoi(ps.ByName("commentId"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("comment id is empty")))
+		invalidParameter(w, "commentId", "must be an integer")
 		return
 	}
-	comment, err := rt.db.GetCommentByID(commentID)
+	comment, err := ctx.DB.GetCommentByID(commentID)
 	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("comment not found")))
+		sendDatabaseError(ctx, w, err)
 		return
 	}
 	if comment.UserID != myID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi cancellare un commento che non ti appartiene")))
-		return
-	}
-	output, err := rt.db.DeleteComment(commentID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("comment not found")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't delete the comment of another user")
 		return
 	}
-	finalize(output, err, w, 200)
+	output, err := ctx.DB.DeleteComment(commentID)
+	finalize(ctx, output, err, w, 200)
 }
-func (rt *_router) deletePhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) deletePhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	photoID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("photo id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
-	photo, err := rt.db.GetPhoto(photoID)
+	photo, err := ctx.DB.GetPhoto(photoID)
 	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("photo not found")))
+		sendDatabaseError(ctx, w, err)
 		return
 	}
 	if photo.UserID != myID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi cancellare una foto che non ti appartiene")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't delete the photo of another user")
 		return
 	}
-	output, err := rt.db.DeletePhoto(photoID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("photo not found")))
-		return
+	output, err := ctx.DB.DeletePhoto(photoID)
+	if err == nil {
+		rt.deleteImages(ctx.Context, ctx.Logger, photo.Photourl)
 	}
-	finalize(output, err, w, 200)
+	finalize(ctx, output, err, w, 200)
 }
-func (rt *_router) unlikePhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) unlikePhotoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	photoID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("photo id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
 	userID, err := strconv.Atoi(ps.ByName("userId"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("user id is empty")))
+		invalidParameter(w, "userId", "must be an integer")
 		return
 	}
 	if myID != userID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi levare un like che non hai messo tu")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't remove the like of another user")
 		return
 	}
-	output, err := rt.db.DeleteLike(photoID, userID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("like not found")))
-		return
-	}
-	finalize(output, err, w, 200)
+	output, err := ctx.DB.DeleteLike(photoID, userID)
+	finalize(ctx, output, err, w, 200)
 }
-func (rt *_router) unfollowUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) unfollowUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	followerID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("follower id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
 	if myID != followerID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi smettere di seguire da un account che non ti appartiene")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't unfollow on behalf of another user")
 		return
 	}
 	followingID, err := strconv.Atoi(ps.ByName("followId"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("following id is empty")))
+		invalidParameter(w, "followId", "must be an integer")
 		return
 	}
-	output, err := rt.db.DeleteFollow(followerID, followingID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("follow not exist")))
-		return
-	}
-	finalize(output, err, w, 200)
+	output, err := ctx.DB.DeleteFollow(followerID, followingID)
+	finalize(ctx, output, err, w, 200)
 }
 
-func (rt *_router) unbanUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	flag, myID := rt.youAreLogged(r, w)
-	if flag {
-		return
-	}
+func (rt *_router) unbanUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
 	bannerID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("banner id is empty")))
+		invalidParameter(w, "id", "must be an integer")
 		return
 	}
 	if myID != bannerID {
-		w.WriteHeader(403)
-		logerr(w.Write([]byte("Non puoi sbannare da un account che non ti appartiene")))
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't unban on behalf of another user")
 		return
 	}
 	bannedID, err := strconv.Atoi(ps.ByName("banId"))
 	if err != nil {
-		w.WriteHeader(400)
-		logerr(w.Write([]byte("banned id is empty")))
-		return
-	}
-	output, err := rt.db.DeleteBan(bannedID, bannerID)
-	if err != nil {
-		w.WriteHeader(404)
-		logerr(w.Write([]byte("ban not exist")))
+		invalidParameter(w, "banId", "must be an integer")
 		return
 	}
-	finalize(output, err, w, 200)
+	output, err := ctx.DB.DeleteBan(bannedID, bannerID)
+	finalize(ctx, output, err, w, 200)
 }
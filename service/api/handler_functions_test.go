@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"wasaPhoto/service/database"
+)
+
+// uploadRequest returns a POST /photos request with the photo in a multipart form
+func uploadRequest(t *testing.T, token string, photo []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("title", "title"); err != nil {
+		t.Fatal(err)
+	}
+	part, err := mw.CreateFormFile("photo", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(photo); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/photos", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestUploadPhotoSize(t *testing.T) {
+	const maxSize = 4 << 10
+	rt := newTestRouter(t, func(cfg *Config) { cfg.PhotoMaxSize = maxSize })
+	h := rt.Handler()
+	_, token := login(t, h, "alice")
+	var small bytes.Buffer
+	if err := png.Encode(&small, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		photo  []byte
+		status int
+		// detail is the start of the detail of the problem
+		detail string
+	}{
+		{"small photo", small.Bytes(), http.StatusCreated, ""},
+		{"photo larger than the limit", make([]byte, maxSize+1), http.StatusRequestEntityTooLarge, "the photo is larger"},
+		{"request larger than the limit", make([]byte, maxSize+photoFormOverhead), http.StatusRequestEntityTooLarge, "the request is larger"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, uploadRequest(t, token, tt.photo))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.detail == "" {
+			continue
+		}
+		var p problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil || p.Code != codeImageTooLarge || !strings.HasPrefix(p.Detail, tt.detail) {
+			t.Errorf("%s: problem %+v, %v", tt.name, p, err)
+		}
+	}
+}
+
+// TestRepeatedPut checks that liking, following and banning again succeeds without changing anything
+func TestRepeatedPut(t *testing.T) {
+	var db database.AppDatabase
+	var photo database.Photo
+	rt := newTestRouter(t, func(cfg *Config) {
+		db = cfg.Database
+		photo = addPhoto(t, db, "bob")
+	})
+	h := rt.Handler()
+	aliceID, token := login(t, h, "alice")
+	bobID, _ := login(t, h, "bob")
+	carolID, _ := login(t, h, "carol")
+
+	likes := func() int {
+		photos, err := db.GetPhotos(photo.UserID, aliceID)
+		if err != nil || len(photos) != 1 {
+			t.Fatalf("photos %+v, %v", photos, err)
+		}
+		return photos[0].Likes
+	}
+	user := func(id int) database.UserExtended {
+		user, err := db.GetUserExtendedByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	tests := []struct {
+		name   string
+		target string
+		count  func() int
+	}{
+		{"like", "/photos/" + strconv.Itoa(photo.ID) + "/like/" + strconv.Itoa(aliceID), likes},
+		{"follow", "/users/" + strconv.Itoa(aliceID) + "/follow/" + strconv.Itoa(bobID), func() int { return user(bobID).Followers }},
+		{"ban", "/users/" + strconv.Itoa(aliceID) + "/ban/" + strconv.Itoa(carolID), func() int { return user(aliceID).Banned }},
+	}
+	for _, tt := range tests {
+		if w := do(t, h, http.MethodPut, tt.target, token, nil); w.Code != http.StatusCreated {
+			t.Fatalf("%s: status %d, body %s", tt.name, w.Code, w.Body)
+		}
+		count := tt.count()
+		if count != 1 {
+			t.Errorf("%s: count %d after the first PUT", tt.name, count)
+		}
+		first := do(t, h, http.MethodPut, tt.target, token, nil)
+		if first.Code != http.StatusOK {
+			t.Errorf("%s again: status %d, body %s", tt.name, first.Code, first.Body)
+		}
+		for i := 0; i < 2; i++ {
+			w := do(t, h, http.MethodPut, tt.target, token, nil)
+			if w.Code != http.StatusOK || w.Body.String() != first.Body.String() {
+				t.Errorf("%s again: status %d, body %s", tt.name, w.Code, w.Body)
+			}
+		}
+		if got := tt.count(); got != count {
+			t.Errorf("%s: count %d after the repeated PUTs, want %d", tt.name, got, count)
+		}
+	}
+}
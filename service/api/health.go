@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Status of the health checks
+const (
+	healthUp   = "UP"
+	healthDown = "DOWN"
+)
+
+// healthCheck is the result of the check of a dependency. Error is a short reason for humans, the cause is logged.
+type healthCheck struct {
+	Status  string
+	Error   string      `json:",omitempty"`
+	Details interface{} `json:",omitempty"`
+}
+
+type readinessReport struct {
+	Status string
+	Checks map[string]healthCheck
+}
+
+type workersInfo struct {
+	Running             int
+	Expected            int
+	ExportQueue         int
+	ExportQueueSize     int
+	DerivativeQueue     int
+	DerivativeQueueSize int
+	LastMaintenance     *time.Time `json:",omitempty"`
+}
+
+// livenessHandler answers 200 while the process is able to serve requests
+func (rt *_router) livenessHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	finalize(ctx, healthCheck{Status: healthUp}, nil, w, 200)
+}
+
+// readinessHandler checks the dependencies of the service, and answers 503 with the failed ones if it can't serve
+// requests. It's also not ready while shutting down.
+func (rt *_router) readinessHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	report := readinessReport{
+		Status: healthUp,
+		Checks: map[string]healthCheck{
+			"database": rt.checkDatabase(ctx),
+			"storage":  rt.checkStorage(ctx),
+			"workers":  rt.checkWorkers(),
+		},
+	}
+	code := 200
+	for _, check := range report.Checks {
+		if check.Status != healthUp {
+			report.Status = healthDown
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	finalize(ctx, report, nil, w, code)
+}
+
+func (rt *_router) checkDatabase(ctx reqcontext.RequestContext) healthCheck {
+	if err := ctx.DB.Ping(); err != nil {
+		ctx.Logger.WithError(err).Warning("readiness: database ping failed")
+		return healthCheck{Status: healthDown, Error: "ping failed"}
+	}
+	return healthCheck{Status: healthUp}
+}
+
+// checkStorage writes and deletes a blob in the image storage
+func (rt *_router) checkStorage(ctx reqcontext.RequestContext) healthCheck {
+	key := ".readiness-" + ctx.ReqUUID.String()
+	if _, err := rt.blobs.Put(ctx.Context, key, strings.NewReader("ok")); err != nil {
+		ctx.Logger.WithError(err).Warning("readiness: image storage not writable")
+		return healthCheck{Status: healthDown, Error: "not writable"}
+	}
+	if err := rt.blobs.Delete(ctx.Context, key); err != nil {
+		ctx.Logger.WithError(err).Warning("readiness: can't delete the test blob from the image storage")
+		return healthCheck{Status: healthDown, Error: "not writable"}
+	}
+	return healthCheck{Status: healthUp}
+}
+
+// checkWorkers fails if a background goroutine stopped, or the maintenance did not run for two intervals
+func (rt *_router) checkWorkers() healthCheck {
+	info := workersInfo{
+		Running:             int(atomic.LoadInt32(&rt.runningWorkers)),
+		Expected:            expectedWorkers,
+		ExportQueue:         len(rt.exportQueue),
+		ExportQueueSize:     cap(rt.exportQueue),
+		DerivativeQueue:     len(rt.derivativeQueue),
+		DerivativeQueueSize: cap(rt.derivativeQueue),
+	}
+	if last := atomic.LoadInt64(&rt.lastMaintenance); last != 0 {
+		t := time.Unix(0, last).UTC()
+		info.LastMaintenance = &t
+	}
+	check := healthCheck{Status: healthUp, Details: info}
+	select {
+	case <-rt.done:
+		check.Status, check.Error = healthDown, "shutting down"
+		return check
+	default:
+	}
+	switch {
+	case info.Running < info.Expected:
+		check.Status, check.Error = healthDown, "a worker stopped"
+	case info.LastMaintenance != nil && globaltime.Now().Sub(*info.LastMaintenance) > 2*maintenanceInterval:
+		check.Status, check.Error = healthDown, "maintenance not running"
+	}
+	return check
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"wasaPhoto/service/database"
+)
+
+// pingFailingDB is a database whose ping fails
+type pingFailingDB struct {
+	database.AppDatabase
+}
+
+func (db pingFailingDB) Ping() error {
+	return errors.New("unable to open database file")
+}
+
+func (db pingFailingDB) WithContext(ctx context.Context) database.AppDatabase {
+	return pingFailingDB{db.AppDatabase.WithContext(ctx)}
+}
+
+// probe sends the health check and returns its status and report
+func probe(t *testing.T, h http.Handler, path string) (int, readinessReport) {
+	t.Helper()
+	w := do(t, h, http.MethodGet, path, "", nil)
+	var report readinessReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("%s: status %d, %v", path, w.Code, err)
+	}
+	return w.Code, report
+}
+
+func TestHealth(t *testing.T) {
+	h := newTestRouter(t, nil).Handler()
+	if status, report := probe(t, h, "/liveness"); status != http.StatusOK || report.Status != healthUp {
+		t.Errorf("liveness: %d, %+v", status, report)
+	}
+	status, report := probe(t, h, "/readiness")
+	if status != http.StatusOK || report.Status != healthUp {
+		t.Errorf("readiness: %d, %+v", status, report)
+	}
+	for _, name := range []string{"database", "storage", "workers"} {
+		if report.Checks[name].Status != healthUp {
+			t.Errorf("readiness of %s: %+v", name, report.Checks[name])
+		}
+	}
+}
+
+func TestReadinessDatabaseDown(t *testing.T) {
+	h := newTestRouter(t, func(cfg *Config) { cfg.Database = pingFailingDB{cfg.Database} }).Handler()
+	status, report := probe(t, h, "/readiness")
+	if status != http.StatusServiceUnavailable || report.Status != healthDown ||
+		report.Checks["database"] != (healthCheck{Status: healthDown, Error: "ping failed"}) {
+		t.Errorf("readiness: %d, %+v", status, report)
+	}
+	if report.Checks["storage"].Status != healthUp {
+		t.Errorf("readiness of the storage: %+v", report.Checks["storage"])
+	}
+	if status, report := probe(t, h, "/liveness"); status != http.StatusOK || report.Status != healthUp {
+		t.Errorf("liveness: %d, %+v", status, report)
+	}
+}
+
+func TestReadinessDraining(t *testing.T) {
+	rt := newTestRouter(t, nil)
+	h := rt.Handler()
+	if err := rt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	status, report := probe(t, h, "/readiness")
+	if status != http.StatusServiceUnavailable || report.Status != healthDown ||
+		report.Checks["workers"].Status != healthDown || report.Checks["workers"].Error != "shutting down" {
+		t.Errorf("readiness: %d, %+v", status, report)
+	}
+	if status, report := probe(t, h, "/liveness"); status != http.StatusOK || report.Status != healthUp {
+		t.Errorf("liveness: %d, %+v", status, report)
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/metrics"
+	"wasaPhoto/service/storage"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// storageUsageTTL is how long the usage of the image storage is cached, since it's computed by listing the store
+const storageUsageTTL = time.Minute
+
+// databaseBuckets are the upper bounds, in seconds, of the histogram of the database calls
+var databaseBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+type routerMetrics struct {
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	uploadBytes     *metrics.CounterVec
+
+	storageMu    sync.Mutex
+	storageAt    time.Time
+	storageBytes float64
+	storageFiles float64
+}
+
+// registerMetrics creates the metrics of the router in reg, and wraps the database to measure its calls
+func (rt *_router) registerMetrics(reg *metrics.Registry) {
+	rt.metrics = &routerMetrics{
+		requests: reg.NewCounterVec("wasaphoto_http_requests_total",
+			"Number of HTTP requests by route pattern and status code.", "method", "route", "status"),
+		requestDuration: reg.NewHistogramVec("wasaphoto_http_request_duration_seconds",
+			"Duration of the HTTP requests by route pattern and status code.", metrics.DefaultBuckets, "method", "route", "status"),
+		uploadBytes: reg.NewCounterVec("wasaphoto_upload_bytes_total", "Bytes of the uploaded photos."),
+	}
+
+	calls := reg.NewHistogramVec("wasaphoto_db_call_duration_seconds",
+		"Duration of the calls to the database by method.", databaseBuckets, "method")
+	callErrors := reg.NewCounterVec("wasaphoto_db_call_errors_total",
+		"Number of calls to the database that failed, by method. Expected outcomes like not found are not counted.", "method")
+	rt.db = database.Observe(rt.db, func(ctx context.Context, method string) (context.Context, func(error)) {
+		start := time.Now()
+		return ctx, func(err error) {
+			calls.Observe(time.Since(start).Seconds(), method)
+			var expected *database.Error
+			if err != nil && !errors.As(err, &expected) {
+				callErrors.Inc(method)
+			}
+		}
+	})
+
+	reg.NewGaugeFunc("wasaphoto_storage_bytes", "Size of the images in the storage.", func() float64 {
+		bytes, _ := rt.storageUsage()
+		return bytes
+	})
+	reg.NewGaugeFunc("wasaphoto_storage_files", "Number of images in the storage.", func() float64 {
+		_, files := rt.storageUsage()
+		return files
+	})
+	reg.NewGaugeVecFunc("wasaphoto_objects", "Number of active users, and of photos, likes and comments.", "type",
+		func() map[string]float64 {
+			counts, err := rt.db.GetObjectCounts()
+			if err != nil {
+				rt.baseLogger.WithError(err).Warning("can't count the objects for the metrics")
+				return nil
+			}
+			return map[string]float64{
+				"users":    float64(counts.Users),
+				"photos":   float64(counts.Photos),
+				"likes":    float64(counts.Likes),
+				"comments": float64(counts.Comments),
+			}
+		})
+}
+
+// statusWriter records the status code, the size and the route pattern of a response, and the request ID and the
+// caller once known, for the metrics, the traces and the access log
+type statusWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int64
+	route     string
+	requestID string
+	userID    int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// handle registers a route in the router. The route pattern is the label of the metrics of its requests, and the name
+// of their spans.
+func (rt *_router) handle(method string, path string, handle httprouter.Handle) {
+	handle = rt.traced(method, path, handle)
+	rt.router.Handle(method, path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if sw, ok := w.(*statusWriter); ok {
+			sw.route = path
+		}
+		handle(w, r, ps)
+	})
+}
+
+// instrumented counts and measures the requests served by next, and writes the access log. Requests that match no
+// route are labelled "unmatched", to keep the number of label values bounded.
+func (rt *_router) instrumented(next http.Handler) http.Handler {
+	if rt.metrics == nil && rt.accessLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), statusWriterKey{}, sw)))
+
+		route := sw.route
+		if route == "" {
+			route = "unmatched"
+		}
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if rt.metrics != nil {
+			code := strconv.Itoa(status)
+			rt.metrics.requests.Inc(r.Method, route, code)
+			rt.metrics.requestDuration.Observe(time.Since(start).Seconds(), r.Method, route, code)
+		}
+		if rt.accessLog != nil {
+			rt.logAccess(r, sw, start, route, status)
+		}
+	})
+}
+
+// countUpload adds the size of an uploaded photo to the metrics
+func (rt *_router) countUpload(n int64) {
+	if rt.metrics != nil {
+		rt.metrics.uploadBytes.Add(float64(n))
+	}
+}
+
+// storageUsage returns the bytes and the number of files in the image storage, cached for storageUsageTTL. On error
+// both are NaN.
+func (rt *_router) storageUsage() (float64, float64) {
+	m := rt.metrics
+	m.storageMu.Lock()
+	defer m.storageMu.Unlock()
+	if time.Since(m.storageAt) < storageUsageTTL {
+		return m.storageBytes, m.storageFiles
+	}
+	var bytes, files int64
+	err := rt.blobs.List(context.Background(), func(info storage.BlobInfo) error {
+		bytes += info.Size
+		files++
+		return nil
+	})
+	if err != nil {
+		rt.baseLogger.WithError(err).Warning("can't compute the usage of the image storage")
+		return math.NaN(), math.NaN()
+	}
+	m.storageAt = time.Now()
+	m.storageBytes, m.storageFiles = float64(bytes), float64(files)
+	return m.storageBytes, m.storageFiles
+}
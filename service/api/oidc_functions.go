@@ -0,0 +1,269 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/globaltime"
+	"wasaPhoto/service/oidc"
+	"wasaPhoto/service/usernames"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// oidcStateTTL is how long the user has to complete the login on the provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcNonceCookie binds a login, or the linking of an identity, started by a browser to the callback received by the
+// same browser. Without it, a provider URL opened by somebody else, e.g. sent by an attacker, would log them into the
+// attacker's account, or link their identity to it.
+const oidcNonceCookie = "wasaphoto_oidc_nonce"
+
+// oidcState is carried through the provider in the `state` parameter, signed with the session secret
+type oidcState struct {
+	Nonce      string
+	LinkUserID int
+	Expires    int64
+}
+
+// oidcLinkResponse is the body returned by POST /users/:id/identities
+type oidcLinkResponse struct {
+	URL string
+}
+
+func (rt *_router) newOIDCState(linkUserID int) (oidcState, string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return oidcState{}, "", err
+	}
+	st := oidcState{
+		Nonce:      base64.RawURLEncoding.EncodeToString(nonce),
+		LinkUserID: linkUserID,
+		Expires:    globaltime.Now().Add(oidcStateTTL).Unix(),
+	}
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return oidcState{}, "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return st, encoded + "." + rt.sign(encoded), nil
+}
+
+func (rt *_router) parseOIDCState(raw string) (oidcState, error) {
+	encoded, signature, found := strings.Cut(raw, ".")
+	if !found || !rt.verify(encoded, signature) {
+		return oidcState{}, errors.New("invalid state")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return oidcState{}, err
+	}
+	var st oidcState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return oidcState{}, err
+	}
+	if globaltime.Now().Unix() > st.Expires {
+		return oidcState{}, errors.New("state expired")
+	}
+	return st, nil
+}
+
+// freeUsername picks the username for a user created on the first OIDC login, starting from the provider claims and
+// adding a number if the name is already taken
+func (rt *_router) freeUsername(ctx reqcontext.RequestContext, claims oidc.Claims) (string, error) {
+	base := ""
+	for _, candidate := range []string{claims.PreferredUsername, strings.Split(claims.Email, "@")[0], claims.Name} {
+		base = strings.Map(func(r rune) rune {
+			if r == '_' || r == '.' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return -1
+		}, candidate)
+		if base != "" {
+			break
+		}
+	}
+	// the name from the provider may not respect the policy (e.g. too short or reserved), then "user" is used
+	for _, candidate := range []string{base, "user"} {
+		if candidate == "" {
+			continue
+		}
+		for i := 1; i <= 100; i++ {
+			username := candidate
+			if i > 1 {
+				username += strconv.Itoa(i)
+			}
+			username, err := rt.checkUsername(ctx, username, 0)
+			var invalid *usernames.ValidationError
+			if err == nil {
+				return username, nil
+			} else if errors.As(err, &invalid) {
+				break
+			} else if !errors.Is(err, errUsernameTaken) {
+				return "", err
+			}
+		}
+	}
+	return "", errors.New("no free username for " + base)
+}
+
+func (rt *_router) oidcLoginHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if rt.oidc == nil {
+		sendProblem(w, http.StatusNotFound, codeFeatureDisabled, "OpenID Connect login is not configured")
+		return
+	}
+	st, state, err := rt.newOIDCState(0)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	setOIDCNonceCookie(w, r, st.Nonce)
+	http.Redirect(w, r, rt.oidc.AuthCodeURL(state, st.Nonce), http.StatusFound)
+}
+
+// setOIDCNonceCookie sends the nonce of the flow to the browser, which must send it back to the callback
+func setOIDCNonceCookie(w http.ResponseWriter, r *http.Request, nonce string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcNonceCookie,
+		Value:    nonce,
+		Path:     "/session/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oidcCallbackHandler completes both the login and the linking of an external identity to an existing account
+func (rt *_router) oidcCallbackHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if rt.oidc == nil {
+		sendProblem(w, http.StatusNotFound, codeFeatureDisabled, "OpenID Connect login is not configured")
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		sendProblem(w, http.StatusUnauthorized, codeOIDCFailed, "login refused by the provider: "+query.Get("error"))
+		return
+	}
+	st, err := rt.parseOIDCState(query.Get("state"))
+	if err != nil {
+		invalidParameter(w, "state", "invalid or expired")
+		return
+	}
+	cookie, err := r.Cookie(oidcNonceCookie)
+	if err != nil || cookie.Value != st.Nonce {
+		sendProblem(w, http.StatusBadRequest, codeOIDCFailed, "login was started by another browser")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcNonceCookie, Path: "/session/oidc", MaxAge: -1})
+	claims, err := rt.oidc.Exchange(r.Context(), query.Get("code"), st.Nonce)
+	if err != nil {
+		ctx.Logger.WithError(err).Warning("OpenID Connect code exchange failed")
+		sendProblem(w, http.StatusUnauthorized, codeOIDCFailed, "login with the provider failed")
+		return
+	}
+	user, err := ctx.DB.GetUserByIdentity(claims.Issuer, claims.Subject)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	found := err == nil
+
+	if st.LinkUserID != 0 {
+		if found && user.ID != st.LinkUserID {
+			sendProblem(w, http.StatusConflict, codeIdentityLinked, "this identity is linked to another account")
+			return
+		}
+		identity := database.Identity{Issuer: claims.Issuer, Subject: claims.Subject, UserID: st.LinkUserID}
+		if !found {
+			identity, err = ctx.DB.AddIdentity(claims.Issuer, claims.Subject, st.LinkUserID)
+			if err != nil {
+				sendInternalError(ctx, w, err)
+				return
+			}
+		}
+		if rt.oidcUIRedirect != "" {
+			http.Redirect(w, r, withFragment(rt.oidcUIRedirect, url.Values{"linked": {"1"}}), http.StatusFound)
+			return
+		}
+		finalize(ctx, identity, err, w, 201)
+		return
+	}
+
+	code := 200
+	if !found {
+		// just-in-time creation of the account on the first login
+		username, err := rt.freeUsername(ctx, claims)
+		if err == nil {
+			user, err = ctx.DB.AddUser(username)
+		}
+		if err == nil {
+			_, err = ctx.DB.AddIdentity(claims.Issuer, claims.Subject, user.ID)
+		}
+		if err != nil {
+			sendInternalError(ctx, w, err)
+			return
+		}
+		code = 201
+	}
+	if rt.oidcUIRedirect == "" {
+		rt.sendSession(ctx, w, r, user, code)
+		return
+	}
+	// browsers are sent back to the web UI with the session in the URL fragment, which is never sent to servers
+	_, token, err := rt.newSession(ctx, r, user.ID)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("token", token)
+	fragment.Set("id", strconv.Itoa(user.ID))
+	http.Redirect(w, r, withFragment(rt.oidcUIRedirect, fragment), http.StatusFound)
+}
+
+// withFragment adds values to the URL fragment. If the URL already has a fragment (like the routes of the web UI,
+// "/dashboard/#/login"), values are added as the query of that fragment.
+func withFragment(base string, values url.Values) string {
+	if !strings.Contains(base, "#") {
+		return base + "#" + values.Encode()
+	}
+	if strings.Contains(base[strings.Index(base, "#"):], "?") {
+		return base + "&" + values.Encode()
+	}
+	return base + "?" + values.Encode()
+}
+
+// linkIdentityHandler returns the provider URL to open to link an external identity to the logged user. The nonce
+// cookie is set on this response, so the URL works only in the browser of the user who asked for it.
+func (rt *_router) linkIdentityHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	myID := ctx.UserID
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if myID != id {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't link an identity to another user")
+		return
+	}
+	if rt.oidc == nil {
+		sendProblem(w, http.StatusNotFound, codeFeatureDisabled, "OpenID Connect login is not configured")
+		return
+	}
+	st, state, err := rt.newOIDCState(id)
+	if err != nil {
+		sendInternalError(ctx, w, err)
+		return
+	}
+	setOIDCNonceCookie(w, r, st.Nonce)
+	finalize(ctx, oidcLinkResponse{URL: rt.oidc.AuthCodeURL(state, st.Nonce)}, err, w, 200)
+}
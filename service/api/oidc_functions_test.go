@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"wasaPhoto/service/oidc"
+	"wasaPhoto/service/oidc/oidctest"
+)
+
+// newOIDCRouter returns a router whose OpenID Connect provider is a local issuer
+func newOIDCRouter(t *testing.T) (*_router, *oidctest.Issuer) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("wasaphoto", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       issuer.URL,
+		ClientID:     "wasaphoto",
+		ClientSecret: "secret",
+		RedirectURL:  "http://wasaphoto.test/session/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestRouter(t, func(cfg *Config) { cfg.OIDC = provider }), issuer
+}
+
+// authorize opens the provider URL like a browser, and returns the callback URL the provider redirects to
+func authorize(t *testing.T, providerURL string) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(providerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("authorization: status %d, %v", res.StatusCode, err)
+	}
+	return callback.RequestURI()
+}
+
+// callback sends the callback request, with the cookies if not nil
+func callback(h http.Handler, target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	rt, issuer := newOIDCRouter(t)
+	h := rt.Handler()
+	issuer.Subject = "alice-subject"
+	issuer.Claims = map[string]interface{}{"preferred_username": "alice"}
+
+	start := do(t, h, http.MethodGet, "/session/oidc", "", nil)
+	if start.Code != http.StatusFound {
+		t.Fatalf("login: status %d", start.Code)
+	}
+	target := authorize(t, start.Header().Get("Location"))
+	cookies := start.Result().Cookies()
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		code    int
+	}{
+		{"another browser", nil, http.StatusBadRequest},
+		{"wrong nonce", []*http.Cookie{{Name: oidcNonceCookie, Value: "other"}}, http.StatusBadRequest},
+		{"same browser", cookies, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		w := callback(h, target, tt.cookies)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.code, w.Body)
+		}
+	}
+}
+
+func TestOIDCLinkOtherBrowser(t *testing.T) {
+	rt, issuer := newOIDCRouter(t)
+	h := rt.Handler()
+	attackerID, attackerToken := login(t, h, "mallory")
+	issuer.Subject = "victim-subject"
+
+	// the attacker asks for a link URL and sends it to the victim
+	start := do(t, h, http.MethodPost, "/users/"+strconv.Itoa(attackerID)+"/identities", attackerToken, nil)
+	if start.Code != http.StatusOK {
+		t.Fatalf("link: status %d: %s", start.Code, start.Body)
+	}
+	var link oidcLinkResponse
+	if err := json.NewDecoder(start.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+	// the victim is logged in on the provider, and its browser doesn't have the cookie of the attacker
+	w := callback(h, authorize(t, link.URL), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("link from another browser: status %d, want 400: %s", w.Code, w.Body)
+	}
+	if _, err := rt.db.GetUserByIdentity(issuer.URL, "victim-subject"); err == nil {
+		t.Fatal("the identity of the victim was linked to the attacker")
+	}
+
+	// the same flow in the browser of the user who started it
+	issuer.Subject = "mallory-subject"
+	w = callback(h, authorize(t, link.URL), start.Result().Cookies())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("link: status %d, want 201: %s", w.Code, w.Body)
+	}
+	user, err := rt.db.GetUserByIdentity(issuer.URL, "mallory-subject")
+	if err != nil || user.ID != attackerID {
+		t.Fatalf("identity linked to %+v, %v", user, err)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/imaging"
+)
+
+// Error codes of the problem responses. Clients branch on them, so a code must never change meaning: add a new one
+// instead. They are documented in doc/api.yaml.
+const (
+	codeInvalidParameter   = "invalid_parameter"
+	codeInvalidBody        = "invalid_body"
+	codeValidationFailed   = "validation_failed"
+	codeNotAuthenticated   = "not_authenticated"
+	codeInvalidCredentials = "invalid_credentials"
+	codeForbidden          = "forbidden"
+	codeBanned             = "banned"
+	codeAPIKeyNotAllowed   = "api_key_not_allowed"
+	codeInsufficientScope  = "insufficient_scope"
+	codeWrongPassword      = "wrong_password"
+	codeFeatureDisabled    = "feature_disabled"
+	codeNotFound           = "not_found"
+	codeConflict           = "conflict"
+	codeSelfAction         = "self_action"
+	codeUsernameTaken      = "username_taken"
+	codeIdentityLinked     = "identity_linked"
+	codeExportInProgress   = "export_in_progress"
+	codeExportNotReady     = "export_not_ready"
+	codeExportGone         = "export_gone"
+	codeUnsupportedImage   = "unsupported_image"
+	codeInvalidImage       = "invalid_image"
+	codeImageTooLarge      = "image_too_large"
+	codeDerivativeNotReady = "derivative_not_ready"
+	codeOIDCFailed         = "oidc_failed"
+	codeRateLimited        = "rate_limited"
+	codeInternalError      = "internal_error"
+	codeUnavailable        = "unavailable"
+)
+
+// problemTitles are the titles of the error codes, which are the same for every occurrence of the error
+var problemTitles = map[string]string{
+	codeInvalidParameter:   "Invalid parameter",
+	codeInvalidBody:        "Invalid request body",
+	codeValidationFailed:   "Validation failed",
+	codeNotAuthenticated:   "Not authenticated",
+	codeInvalidCredentials: "Invalid credentials",
+	codeForbidden:          "Forbidden",
+	codeBanned:             "Banned by the user",
+	codeAPIKeyNotAllowed:   "API keys not allowed",
+	codeInsufficientScope:  "Insufficient scope",
+	codeWrongPassword:      "Wrong password",
+	codeFeatureDisabled:    "Feature disabled",
+	codeNotFound:           "Not found",
+	codeConflict:           "Already exists",
+	codeSelfAction:         "Not allowed on yourself",
+	codeUsernameTaken:      "Username taken",
+	codeIdentityLinked:     "Identity linked to another account",
+	codeExportInProgress:   "Export in progress",
+	codeExportNotReady:     "Export not ready",
+	codeExportGone:         "Export no longer available",
+	codeUnsupportedImage:   "Unsupported image format",
+	codeInvalidImage:       "Invalid image",
+	codeImageTooLarge:      "Image too large",
+	codeDerivativeNotReady: "Size not ready",
+	codeOIDCFailed:         "OpenID Connect login failed",
+	codeRateLimited:        "Too many requests",
+	codeInternalError:      "Internal server error",
+	codeUnavailable:        "Service unavailable",
+}
+
+// problemTypePrefix is the prefix of the type URI of the problems, followed by the error code
+const problemTypePrefix = "urn:wasaphoto:problem:"
+
+// problem is the body of the error responses, in the format of RFC 7807 (application/problem+json). Its members
+// are lowercase as required by the RFC.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError describes why a field of the request (a body member or a parameter) is not valid
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// sendProblem writes an error response. The request ID is the one set in X-Request-ID by wrap.
+func sendProblem(w http.ResponseWriter, status int, code string, detail string, fields ...fieldError) {
+	title, ok := problemTitles[code]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	body, err := json.Marshal(problem{
+		Type:      problemTypePrefix + code,
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: w.Header().Get("X-Request-ID"),
+		Errors:    fields,
+	})
+	if err != nil {
+		// a problem can always be marshalled
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// sendInternalError logs err with the request logger and answers 500. The error is not sent to the client, which
+// can report the request ID instead.
+func sendInternalError(ctx reqcontext.RequestContext, w http.ResponseWriter, err error) {
+	ctx.Logger.WithError(err).Error("internal error")
+	sendProblem(w, http.StatusInternalServerError, codeInternalError, "")
+}
+
+// sendDatabaseError answers with the status of the typed errors of the database (e.g. 404 for database.ErrNotFound),
+// and with 500 for the other errors
+func sendDatabaseError(ctx reqcontext.RequestContext, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		sendProblem(w, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, database.ErrConflict):
+		sendProblem(w, http.StatusConflict, codeConflict, err.Error())
+	case errors.Is(err, database.ErrSelfAction):
+		sendProblem(w, http.StatusBadRequest, codeSelfAction, err.Error())
+	case errors.Is(err, database.ErrForbidden):
+		sendProblem(w, http.StatusForbidden, codeForbidden, err.Error())
+	default:
+		sendInternalError(ctx, w, err)
+	}
+}
+
+// sendImageError answers with the status of the errors of the image detection (e.g. 415 for imaging.ErrUnsupported),
+// and with 500 for the other errors
+func sendImageError(ctx reqcontext.RequestContext, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, imaging.ErrUnsupported):
+		sendProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedImage, err.Error())
+	case errors.Is(err, imaging.ErrCorrupt):
+		sendProblem(w, http.StatusBadRequest, codeInvalidImage, err.Error())
+	case errors.Is(err, imaging.ErrTooLarge):
+		sendProblem(w, http.StatusRequestEntityTooLarge, codeImageTooLarge, err.Error())
+	default:
+		sendInternalError(ctx, w, err)
+	}
+}
+
+// invalidParameter answers 400 for a path or query parameter that is missing or malformed
+func invalidParameter(w http.ResponseWriter, name string, message string) {
+	sendProblem(w, http.StatusBadRequest, codeInvalidParameter, "invalid "+name+" parameter",
+		fieldError{Field: name, Message: message})
+}
+
+// invalidBody answers 400 for a body that can't be decoded
+func invalidBody(w http.ResponseWriter, err error) {
+	sendProblem(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+}
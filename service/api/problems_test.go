@@ -0,0 +1,102 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// documentedCodes returns the statuses of the error codes in the table of doc/api.yaml
+func documentedCodes(t *testing.T) map[string][]int {
+	t.Helper()
+	data, err := os.ReadFile("../../doc/api.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Info struct {
+			Description string `yaml:"description"`
+		} `yaml:"info"`
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	codes := make(map[string][]int)
+	row := regexp.MustCompile(`(?m)^\| ([a-z_]+) \| ([0-9, ]+) \|`)
+	for _, m := range row.FindAllStringSubmatch(spec.Info.Description, -1) {
+		for _, s := range strings.Split(m[2], ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				t.Fatalf("status of %s: %v", m[1], err)
+			}
+			codes[m[1]] = append(codes[m[1]], status)
+		}
+	}
+	return codes
+}
+
+func TestSendDatabaseError(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := reqcontext.RequestContext{Logger: logger}
+	documented := documentedCodes(t)
+
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&database.Error{Kind: database.ErrNotFound, What: "photo", Err: sql.ErrNoRows}, http.StatusNotFound, codeNotFound},
+		{&database.Error{Kind: database.ErrConflict, What: "like"}, http.StatusConflict, codeConflict},
+		{&database.Error{Kind: database.ErrSelfAction, What: "ban"}, http.StatusBadRequest, codeSelfAction},
+		{&database.Error{Kind: database.ErrForbidden, What: "comment"}, http.StatusForbidden, codeForbidden},
+		{fmt.Errorf("liking: %w", &database.Error{Kind: database.ErrNotFound, What: "photo"}), http.StatusNotFound, codeNotFound},
+		{errors.New("disk I/O error"), http.StatusInternalServerError, codeInternalError},
+		{sql.ErrConnDone, http.StatusInternalServerError, codeInternalError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		sendDatabaseError(ctx, w, tt.err)
+		if w.Code != tt.status || problemCode(t, w) != tt.code {
+			t.Errorf("%v: status %d, code %q, want %d and %q", tt.err, w.Code, problemCode(t, w), tt.status, tt.code)
+		}
+		found := false
+		for _, status := range documented[tt.code] {
+			found = found || status == tt.status
+		}
+		if !found {
+			t.Errorf("code %s with status %d is not in doc/api.yaml", tt.code, tt.status)
+		}
+		// the cause of the internal errors is only logged
+		if tt.status == http.StatusInternalServerError && strings.Contains(w.Body.String(), tt.err.Error()) {
+			t.Errorf("%v: sent to the client: %s", tt.err, w.Body)
+		}
+	}
+}
+
+func TestProblemCodesDocumented(t *testing.T) {
+	documented := documentedCodes(t)
+	for code := range problemTitles {
+		if _, ok := documented[code]; !ok {
+			t.Errorf("code %s is not in doc/api.yaml", code)
+		}
+	}
+	for code := range documented {
+		if _, ok := problemTitles[code]; !ok {
+			t.Errorf("code %s of doc/api.yaml has no title", code)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Groups of routes with their own rate limit policy
+const (
+	RateLimitDefault  = "default"
+	RateLimitLogin    = "login"
+	RateLimitUpload   = "upload"
+	RateLimitComments = "comments"
+)
+
+// rateLimitGroup returns the rate limit group of the request
+func rateLimitGroup(r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return RateLimitDefault
+	}
+	switch {
+	case r.URL.Path == "/session" || r.URL.Path == "/users":
+		return RateLimitLogin
+	case r.URL.Path == "/photos":
+		return RateLimitUpload
+	case strings.HasPrefix(r.URL.Path, "/photos/") && strings.HasSuffix(r.URL.Path, "/comments"):
+		return RateLimitComments
+	}
+	return RateLimitDefault
+}
+
+// seconds rounds d up to whole seconds, as required by Retry-After and RateLimit-Reset
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimited takes a token from the bucket of key for the group of the request. It sets the RateLimit-* headers and,
+// if the bucket is empty, answers 429 and returns true. Errors of the store do not block requests.
+func (rt *_router) rateLimited(w http.ResponseWriter, r *http.Request, key string) bool {
+	if rt.rateLimiter == nil {
+		return false
+	}
+	res, limit, ok, err := rt.rateLimiter.Allow(rateLimitGroup(r), key)
+	if err != nil {
+		rt.baseLogger.WithError(err).Warning("rate limit store error")
+		return false
+	}
+	if !ok {
+		return false
+	}
+	w.Header().Set("RateLimit-Policy", limit.Policy())
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", seconds(res.Reset))
+	if res.Allowed {
+		return false
+	}
+	w.Header().Set("Retry-After", seconds(res.RetryAfter))
+	sendProblem(w, http.StatusTooManyRequests, codeRateLimited, "too many requests, retry in "+seconds(res.RetryAfter)+" seconds")
+	return true
+}
+
+// rateLimitIP returns the address the request is limited by: the client IP address, which the client can't choose
+// (see clientIP). Anything that is not an IP address, like a value forged by a client reaching the service without the
+// proxy, is replaced by the address of the connection. IPv6 clients are limited by /64 network, as they usually get one
+// and can use any address in it.
+func (rt *_router) rateLimitIP(r *http.Request) string {
+	ip := net.ParseIP(rt.clientIP(r))
+	if ip == nil {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ip = net.ParseIP(host)
+		if ip == nil {
+			return r.RemoteAddr
+		}
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+// rateLimitedByIP limits every request by client IP address, except the health probes. Authenticated routes are
+// limited by user too, in authenticated.
+func (rt *_router) rateLimitedByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/liveness" && r.URL.Path != "/readiness" && rt.rateLimited(w, r, "ip:"+rt.rateLimitIP(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
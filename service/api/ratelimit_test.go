@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"wasaPhoto/service/ratelimit"
+)
+
+func TestRateLimitIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		behindProxy bool
+		remoteAddr  string
+		forwarded   string
+		want        string
+	}{
+		{"direct", false, "192.0.2.1:1234", "", "192.0.2.1"},
+		{"proxy", true, "10.0.0.1:1234", "203.0.113.7", "203.0.113.7"},
+		{"forged value", true, "10.0.0.1:1234", "not-an-ip", "10.0.0.1"},
+		{"IPv6 network", false, "[2001:db8:1:2:3:4:5:6]:1234", "", "2001:db8:1:2::/64"},
+		{"IPv4 mapped", true, "10.0.0.1:1234", "::ffff:203.0.113.7", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		rt := &_router{behindProxy: tt.behindProxy}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := rt.rateLimitIP(r); got != tt.want {
+			t.Errorf("%s: rateLimitIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRateLimitForwardedRotation checks that a client can't get a new bucket by changing X-Forwarded-For
+func TestRateLimitForwardedRotation(t *testing.T) {
+	limiter, err := ratelimit.New(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		RateLimitDefault: {Requests: 1, Period: time.Hour, Burst: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limiter.Close()
+	rt := &_router{behindProxy: true, rateLimiter: limiter}
+	handler := rt.rateLimitedByIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest("GET", "/feed", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i)+", 203.0.113.7")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if want := i >= 3; (w.Code == http.StatusTooManyRequests) != want {
+			t.Errorf("request %d: status %d, limited should be %v", i, w.Code, want)
+		}
+	}
+}
@@ -7,6 +7,10 @@ Each value here should be assumed valid only per request only, with some excepti
 package reqcontext
 
 import (
+	"context"
+	"time"
+	"wasaPhoto/service/database"
+
 	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -18,4 +22,23 @@ type RequestContext struct {
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// Context is the context of the request (r.Context()), which carries its trace span
+	Context context.Context
+
+	// DB is the database bound to Context: its queries are cancelled with the request, and traced in its span
+	DB database.AppDatabase
+
+	// Deadline is when the request times out, the request context (r.Context()) is cancelled at the same time. It's
+	// zero if requests have no timeout
+	Deadline time.Time
+
+	// UserID is the authenticated user, zero for the routes that don't need a logged caller
+	UserID int
+
+	// Session is the session that authenticated the request, if the caller used a session token
+	Session database.Session
+
+	// APIKey is the key that authenticated the request, if the caller used an API key
+	APIKey database.APIKey
 }
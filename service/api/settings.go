@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// getSettingsHandler returns the preferences of the logged user
+func (rt *_router) getSettingsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if ctx.UserID != id {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't read the settings of another user")
+		return
+	}
+	output, err := ctx.DB.GetUserSettings(id)
+	finalize(ctx, output, err, w, 200)
+}
+
+// setSettingsHandler replaces the preferences of the logged user. They apply to the next uploads.
+func (rt *_router) setSettingsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		invalidParameter(w, "id", "must be an integer")
+		return
+	}
+	if ctx.UserID != id {
+		sendProblem(w, http.StatusForbidden, codeForbidden, "you can't change the settings of another user")
+		return
+	}
+	settings := database.UserSettings{}
+	err = json.NewDecoder(r.Body).Decode(&settings)
+	if err != nil {
+		invalidBody(w, err)
+		return
+	}
+	output, err := ctx.DB.SetUserSettings(id, settings)
+	finalize(ctx, output, err, w, 200)
+}
@@ -1,6 +1,9 @@
 package api
 
-// Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
+// Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines. It can
+// be called more than once.
 func (rt *_router) Close() error {
+	rt.closeOnce.Do(func() { close(rt.done) })
+	rt.workers.Wait()
 	return nil
 }
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/tracing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// traced starts a server span for each request of a route, child of the caller span in the traceparent header if
+// any. The span is named after the route pattern, so that the requests of a route are grouped.
+func (rt *_router) traced(method string, path string, handle httprouter.Handle) httprouter.Handle {
+	if rt.tracer == nil {
+		return handle
+	}
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx, span := rt.tracer.Start(tracing.Extract(r.Context(), r.Header), method+" "+path, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", method)
+		span.SetAttribute("http.route", path)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.client_ip", rt.clientIP(r))
+
+		sw, ok := w.(*statusWriter)
+		if !ok {
+			// metrics are disabled
+			sw = &statusWriter{ResponseWriter: w}
+		}
+		handle(sw, r.WithContext(ctx), ps)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+	}
+}
+
+// traceDatabase starts a span for each call to the database, child of the span of its context. The errors expected by
+// the handlers, like not found, don't mark the span as failed.
+func traceDatabase(ctx context.Context, method string) (context.Context, func(error)) {
+	ctx, span := tracing.StartChild(ctx, method, tracing.KindInternal)
+	return ctx, func(err error) {
+		var expected *database.Error
+		if errors.As(err, &expected) {
+			span.SetAttribute("db.outcome", expected.Error())
+		} else {
+			span.SetError(err)
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"wasaPhoto/service/api/reqcontext"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/usernames"
+)
+
+var errUsernameTaken = errors.New("username already taken, or too similar to an existing one")
+
+// checkUsername normalizes a username chosen by the user exceptID (0 for a new user), then checks it against the
+// policy and the usernames of the other users. The error is a *usernames.ValidationError or errUsernameTaken if the
+// username can't be used.
+func (rt *_router) checkUsername(ctx reqcontext.RequestContext, name string, exceptID int) (string, error) {
+	name = usernames.Normalize(name)
+	if err := rt.usernamePolicy.Validate(name); err != nil {
+		return "", err
+	}
+	taken, err := ctx.DB.UsernameIsTaken(name, exceptID)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", errUsernameTaken
+	}
+	return name, nil
+}
+
+// usernameError answers with the error returned by checkUsername
+func usernameError(ctx reqcontext.RequestContext, w http.ResponseWriter, err error) {
+	var invalid *usernames.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		sendProblem(w, http.StatusBadRequest, codeValidationFailed, invalid.Reason,
+			fieldError{Field: "Username", Message: invalid.Reason})
+	case errors.Is(err, errUsernameTaken), errors.Is(err, database.ErrConflict):
+		// the database conflicts when another request took the username after checkUsername
+		sendProblem(w, http.StatusConflict, codeUsernameTaken, errUsernameTaken.Error())
+	default:
+		sendInternalError(ctx, w, err)
+	}
+}
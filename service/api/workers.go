@@ -0,0 +1,70 @@
+package api
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+	"wasaPhoto/service/globaltime"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maintenanceInterval is how often expired data (accounts at the end of the grace period, export archives) is deleted
+const maintenanceInterval = time.Hour
+
+// exportWorkers is the number of archives built at the same time
+const exportWorkers = 2
+
+// derivativeWorkers is the number of photos whose derivatives are rendered at the same time
+const derivativeWorkers = 2
+
+// expectedWorkers is the number of background goroutines: the maintenance, the derivative scheduler and the workers
+const expectedWorkers = 2 + exportWorkers + derivativeWorkers
+
+// startWorkers starts the background goroutines of the router. They run until Close is called.
+func (rt *_router) startWorkers() {
+	rt.workers.Add(expectedWorkers)
+	atomic.StoreInt32(&rt.runningWorkers, expectedWorkers)
+	go rt.runWorker("maintenance", rt.maintenance)
+	go rt.runWorker("derivative scheduler", rt.derivativeScheduler)
+	for i := 0; i < exportWorkers; i++ {
+		go rt.runWorker("export", rt.exportWorker)
+	}
+	for i := 0; i < derivativeWorkers; i++ {
+		go rt.runWorker("derivatives", rt.derivativeWorker)
+	}
+}
+
+// runWorker runs a background goroutine and keeps count of the running ones for the readiness probe. A panic stops
+// only the worker, which makes the service not ready.
+func (rt *_router) runWorker(name string, fn func()) {
+	defer rt.workers.Done()
+	defer atomic.AddInt32(&rt.runningWorkers, -1)
+	defer func() {
+		if v := recover(); v != nil {
+			rt.baseLogger.WithFields(logrus.Fields{
+				"worker": name,
+				"panic":  v,
+				"stack":  string(debug.Stack()),
+			}).Error("background worker stopped by a panic")
+		}
+	}()
+	fn()
+}
+
+// maintenance deletes expired data at startup and then every maintenanceInterval
+func (rt *_router) maintenance() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	for {
+		rt.purgeDeactivatedUsers()
+		rt.deleteExpiredExports()
+		atomic.StoreInt64(&rt.lastMaintenance, globaltime.Now().UnixNano())
+
+		select {
+		case <-rt.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Package archive defines the "download your data" ZIP archive of a user. Write builds it from the database, Import
+recreates the user from it on another instance.
+
+The layout of the archive is:
+
+	profile.json       the user (Profile)
+	photos.json        the photos uploaded by the user ({"Items": [Photo...]})
+	photos/<id>.jpg    the original image of each photo (or .png, .gif, .webp), named as in Photo.File
+	comments.json      the comments on the photos of the user ({"Items": [database.Comment...]})
+	likes.json         the likes on the photos of the user ({"Items": [Like...]})
+	user-comments.json the comments written by the user on the photos of other users ({"Items": [database.Comment...]})
+	user-likes.json    the likes of the user on the photos of other users ({"Items": [Like...]})
+	followers.json     the followers of the user ({"Items": [database.User...]})
+	following.json     the users followed by the user ({"Items": [database.User...]})
+	bans.json          the users banned by the user ({"Items": [database.User...]})
+
+IDs are the ones of the instance that built the archive: Import maps them to the users and photos of the target
+instance by username. The comments and likes on the photos of other users are there for the user to read, and are not
+imported, as those photos are not in the archive.
+*/
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/globaltime"
+	"wasaPhoto/service/storage"
+)
+
+// Version is the version of the layout, written in profile.json
+const Version = 1
+
+const (
+	ProfileFile      = "profile.json"
+	PhotosFile       = "photos.json"
+	PhotosDir        = "photos/"
+	CommentsFile     = "comments.json"
+	LikesFile        = "likes.json"
+	UserCommentsFile = "user-comments.json"
+	UserLikesFile    = "user-likes.json"
+	FollowersFile    = "followers.json"
+	FollowingFile    = "following.json"
+	BansFile         = "bans.json"
+)
+
+// Profile is the content of profile.json
+type Profile struct {
+	Version    int
+	ID         int
+	Username   string
+	ExportedAt time.Time
+}
+
+// Photo is a photo in photos.json. File is the path of the image inside the archive, empty if the image was missing
+// when the archive was built.
+type Photo struct {
+	ID          int
+	File        string
+	Title       string
+	Description string
+	CreatedAt   time.Time
+	Likes       int
+	Comments    int
+}
+
+// Like is a like in likes.json or user-likes.json
+type Like struct {
+	PhotoID  int
+	UserID   int
+	Username string
+}
+
+// The JSON files with more items have the same format as the responses of the API
+type PhotoList struct{ Items []Photo }
+type LikeList struct{ Items []Like }
+type UserList struct{ Items []database.User }
+
+// Write builds the archive of the user into w
+func Write(w io.Writer, db database.AppDatabase, store storage.BlobStore, userID int) error {
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("reading the user: %w", err)
+	}
+	photos, err := db.GetPhotos(userID, userID)
+	if err != nil {
+		return fmt.Errorf("reading the photos: %w", err)
+	}
+	followers, err := db.GetFollowersID(userID)
+	if err != nil {
+		return fmt.Errorf("reading the followers: %w", err)
+	}
+	following, err := db.GetFollowingID(userID)
+	if err != nil {
+		return fmt.Errorf("reading the followed users: %w", err)
+	}
+	bans, err := db.GetBansID(userID)
+	if err != nil {
+		return fmt.Errorf("reading the bans: %w", err)
+	}
+	userComments, err := db.GetCommentsByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("reading the comments of the user: %w", err)
+	}
+	likesByUser, err := db.GetLikesByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("reading the likes of the user: %w", err)
+	}
+	var userLikes []Like
+	for _, like := range likesByUser {
+		userLikes = append(userLikes, Like{PhotoID: like.PhotoID, UserID: user.ID, Username: user.Username})
+	}
+
+	zw := zip.NewWriter(w)
+	var archivePhotos []Photo
+	var comments []database.Comment
+	var likes []Like
+	for _, photo := range photos {
+		photoComments, err := db.GetCommentsByPhotoID(photo.ID)
+		if err != nil {
+			return fmt.Errorf("reading the comments of photo %d: %w", photo.ID, err)
+		}
+		comments = append(comments, photoComments...)
+		photoLikes, err := db.GetLikes(photo.ID)
+		if err != nil {
+			return fmt.Errorf("reading the likes of photo %d: %w", photo.ID, err)
+		}
+		for _, like := range photoLikes {
+			likes = append(likes, Like{PhotoID: photo.ID, UserID: like.ID, Username: like.Username})
+		}
+
+		name := fmt.Sprintf("%s%d%s", PhotosDir, photo.ID, filepath.Ext(photo.Photourl))
+		copied, err := copyBlob(zw, name, store, photo.Photourl, photo.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("adding the image of photo %d: %w", photo.ID, err)
+		}
+		if !copied {
+			name = ""
+		}
+		archivePhotos = append(archivePhotos, Photo{
+			ID:          photo.ID,
+			File:        name,
+			Title:       photo.Title,
+			Description: photo.Description,
+			CreatedAt:   photo.CreatedAt,
+			Likes:       photo.Likes,
+			Comments:    photo.Comments,
+		})
+	}
+
+	exportedAt := globaltime.Now().UTC()
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{ProfileFile, Profile{Version: Version, ID: user.ID, Username: user.Username, ExportedAt: exportedAt}},
+		{PhotosFile, PhotoList{archivePhotos}},
+		{CommentsFile, db.JsonificaCommentsFun(comments)},
+		{LikesFile, LikeList{likes}},
+		{UserCommentsFile, db.JsonificaCommentsFun(userComments)},
+		{UserLikesFile, LikeList{userLikes}},
+		{FollowersFile, UserList{followers}},
+		{FollowingFile, UserList{following}},
+		{BansFile, UserList{bans}},
+	}
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: exportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.content); err != nil {
+			return fmt.Errorf("writing %s: %w", file.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// copyBlob adds the blob key of the store to the archive. It returns false if the blob does not exist.
+func copyBlob(zw *zip.Writer, name string, store storage.BlobStore, key string, modified time.Time) (bool, error) {
+	blob, _, err := store.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer blob.Close()
+	// images are already compressed
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(fw, blob)
+	return err == nil, err
+}
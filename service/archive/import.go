@@ -0,0 +1,307 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/imaging"
+	"wasaPhoto/service/storage"
+	"wasaPhoto/service/usernames"
+)
+
+// ImportOptions changes how an archive is imported
+type ImportOptions struct {
+	// Storage is where the images of the photos are written
+	Storage storage.BlobStore
+
+	// Username replaces the username of the archive, e.g. when it's already taken on the target instance
+	Username string
+
+	// UsernamePolicy is the policy of the target instance, which the username must respect
+	UsernamePolicy *usernames.Policy
+
+	// MaxPixels is the maximum width times height of the images, like for the uploads to the target instance: larger
+	// photos are skipped
+	MaxPixels int
+}
+
+// Report describes an import: the new user, how many items were recreated and what could not be mapped
+type Report struct {
+	UserID   int
+	Username string
+	Photos   int
+	Comments int
+	Likes    int
+	Follows  int
+	Bans     int
+	Skipped  []string
+}
+
+func (r *Report) skip(format string, args ...interface{}) {
+	r.Skipped = append(r.Skipped, fmt.Sprintf(format, args...))
+}
+
+// Import recreates the user of the archive built by Write. Comments, likes, follows and bans are matched by username
+// to the active users of the target instance: the ones that can't be matched are listed in Report.Skipped.
+// If the import fails, the partially imported user is deleted.
+func Import(zr *zip.Reader, db database.AppDatabase, opts ImportOptions) (Report, error) {
+	var report Report
+	if opts.UsernamePolicy == nil {
+		return report, errors.New("username policy is required")
+	}
+	if opts.MaxPixels <= 0 {
+		return report, errors.New("the maximum pixels of the images must be positive")
+	}
+	var profile Profile
+	if err := readJSON(zr, ProfileFile, &profile); err != nil {
+		return report, err
+	}
+	if profile.Version > Version {
+		return report, fmt.Errorf("archive version %d is newer than the supported one (%d)", profile.Version, Version)
+	}
+	var photos PhotoList
+	var comments database.JsonificaComments
+	var likes LikeList
+	var followers, following, bans UserList
+	for _, file := range []struct {
+		name string
+		v    interface{}
+	}{
+		{PhotosFile, &photos},
+		{CommentsFile, &comments},
+		{LikesFile, &likes},
+		{FollowersFile, &followers},
+		{FollowingFile, &following},
+		{BansFile, &bans},
+	} {
+		if err := readJSON(zr, file.name, file.v); err != nil {
+			return report, err
+		}
+	}
+
+	username := profile.Username
+	if opts.Username != "" {
+		username = opts.Username
+	}
+	username = usernames.Normalize(username)
+	if err := opts.UsernamePolicy.Validate(username); err != nil {
+		return report, fmt.Errorf("username %q can't be used on this instance: %w", username, err)
+	}
+	taken, err := db.UsernameIsTaken(username, 0)
+	if err != nil {
+		return report, err
+	}
+	if taken {
+		return report, fmt.Errorf("username %q is already taken on this instance, or too similar to an existing one", username)
+	}
+	user, err := db.AddUser(username)
+	if err != nil {
+		return report, fmt.Errorf("creating the user: %w", err)
+	}
+	report.UserID = user.ID
+	report.Username = user.Username
+
+	err = importContent(zr, db, opts, profile, user, photos, comments, likes, followers, following, bans, &report)
+	if err != nil {
+		if delErr := deleteUser(db, opts.Storage, user.ID); delErr != nil {
+			err = fmt.Errorf("%w (and the partially imported user %d can't be deleted: %v)", err, user.ID, delErr)
+		}
+		return Report{}, err
+	}
+	return report, nil
+}
+
+func importContent(zr *zip.Reader, db database.AppDatabase, opts ImportOptions, profile Profile, user database.User,
+	photos PhotoList, comments database.JsonificaComments, likes LikeList, followers, following, bans UserList,
+	report *Report) error {
+
+	// resolve maps a user of the source instance to the target one. Deactivated users are not matched, like the
+	// missing ones.
+	resolve := func(sourceID int, username string) (int, bool, error) {
+		if sourceID == profile.ID {
+			return user.ID, true, nil
+		}
+		target, err := db.GetUserByUsername(username)
+		if errors.Is(err, database.ErrNotFound) {
+			return 0, false, nil
+		} else if err != nil {
+			return 0, false, err
+		}
+		present, err := db.UserIsPresent(target.ID)
+		return target.ID, present, err
+	}
+
+	photoIDs := make(map[int]int)
+	for _, photo := range photos.Items {
+		if photo.File == "" {
+			report.skip("photo %d: the image is missing from the archive", photo.ID)
+			continue
+		}
+		photourl, info, err := copyImage(zr, photo, user.ID, opts.Storage, opts.MaxPixels)
+		if errors.Is(err, imaging.ErrUnsupported) || errors.Is(err, imaging.ErrCorrupt) || errors.Is(err, imaging.ErrTooLarge) {
+			report.skip("photo %d: %v", photo.ID, err)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("copying the image of photo %d: %w", photo.ID, err)
+		}
+		added, err := db.AddPhotoAt(user.ID, photourl, info.Format.MimeType, info.Width, info.Height, photo.Title, photo.Description, photo.CreatedAt)
+		if err != nil {
+			_ = opts.Storage.Delete(context.Background(), photourl)
+			return fmt.Errorf("adding photo %d: %w", photo.ID, err)
+		}
+		photoIDs[photo.ID] = added.ID
+		report.Photos++
+	}
+
+	for _, comment := range comments.Items {
+		photoID, ok := photoIDs[comment.PhotoID]
+		if !ok {
+			report.skip("comment %d: photo %d was not imported", comment.ID, comment.PhotoID)
+			continue
+		}
+		authorID, ok, err := resolve(comment.UserID, comment.Username)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			report.skip("comment %d: user %q not found or deactivated", comment.ID, comment.Username)
+			continue
+		}
+		if _, err := db.AddCommentAt(photoID, authorID, comment.Content, comment.CreatedAt); err != nil {
+			return fmt.Errorf("adding comment %d: %w", comment.ID, err)
+		}
+		report.Comments++
+	}
+
+	for _, like := range likes.Items {
+		photoID, ok := photoIDs[like.PhotoID]
+		if !ok {
+			report.skip("like of %q: photo %d was not imported", like.Username, like.PhotoID)
+			continue
+		}
+		userID, ok, err := resolve(like.UserID, like.Username)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			report.skip("like on photo %d: user %q not found or deactivated", like.PhotoID, like.Username)
+			continue
+		}
+		if _, err := db.AddLike(photoID, userID); err != nil {
+			return fmt.Errorf("adding the like of %q: %w", like.Username, err)
+		}
+		report.Likes++
+	}
+
+	for _, follower := range followers.Items {
+		followerID, ok, err := resolve(follower.ID, follower.Username)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			report.skip("follower %q not found or deactivated", follower.Username)
+			continue
+		}
+		if _, err := db.AddFollow(followerID, user.ID); err != nil {
+			return fmt.Errorf("adding follower %q: %w", follower.Username, err)
+		}
+		report.Follows++
+	}
+	for _, followed := range following.Items {
+		followingID, ok, err := resolve(followed.ID, followed.Username)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			report.skip("followed user %q not found or deactivated", followed.Username)
+			continue
+		}
+		if _, err := db.AddFollow(user.ID, followingID); err != nil {
+			return fmt.Errorf("following %q: %w", followed.Username, err)
+		}
+		report.Follows++
+	}
+
+	// bans are added last, as they remove the follows of the banned users
+	for _, banned := range bans.Items {
+		bannedID, ok, err := resolve(banned.ID, banned.Username)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			report.skip("banned user %q not found or deactivated", banned.Username)
+			continue
+		}
+		if _, err := db.AddBan(bannedID, user.ID); err != nil {
+			return fmt.Errorf("banning %q: %w", banned.Username, err)
+		}
+		report.Bans++
+	}
+	return nil
+}
+
+// readJSON decodes the file name of the archive into v
+func readJSON(zr *zip.Reader, name string, v interface{}) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", name, err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	return nil
+}
+
+// copyImage checks the image of the photo, which can have at most maxPixels pixels, removes its metadata and writes it
+// into the store. It returns its key and description.
+func copyImage(zr *zip.Reader, photo Photo, userID int, store storage.BlobStore, maxPixels int) (string, imaging.Info, error) {
+	src, err := zr.Open(photo.File)
+	if err != nil {
+		return "", imaging.Info{}, err
+	}
+	data, err := io.ReadAll(src)
+	_ = src.Close()
+	if err != nil {
+		return "", imaging.Info{}, err
+	}
+	img, info, err := imaging.Decode(data, maxPixels)
+	if err != nil {
+		return "", imaging.Info{}, err
+	}
+	data, info, err = imaging.Sanitize(data, img, info, imaging.SanitizeOptions{})
+	if err != nil {
+		return "", imaging.Info{}, err
+	}
+	key, err := storage.NewKey(strconv.Itoa(userID), info.Format.Ext)
+	if err != nil {
+		return "", imaging.Info{}, err
+	}
+	if _, err := store.Put(context.Background(), key, bytes.NewReader(data)); err != nil {
+		return "", imaging.Info{}, err
+	}
+	return key, info, nil
+}
+
+// deleteUser deletes the partially imported user and the images of its photos
+func deleteUser(db database.AppDatabase, store storage.BlobStore, userID int) error {
+	keys, err := db.GetPhotoKeys(userID)
+	if err != nil {
+		return err
+	}
+	if _, err := db.DeleteUser(userID); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := store.Delete(context.Background(), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package archive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wasaPhoto/service/archive"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/storage"
+	"wasaPhoto/service/usernames"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newDatabase(t *testing.T) database.AppDatabase {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// importOptions returns the options of an import into a memory store, with the default username policy of webapi
+func importOptions(t *testing.T) archive.ImportOptions {
+	t.Helper()
+	policy, err := usernames.NewPolicy(3, 20, `^[\p{L}\p{N}_.-]+$`, []string{"admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive.ImportOptions{Storage: storage.NewMemoryStore(), UsernamePolicy: policy, MaxPixels: 1 << 20}
+}
+
+func addUsers(t *testing.T, db database.AppDatabase, names ...string) []int {
+	t.Helper()
+	var ids []int
+	for _, name := range names {
+		user, err := db.AddUser(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+// exportAlice returns the archive of alice, who follows bob and carol, is followed by bob and banned dave
+func exportAlice(t *testing.T) *zip.Reader {
+	t.Helper()
+	db := newDatabase(t)
+	ids := addUsers(t, db, "alice", "bob", "carol", "dave")
+	alice, bob, carol, dave := ids[0], ids[1], ids[2], ids[3]
+	for _, follow := range [][2]int{{alice, bob}, {alice, carol}, {bob, alice}} {
+		if _, err := db.AddFollow(follow[0], follow[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.AddBan(dave, alice); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := archive.Write(&buf, db, storage.NewMemoryStore(), alice); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestImport(t *testing.T) {
+	zr := exportAlice(t)
+	tests := []struct {
+		name        string
+		deactivated []string
+		follows     int
+		bans        int
+		skipped     []string
+	}{
+		{"all users active", nil, 3, 1, nil},
+		{"followed user deactivated", []string{"carol"}, 2, 1, []string{`followed user "carol"`}},
+		{"follower deactivated", []string{"bob"}, 1, 1, []string{`follower "bob"`, `followed user "bob"`}},
+		{"banned user deactivated", []string{"dave"}, 3, 0, []string{`banned user "dave"`}},
+	}
+	for _, tt := range tests {
+		db := newDatabase(t)
+		ids := addUsers(t, db, "bob", "carol", "dave")
+		for i, name := range []string{"bob", "carol", "dave"} {
+			for _, deactivated := range tt.deactivated {
+				if name != deactivated {
+					continue
+				}
+				if _, err := db.DeactivateUser(ids[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		report, err := archive.Import(zr, db, importOptions(t))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if report.Username != "alice" || report.Follows != tt.follows || report.Bans != tt.bans {
+			t.Errorf("%s: imported %s with %d follows and %d bans, want %d and %d", tt.name, report.Username,
+				report.Follows, report.Bans, tt.follows, tt.bans)
+		}
+		if len(report.Skipped) != len(tt.skipped) {
+			t.Errorf("%s: skipped %q, want %q", tt.name, report.Skipped, tt.skipped)
+			continue
+		}
+		for i, skipped := range tt.skipped {
+			if !strings.HasPrefix(report.Skipped[i], skipped) {
+				t.Errorf("%s: skipped %q, want %q", tt.name, report.Skipped[i], skipped)
+			}
+		}
+	}
+}
+
+func TestImportUsername(t *testing.T) {
+	zr := exportAlice(t)
+	tests := []struct {
+		username string
+		want     string
+	}{
+		{"", "alice"},
+		{"ａｌｉｃｅ２", "alice2"},
+		{"al", ""},
+		{"alice bob", ""},
+		{"Αdmin", ""},
+	}
+	for _, tt := range tests {
+		opts := importOptions(t)
+		opts.Username = tt.username
+		report, err := archive.Import(zr, newDatabase(t), opts)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%q: imported as %q, want an error", tt.username, report.Username)
+		case tt.want != "" && err != nil:
+			t.Errorf("%q: %v", tt.username, err)
+		case tt.want != "" && report.Username != tt.want:
+			t.Errorf("%q: imported as %q, want %q", tt.username, report.Username, tt.want)
+		}
+	}
+}
+
+func TestImportMaxPixels(t *testing.T) {
+	db := newDatabase(t)
+	store := storage.NewMemoryStore()
+	alice := addUsers(t, db, "alice")[0]
+	for _, size := range []int{4, 10} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+		key, err := storage.NewKey("1", ".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Put(context.Background(), key, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.AddPhoto(alice, key, "image/png", size, size, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := archive.Write(&buf, db, store, alice); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxPixels int
+		photos    int
+	}{
+		{1 << 20, 2},
+		{16, 1},
+		{15, 0},
+	}
+	for _, tt := range tests {
+		opts := importOptions(t)
+		opts.MaxPixels = tt.maxPixels
+		report, err := archive.Import(zr, newDatabase(t), opts)
+		if err != nil {
+			t.Errorf("%d pixels: %v", tt.maxPixels, err)
+			continue
+		}
+		if report.Photos != tt.photos || len(report.Skipped) != 2-tt.photos {
+			t.Errorf("%d pixels: imported %d photos, skipped %q, want %d photos", tt.maxPixels, report.Photos,
+				report.Skipped, tt.photos)
+		}
+		for _, skipped := range report.Skipped {
+			if !strings.Contains(skipped, "too large") {
+				t.Errorf("%d pixels: skipped %q", tt.maxPixels, skipped)
+			}
+		}
+	}
+}
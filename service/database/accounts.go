@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"time"
+
+	"wasaPhoto/service/globaltime"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEACTIVATED = "DEACTIVATED"
+	RESTORED    = "RESTORED"
+)
+
+// DeleteUser removes the user and, through the triggers on users and photos, every photo, comment, like, follow, ban,
+// session, identity, API key and export related to it. The archive files are removed after the rows: a file that
+// can't be removed is only logged, as the account is already gone. The images are not removed from the storage, their
+// keys can be read before with GetPhotoKeys.
+func (db *appdbimpl) DeleteUser(id int) (Status, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return Status{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.Query("SELECT path FROM exports WHERE userid=? AND path != ''", id)
+	if err != nil {
+		return Status{}, err
+	}
+	var files []string
+	for rows.Next() {
+		var file string
+		err = rows.Scan(&file)
+		if err != nil {
+			_ = rows.Close()
+			return Status{}, err
+		}
+		files = append(files, file)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return Status{}, err
+	}
+
+	res, err := tx.Exec("DELETE FROM users WHERE id=?", id)
+	if err != nil {
+		return Status{}, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return Status{}, err
+	}
+	if affected == 0 {
+		return Status{}, newError(ErrNotFound, "user")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Status{}, err
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("userid", id).Error("can't remove a file of a deleted user")
+		}
+	}
+	return Status{Status: DELETED}, nil
+}
+
+// DeactivateUser hides the user from the other users and revokes its sessions and API keys. The account can be
+// restored with RestoreUser until it is deleted.
+func (db *appdbimpl) DeactivateUser(id int) (time.Time, error) {
+	deactivatedAt := globaltime.Now().UTC()
+	tx, err := db.begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec("UPDATE users SET deactivatedat=? WHERE id=? AND deactivatedat IS NULL", deactivatedAt, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if affected == 0 {
+		return time.Time{}, newError(ErrNotFound, "user")
+	}
+	_, err = tx.Exec("UPDATE sessions SET revoked=1 WHERE userid=?", id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = tx.Exec("UPDATE apikeys SET revoked=1 WHERE userid=?", id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return deactivatedAt, tx.Commit()
+}
+
+// RestoreUser cancels the deactivation of the user
+func (db *appdbimpl) RestoreUser(id int) (Status, error) {
+	_, err := db.exec("UPDATE users SET deactivatedat=NULL WHERE id=?", id)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Status: RESTORED}, err
+}
+
+// GetDeactivatedAt returns when the user was deactivated, or the zero time if the account is active
+func (db *appdbimpl) GetDeactivatedAt(id int) (time.Time, error) {
+	var deactivatedAt sql.NullTime
+	err := db.queryRow("SELECT deactivatedat FROM users WHERE id=?", id).Scan(&deactivatedAt)
+	if err != nil {
+		return time.Time{}, classify(err, "user")
+	}
+	return deactivatedAt.Time, err
+}
+
+// GetUsersDeactivatedBefore returns the users deactivated before t, which are the ones to delete at the end of the
+// grace period
+func (db *appdbimpl) GetUsersDeactivatedBefore(t time.Time) ([]User, error) {
+	rows, err := db.query("SELECT id, username FROM users WHERE deactivatedat IS NOT NULL AND deactivatedat < ?", t.UTC())
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err() // or modify return value
+	}()
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.ID, &user.Username)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
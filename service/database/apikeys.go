@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"wasaPhoto/service/globaltime"
+)
+
+func (db *appdbimpl) AddAPIKey(id string, userID int, name string, scopes []string, hash string) (APIKey, error) {
+	createdAt := globaltime.Now().UTC()
+	_, err := db.exec("INSERT INTO apikeys (id, userid, name, scopes, hash, createdat) VALUES (?, ?, ?, ?, ?, ?)", id, userID, name, strings.Join(scopes, " "), hash, createdAt)
+	if err != nil {
+		return APIKey{}, classify(err, "API key")
+	}
+	return APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hash,
+		CreatedAt: createdAt,
+	}, err
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey reads a row selected with the columns id, userid, name, scopes, hash, createdat, lastused, revoked
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	var lastUsed sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &scopes, &key.Hash, &key.CreatedAt, &lastUsed, &key.Revoked)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.LastUsed = lastUsed.Time
+	return key, nil
+}
+
+func (db *appdbimpl) GetAPIKey(id string) (APIKey, error) {
+	key, err := scanAPIKey(db.queryRow("SELECT id, userid, name, scopes, hash, createdat, lastused, revoked FROM apikeys WHERE id=?", id))
+	return key, classify(err, "API key")
+}
+
+// GetAPIKeysByUserID returns the keys of the user that are not revoked
+func (db *appdbimpl) GetAPIKeysByUserID(userID int) ([]APIKey, error) {
+	rows, err := db.query("SELECT id, userid, name, scopes, hash, createdat, lastused, revoked FROM apikeys WHERE userid=? AND revoked=0 ORDER BY createdat", userID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err() // or modify return value
+	}()
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// TouchAPIKey records that the key has just been used
+func (db *appdbimpl) TouchAPIKey(id string) error {
+	_, err := db.exec("UPDATE apikeys SET lastused=? WHERE id=?", globaltime.Now().UTC(), id)
+	return err
+}
+
+func (db *appdbimpl) RevokeAPIKey(id string) (Status, error) {
+	_, err := db.exec("UPDATE apikeys SET revoked=1 WHERE id=?", id)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Status: DELETED}, err
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+func (db *appdbimpl) WithContext(ctx context.Context) AppDatabase {
+	c := *db
+	c.ctx = ctx
+	return &c
+}
+
+// observeStatement calls the statements observer, if any, for a query
+func (db *appdbimpl) observeStatement(query string) (context.Context, func(error)) {
+	if db.statements == nil {
+		return db.ctx, func(error) {}
+	}
+	return db.statements(db.ctx, query)
+}
+
+func (db *appdbimpl) exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := db.observeStatement(query)
+	res, err := db.c.ExecContext(ctx, query, args...)
+	done(err)
+	return res, err
+}
+
+func (db *appdbimpl) query(query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := db.observeStatement(query)
+	rows, err := db.c.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+// queryRow runs a query returning at most one row. Its error is only known by Scan, so it's not observed.
+func (db *appdbimpl) queryRow(query string, args ...interface{}) *sql.Row {
+	ctx, done := db.observeStatement(query)
+	row := db.c.QueryRowContext(ctx, query, args...)
+	done(nil)
+	return row
+}
+
+// begin starts a transaction whose Exec and Query are observed like those of the database
+func (db *appdbimpl) begin() (*transaction, error) {
+	tx, err := db.c.BeginTx(db.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, db: db}, nil
+}
+
+type transaction struct {
+	*sql.Tx
+	db *appdbimpl
+}
+
+func (tx *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := tx.db.observeStatement(query)
+	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	done(err)
+	return res, err
+}
+
+func (tx *transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := tx.db.observeStatement(query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
@@ -1,11 +1,13 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"os"
+	"path"
 	"time"
+	"wasaPhoto/service/usernames"
 
 	"github.com/sirupsen/logrus"
 )
@@ -41,10 +43,18 @@ type UserExtended struct {
 
 // Photo struct
 type Photo struct {
-	ID          int
-	UserID      int
-	Username    string
-	Photourl    string
+	ID       int
+	UserID   int
+	Username string
+	// Photourl is the key of the image in the blob storage
+	Photourl string
+	// MimeType is the detected format of the image, Width and Height its size in pixels (zero for the photos uploaded
+	// before the detection)
+	MimeType string
+	Width    int
+	Height   int
+	// Derivatives is the status of the smaller versions of the image: PENDING, READY or FAILED
+	Derivatives string
 	Title       string
 	Description string
 	CreatedAt   time.Time
@@ -74,6 +84,65 @@ type Follow struct {
 	FollowerID  int
 	FollowingID int
 }
+
+// ObjectCounts struct, the number of active users and of their content
+type ObjectCounts struct {
+	Users    int
+	Photos   int
+	Likes    int
+	Comments int
+}
+
+// Session struct
+type Session struct {
+	ID        string
+	UserID    int
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+	UserAgent string
+	IP        string
+	Revoked   bool
+}
+
+// Identity links a user to an account on an external OpenID Connect provider
+type Identity struct {
+	Issuer  string
+	Subject string
+	UserID  int
+}
+
+// APIKey is a credential for scripts and bots, limited to some scopes. Only the hash of the secret is stored.
+type APIKey struct {
+	ID        string
+	UserID    int
+	Name      string
+	Scopes    []string
+	Hash      string
+	CreatedAt time.Time
+	LastUsed  time.Time
+	Revoked   bool
+}
+
+// Export is a "download your data" archive of a user. The download token is shown once, only its hash is stored.
+type Export struct {
+	ID        string
+	UserID    int
+	Status    string
+	Path      string
+	Size      int64
+	TokenHash string
+	Error     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// UserSettings are the preferences of a user
+type UserSettings struct {
+	// KeepMetadata keeps the EXIF data of the uploaded photos, except the location, instead of removing it
+	KeepMetadata bool
+}
+
 type JsonificaUsersBanFollow struct{ Items []UserBanFollow }
 type JsonificaPhotos struct{ Items []Photo }
 type JsonificaComments struct{ Items []Comment }
@@ -98,30 +167,84 @@ type AppDatabase interface {
 	AddLike(photoID int, userID int) (Like, error)
 	AddFollow(followerID int, followingID int) (Follow, error)
 	AddBan(bannedID int, bannerID int) (Ban, error)
-	// DeleteUser(id int) (Status, error)
+	DeleteUser(id int) (Status, error)
 	DeletePhoto(id int) (Status, error)
 	DeleteComment(id int) (Status, error)
 	DeleteLike(photoID int, userID int) (Status, error)
 	DeleteFollow(followerID int, followingID int) (Status, error)
 	DeleteBan(bannedID int, bannerID int) (Status, error)
 	UpdateUser(id int, username string) (User, error)
-	AddPhoto(id int, photourl string, title string, description string) (Photo, error)
+	AddPhoto(id int, photourl string, mimeType string, width int, height int, title string, description string) (Photo, error)
+	AddPhotoAt(userID int, photourl string, mimeType string, width int, height int, title string, description string, createdAt time.Time) (Photo, error)
+	AddCommentAt(photoID int, userID int, comment string, createdAt time.Time) (Comment, error)
 	SearchUser(username string, UserID int) ([]UserBanFollow, error)
 	GetCommentByID(id int) (Comment, error)
 	UserIsPresent(id int) (bool, error)
+	UsernameIsTaken(username string, exceptID int) (bool, error)
 	UserIsBanned(bannerID int, bannedID int) (bool, error)
 	JsonificaUsersFun(users []UserBanFollow) JsonificaUsersBanFollow
 	JsonificaPhotosFun(photos []Photo) JsonificaPhotos
 	JsonificaCommentsFun(comments []Comment) JsonificaComments
+	AddUserWithPassword(username string, passwordHash string) (User, error)
+	GetPasswordHash(id int) (string, error)
+	SetPasswordHash(id int, passwordHash string, keepSessionID string) (Status, error)
+	GetUserByIdentity(issuer string, subject string) (User, error)
+	AddIdentity(issuer string, subject string, userID int) (Identity, error)
+	AddAPIKey(id string, userID int, name string, scopes []string, hash string) (APIKey, error)
+	GetAPIKey(id string) (APIKey, error)
+	GetAPIKeysByUserID(userID int) ([]APIKey, error)
+	TouchAPIKey(id string) error
+	RevokeAPIKey(id string) (Status, error)
+	AddSession(id string, userID int, expiresAt time.Time, userAgent string, ip string) (Session, error)
+	GetSession(id string) (Session, error)
+	GetSessionsByUserID(userID int) ([]Session, error)
+	TouchSession(id string) error
+	RevokeSession(id string) (Status, error)
+	RevokeUserSessions(userID int) (Status, error)
+	DeactivateUser(id int) (time.Time, error)
+	RestoreUser(id int) (Status, error)
+	GetDeactivatedAt(id int) (time.Time, error)
+	GetUsersDeactivatedBefore(t time.Time) ([]User, error)
+	AddExport(id string, userID int, tokenHash string, expiresAt time.Time) (Export, error)
+	GetExport(id string) (Export, error)
+	UserHasPendingExport(userID int) (bool, error)
+	SetExportReady(id string, path string, size int64) error
+	SetExportFailed(id string, reason string) error
+	FailPendingExports(reason string) error
+	ConsumeExport(id string) (bool, error)
+	GetExportsExpiredBefore(t time.Time) ([]Export, error)
+	DeleteExport(id string) (Status, error)
+	GetObjectCounts() (ObjectCounts, error)
+	GetPhotoKeys(userID int) ([]string, error)
+	GetCommentsByUserID(userID int) ([]Comment, error)
+	GetLikesByUserID(userID int) ([]Like, error)
+	GetAllPhotoRefs() ([]Photo, error)
+	SetDerivativesStatus(photoID int, status string) error
+	GetPhotoIDsByDerivatives(status string) ([]int, error)
+	GetUserSettings(id int) (UserSettings, error)
+	SetUserSettings(id int, settings UserSettings) (UserSettings, error)
+
+	// WithContext returns the same database, whose queries run with ctx: they are canceled with it, and traced as
+	// children of its span
+	WithContext(ctx context.Context) AppDatabase
 }
 
 type appdbimpl struct {
-	c *sql.DB
+	c   *sql.DB
+	ctx context.Context
+
+	// statements observes every SQL statement, if not nil
+	statements Observer
 }
 
 // New returns a new instance of AppDatabase based on the SQLite connection `db`.
 // `db` is required - an error will be returned if `db` is `nil`.
 func New(db *sql.DB) (AppDatabase, error) {
+	return NewObserved(db, nil)
+}
+
+// NewObserved is like New, and calls statements around every SQL statement with its text
+func NewObserved(db *sql.DB, statements Observer) (AppDatabase, error) {
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
@@ -130,18 +253,15 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error creating database structure: %w", err)
 	}
-	// crea la cartella per le foto se non esiste
-	_, err = os.Stat("/tmp/images/")
-	if os.IsNotExist(err) {
-		errDir := os.MkdirAll("/tmp/images/", 0755)
-		if errDir != nil {
-			logrus.WithError(err).Error("error creating photos folder")
-			return nil, fmt.Errorf("error creating photos folder: %w", err)
-		}
+	err = migrateTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("error updating database structure: %w", err)
 	}
 
 	return &appdbimpl{
-		c: db,
+		c:          db,
+		ctx:        context.Background(),
+		statements: statements,
 	}, nil
 }
 
@@ -156,6 +276,10 @@ func createTables(db *sql.DB) error {
 			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
 			userid INTEGER NOT NULL,
 			photourl varchar(1000) NOT NULL,
+			mimetype TEXT NOT NULL DEFAULT 'image/jpeg',
+			width INTEGER NOT NULL DEFAULT 0,
+			height INTEGER NOT NULL DEFAULT 0,
+			derivatives TEXT NOT NULL DEFAULT 'PENDING',
 			title varchar(1000) NOT NULL,
 			description varchar(1000) NOT NULL,
 			createdat DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -191,6 +315,45 @@ func createTables(db *sql.DB) error {
 			foreign key (bannedId) references users(id),
 			foreign key (bannerId) references users(id)
 		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT NOT NULL PRIMARY KEY,
+			userid INTEGER NOT NULL,
+			createdat DATETIME NOT NULL,
+			expiresat DATETIME NOT NULL,
+			revoked BOOLEAN NOT NULL DEFAULT 0,
+			foreign key (userid) references users(id)
+		);
+		CREATE TABLE IF NOT EXISTS identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			userid INTEGER NOT NULL,
+			PRIMARY KEY (issuer, subject)
+			foreign key (userid) references users(id)
+		);
+		CREATE TABLE IF NOT EXISTS apikeys (
+			id TEXT NOT NULL PRIMARY KEY,
+			userid INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			createdat DATETIME NOT NULL,
+			lastused DATETIME,
+			revoked BOOLEAN NOT NULL DEFAULT 0,
+			foreign key (userid) references users(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS exports (
+			id TEXT NOT NULL PRIMARY KEY,
+			userid INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			path TEXT NOT NULL DEFAULT '',
+			size INTEGER NOT NULL DEFAULT 0,
+			tokenhash TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			createdat DATETIME NOT NULL,
+			expiresat DATETIME NOT NULL,
+			foreign key (userid) references users(id)
+		);
 
 		CREATE TRIGGER IF NOT EXISTS delete_photos_on_user_delete
 		AFTER DELETE ON users
@@ -224,6 +387,30 @@ func createTables(db *sql.DB) error {
 			DELETE FROM bans WHERE bannerid = OLD.id;
 		END;
 
+		CREATE TRIGGER IF NOT EXISTS delete_sessions_on_user_delete
+		AFTER DELETE ON users
+		BEGIN
+			DELETE FROM sessions WHERE userid = OLD.id;
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS delete_identities_on_user_delete
+		AFTER DELETE ON users
+		BEGIN
+			DELETE FROM identities WHERE userid = OLD.id;
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS delete_apikeys_on_user_delete
+		AFTER DELETE ON users
+		BEGIN
+			DELETE FROM apikeys WHERE userid = OLD.id;
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS delete_exports_on_user_delete
+		AFTER DELETE ON users
+		BEGIN
+			DELETE FROM exports WHERE userid = OLD.id;
+		END;
+
 		CREATE TRIGGER IF NOT EXISTS delete_comments_on_photo_delete
 		AFTER DELETE ON photos
 		BEGIN
@@ -245,11 +432,175 @@ func createTables(db *sql.DB) error {
 	`)
 	return err
 }
+
+// migrateTables adds the columns introduced after the first release to databases created by an older version
+func migrateTables(db *sql.DB) error {
+	err := addColumnIfMissing(db, "users", "passwordhash", "TEXT")
+	if err != nil {
+		return err
+	}
+	// the sessions opened before the list of sessions have no user agent nor IP address, and were last seen when they
+	// were opened
+	err = addColumnIfMissing(db, "sessions", "useragent", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "sessions", "ip", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "sessions", "lastseen", "DATETIME")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE sessions SET lastseen=createdat WHERE lastseen IS NULL")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "users", "deactivatedat", "DATETIME")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "users", "usernamekey", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "users", "usernameskeleton", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = fillUsernameKeys(db)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS users_usernameskeleton ON users (usernameskeleton)")
+	if err != nil {
+		return err
+	}
+	// usernames created before the normalization may collide: they keep working, but the unique index can't be
+	// created until one of them is renamed. New usernames are checked anyway by the statements that write them, see
+	// usernameFree.
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS users_usernamekey ON users (usernamekey)")
+	if err != nil {
+		logrus.WithError(err).Warning("some usernames are equal after the normalization, rename them to enforce uniqueness in the database")
+	}
+	// every upload was served as JPEG before the format detection
+	err = addColumnIfMissing(db, "photos", "mimetype", "TEXT NOT NULL DEFAULT 'image/jpeg'")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "photos", "width", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "photos", "height", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	// the derivatives of the existing photos are rendered in background
+	err = addColumnIfMissing(db, "photos", "derivatives", "TEXT NOT NULL DEFAULT 'PENDING'")
+	if err != nil {
+		return err
+	}
+	// the metadata of the photos is removed unless the user asks to keep it
+	err = addColumnIfMissing(db, "users", "keepmetadata", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = convertPhotoPaths(db)
+	if err != nil {
+		return err
+	}
+	// before the unique keys, two uploads of the same user in the same second were saved under the same image: the
+	// photos keep working, and fsck lists them
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS photos_photourl ON photos (photourl)")
+	if err != nil {
+		logrus.WithError(err).Warning("some photos share the same image, run fsck to list them")
+	}
+	return nil
+}
+
+// convertPhotoPaths replaces the absolute paths of the images, stored by the versions before the blob storage, with
+// their storage keys: the name of the file in the images folder
+func convertPhotoPaths(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, photourl FROM photos WHERE photourl LIKE '/%'")
+	if err != nil {
+		return err
+	}
+	var photos []Photo
+	for rows.Next() {
+		var photo Photo
+		err = rows.Scan(&photo.ID, &photo.Photourl)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		photos = append(photos, photo)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, photo := range photos {
+		_, err = db.Exec("UPDATE photos SET photourl=? WHERE id=?", path.Base(photo.Photourl), photo.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if len(photos) > 0 {
+		logrus.WithField("photos", len(photos)).Info("image paths converted to storage keys")
+	}
+	return nil
+}
+
+// fillUsernameKeys computes the normalized forms of the usernames created by an older version
+func fillUsernameKeys(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, username FROM users WHERE usernamekey IS NULL OR usernameskeleton IS NULL")
+	if err != nil {
+		return err
+	}
+	var users []User
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.ID, &user.Username)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		users = append(users, user)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, user := range users {
+		_, err = db.Exec("UPDATE users SET usernamekey=?, usernameskeleton=? WHERE id=?", usernames.Key(user.Username), usernames.Skeleton(user.Username), user.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing runs ALTER TABLE ... ADD COLUMN only if the column is not already in the table
+func addColumnIfMissing(db *sql.DB, table string, column string, definition string) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 func (db *appdbimpl) Ping() error {
-	return db.c.Ping()
+	return db.c.PingContext(db.ctx)
 }
 func (db *appdbimpl) GetUsers() ([]User, error) {
-	rows, err := db.c.Query("SELECT id, username, name FROM users")
+	rows, err := db.query("SELECT id, username, name FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -289,7 +640,11 @@ func (db *appdbimpl) JsonificaCommentsFun(comments []Comment) JsonificaComments
 	}
 }
 func (db *appdbimpl) GetPhotos(userPhoto int, iAmId int) ([]Photo, error) {
-	rows, err := db.c.Query("SELECT id, userid, photourl, title, description, createdat FROM photos where userid = ?", userPhoto)
+	err := db.requireUser(userPhoto)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.query("SELECT id, userid, photourl, mimetype, width, height, derivatives, title, description, createdat FROM photos WHERE userid = ? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", userPhoto)
 	if err != nil {
 		return nil, err
 	}
@@ -302,10 +657,14 @@ func (db *appdbimpl) GetPhotos(userPhoto int, iAmId int) ([]Photo, error) {
 		var id int
 		var user int
 		var photourl string
+		var mimeType string
+		var width int
+		var height int
+		var derivatives string
 		var title string
 		var description string
 		var createdat time.Time
-		err = rows.Scan(&id, &user, &photourl, &title, &description, &createdat)
+		err = rows.Scan(&id, &user, &photourl, &mimeType, &width, &height, &derivatives, &title, &description, &createdat)
 		if err != nil {
 			return nil, err
 		}
@@ -313,19 +672,19 @@ func (db *appdbimpl) GetPhotos(userPhoto int, iAmId int) ([]Photo, error) {
 		var comments int
 		var liked bool
 		var username string
-		err = db.c.QueryRow("SELECT count(*) FROM likes WHERE photoid = ?", id).Scan(&likes)
+		err = db.queryRow("SELECT count(*) FROM likes WHERE photoid = ? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", id).Scan(&likes)
 		if err != nil {
 			return nil, err
 		}
-		err = db.c.QueryRow("SELECT count(*) FROM comments WHERE photoid = ?", id).Scan(&comments)
+		err = db.queryRow("SELECT count(*) FROM comments WHERE photoid = ? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", id).Scan(&comments)
 		if err != nil {
 			return nil, err
 		}
-		err = db.c.QueryRow("SELECT count(*)>0 FROM likes WHERE photoid = ? AND userid = ?", id, iAmId).Scan(&liked)
+		err = db.queryRow("SELECT count(*)>0 FROM likes WHERE photoid = ? AND userid = ?", id, iAmId).Scan(&liked)
 		if err != nil {
 			return nil, err
 		}
-		err = db.c.QueryRow("SELECT username FROM users WHERE id = ?", user).Scan(&username)
+		err = db.queryRow("SELECT username FROM users WHERE id = ?", user).Scan(&username)
 		if err != nil {
 			return nil, err
 		}
@@ -334,6 +693,10 @@ func (db *appdbimpl) GetPhotos(userPhoto int, iAmId int) ([]Photo, error) {
 			UserID:      user,
 			Username:    username,
 			Photourl:    photourl,
+			MimeType:    mimeType,
+			Width:       width,
+			Height:      height,
+			Derivatives: derivatives,
 			Title:       title,
 			Description: description,
 			CreatedAt:   createdat,
@@ -345,16 +708,21 @@ func (db *appdbimpl) GetPhotos(userPhoto int, iAmId int) ([]Photo, error) {
 	return photos, nil
 }
 
+// GetPhoto returns the photo, or ErrNotFound if it does not exist or its owner is deactivated
 func (db *appdbimpl) GetPhoto(photoID int) (Photo, error) {
 	var photo Photo
-	err := db.c.QueryRow("SELECT id, userid, photourl, title, description, createdat FROM photos WHERE id=?", photoID).Scan(&photo.ID, &photo.UserID, &photo.Photourl, &photo.Title, &photo.Description, &photo.CreatedAt)
+	err := db.queryRow("SELECT id, userid, photourl, mimetype, width, height, derivatives, title, description, createdat FROM photos WHERE id=? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", photoID).Scan(&photo.ID, &photo.UserID, &photo.Photourl, &photo.MimeType, &photo.Width, &photo.Height, &photo.Derivatives, &photo.Title, &photo.Description, &photo.CreatedAt)
 	if err != nil {
-		return Photo{}, err
+		return Photo{}, classify(err, "photo")
 	}
 	return photo, err
 }
 func (db *appdbimpl) GetCommentsByPhotoID(photoID int) ([]Comment, error) {
-	rows, err := db.c.Query("SELECT id, photoid, userid, comment, createdat FROM comments WHERE photoid=?", photoID)
+	_, err := db.GetPhoto(photoID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.query("SELECT id, photoid, userid, comment, createdat FROM comments WHERE photoid=? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", photoID)
 	if err != nil {
 		return nil, err
 	}
@@ -373,7 +741,7 @@ func (db *appdbimpl) GetCommentsByPhotoID(photoID int) ([]Comment, error) {
 			return nil, err
 		}
 		var Username string
-		err = db.c.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&Username)
+		err = db.queryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&Username)
 		if err != nil {
 			return nil, err
 		}
@@ -389,7 +757,7 @@ func (db *appdbimpl) GetCommentsByPhotoID(photoID int) ([]Comment, error) {
 	return comments, nil
 }
 func (db *appdbimpl) GetLikes(photoID int) ([]User, error) {
-	rows, err := db.c.Query("SELECT userid FROM likes WHERE photoid=?", photoID)
+	rows, err := db.query("SELECT userid FROM likes WHERE photoid=? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", photoID)
 	if err != nil {
 		return nil, err
 	}
@@ -413,7 +781,11 @@ func (db *appdbimpl) GetLikes(photoID int) ([]User, error) {
 	return usersThatLiked, nil
 }
 func (db *appdbimpl) GetFollowersID(userID int) ([]User, error) {
-	rows, err := db.c.Query("SELECT followerid FROM follows WHERE followingid=?", userID)
+	err := db.requireUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.query("SELECT followerid FROM follows WHERE followingid=? AND followerid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -438,7 +810,11 @@ func (db *appdbimpl) GetFollowersID(userID int) ([]User, error) {
 }
 
 func (db *appdbimpl) GetFollowingID(userID int) ([]User, error) {
-	rows, err := db.c.Query("SELECT followingid FROM follows WHERE followerid=?", userID)
+	err := db.requireUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.query("SELECT followingid FROM follows WHERE followerid=? AND followingid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -463,7 +839,7 @@ func (db *appdbimpl) GetFollowingID(userID int) ([]User, error) {
 }
 
 func (db *appdbimpl) GetBansID(userID int) ([]User, error) {
-	rows, err := db.c.Query("SELECT bannedid FROM bans WHERE bannerid=?", userID)
+	rows, err := db.query("SELECT bannedid FROM bans WHERE bannerid=?", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -488,7 +864,7 @@ func (db *appdbimpl) GetBansID(userID int) ([]User, error) {
 }
 
 func (db *appdbimpl) GetFeed(userID int) ([]Photo, error) {
-	rows, err := db.c.Query("SELECT id, userid, photourl, title, description, createdat FROM photos WHERE userid IN (SELECT followingid FROM follows WHERE followerid=?);", userID)
+	rows, err := db.query("SELECT id, userid, photourl, mimetype, width, height, derivatives, title, description, createdat FROM photos WHERE userid IN (SELECT followingid FROM follows WHERE followerid=?) AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL);", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -501,10 +877,14 @@ func (db *appdbimpl) GetFeed(userID int) ([]Photo, error) {
 		var id int
 		var user int
 		var photourl string
+		var mimeType string
+		var width int
+		var height int
+		var derivatives string
 		var title string
 		var description string
 		var createdat time.Time
-		err = rows.Scan(&id, &user, &photourl, &title, &description, &createdat)
+		err = rows.Scan(&id, &user, &photourl, &mimeType, &width, &height, &derivatives, &title, &description, &createdat)
 		if err != nil {
 			return nil, err
 		}
@@ -512,19 +892,19 @@ func (db *appdbimpl) GetFeed(userID int) ([]Photo, error) {
 		var comments int
 		var liked bool
 		var username string
-		err = db.c.QueryRow("SELECT count(*) FROM likes WHERE photoid = ?", id).Scan(&likes)
+		err = db.queryRow("SELECT count(*) FROM likes WHERE photoid = ? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", id).Scan(&likes)
 		if err != nil {
 			return nil, err
 		}
-		err = db.c.QueryRow("SELECT count(*) FROM comments WHERE photoid = ?", id).Scan(&comments)
+		err = db.queryRow("SELECT count(*) FROM comments WHERE photoid = ? AND userid IN (SELECT id FROM users WHERE deactivatedat IS NULL)", id).Scan(&comments)
 		if err != nil {
 			return nil, err
 		}
-		err = db.c.QueryRow("SELECT count(*) > 0 FROM likes WHERE photoid = ? AND userid = ?", id, userID).Scan(&liked)
+		err = db.queryRow("SELECT count(*) > 0 FROM likes WHERE photoid = ? AND userid = ?", id, userID).Scan(&liked)
 		if err != nil {
 			return nil, err
 		}
-		err = db.c.QueryRow("SELECT username FROM users WHERE id = ?", user).Scan(&username)
+		err = db.queryRow("SELECT username FROM users WHERE id = ?", user).Scan(&username)
 		if err != nil {
 			return nil, err
 		}
@@ -533,6 +913,10 @@ func (db *appdbimpl) GetFeed(userID int) ([]Photo, error) {
 			UserID:      user,
 			Username:    username,
 			Photourl:    photourl,
+			MimeType:    mimeType,
+			Width:       width,
+			Height:      height,
+			Derivatives: derivatives,
 			Title:       title,
 			Description: description,
 			CreatedAt:   createdat,
@@ -546,17 +930,17 @@ func (db *appdbimpl) GetFeed(userID int) ([]Photo, error) {
 
 func (db *appdbimpl) GetUserByID(id int) (User, error) {
 	var user User
-	err := db.c.QueryRow("SELECT id, username FROM users WHERE id=?", id).Scan(&user.ID, &user.Username)
+	err := db.queryRow("SELECT id, username FROM users WHERE id=?", id).Scan(&user.ID, &user.Username)
 	if err != nil {
-		return User{}, err
+		return User{}, classify(err, "user")
 	}
 	return user, err
 }
 func (db *appdbimpl) GetUserExtendedByID(id int) (UserExtended, error) {
 	var user UserExtended
-	err := db.c.QueryRow("SELECT id, username FROM users WHERE id=?", id).Scan(&user.ID, &user.Username)
+	err := db.queryRow("SELECT id, username FROM users WHERE id=? AND deactivatedat IS NULL", id).Scan(&user.ID, &user.Username)
 	if err != nil {
-		return UserExtended{}, err
+		return UserExtended{}, classify(err, "user")
 	}
 	followers, err := db.GetFollowersID(id)
 	if err != nil {
@@ -582,18 +966,31 @@ func (db *appdbimpl) GetUserExtendedByID(id int) (UserExtended, error) {
 }
 func (db *appdbimpl) GetUserByUsername(username string) (User, error) {
 	var user User
-	err := db.c.QueryRow("SELECT id, username FROM users WHERE username=?", username).Scan(&user.ID, &user.Username)
+	err := db.queryRow("SELECT id, username FROM users WHERE usernamekey=?", usernames.Key(username)).Scan(&user.ID, &user.Username)
 	if err != nil {
-		return User{}, err
+		return User{}, classify(err, "user")
 	}
 	return user, err
 }
 
 func (db *appdbimpl) AddUser(username string) (User, error) {
-	res, err := db.c.Exec("INSERT INTO users (username) VALUES (?)", username)
+	key, skeleton := usernames.Key(username), usernames.Skeleton(username)
+	res, err := db.exec("INSERT INTO users (username, usernamekey, usernameskeleton) SELECT ?, ?, ? WHERE "+usernameFree, username, key, skeleton, key, skeleton, 0)
+	return insertedUser(res, err, username)
+}
+
+// insertedUser returns the user added by an INSERT with the usernameFree condition
+func insertedUser(res sql.Result, err error, username string) (User, error) {
+	if err != nil {
+		return User{}, classify(err, "username")
+	}
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return User{}, err
 	}
+	if affected == 0 {
+		return User{}, newError(ErrConflict, "username")
+	}
 	id, err := res.LastInsertId()
 	if err != nil {
 		return User{}, err
@@ -604,11 +1001,25 @@ func (db *appdbimpl) AddUser(username string) (User, error) {
 	}, err
 }
 
-func (db *appdbimpl) AddPhoto(userID int, photourl string, title string, description string) (Photo, error) {
-	res, err := db.c.Exec("INSERT INTO photos (userid, photourl, title, description) VALUES (?, ?, ?, ?)", userID, photourl, title, description)
+func (db *appdbimpl) AddPhoto(userID int, photourl string, mimeType string, width int, height int, title string, description string) (Photo, error) {
+	res, err := db.exec("INSERT INTO photos (userid, photourl, mimetype, width, height, title, description) VALUES (?, ?, ?, ?, ?, ?, ?)", userID, photourl, mimeType, width, height, title, description)
+	if err != nil {
+		return Photo{}, classify(err, "photo")
+	}
+	id, err := res.LastInsertId()
 	if err != nil {
 		return Photo{}, err
 	}
+	photok, err := db.GetPhoto(int(id))
+	return photok, err
+}
+
+// AddPhotoAt adds a photo created at a given time, e.g. when it's imported from another instance
+func (db *appdbimpl) AddPhotoAt(userID int, photourl string, mimeType string, width int, height int, title string, description string, createdAt time.Time) (Photo, error) {
+	res, err := db.exec("INSERT INTO photos (userid, photourl, mimetype, width, height, title, description, createdat) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", userID, photourl, mimeType, width, height, title, description, createdAt.UTC())
+	if err != nil {
+		return Photo{}, classify(err, "photo")
+	}
 	id, err := res.LastInsertId()
 	if err != nil {
 		return Photo{}, err
@@ -618,10 +1029,14 @@ func (db *appdbimpl) AddPhoto(userID int, photourl string, title string, descrip
 }
 
 func (db *appdbimpl) AddComment(photoID int, userID int, comment string) (Comment, error) {
-	res, err := db.c.Exec("INSERT INTO comments (photoid, userid, comment) VALUES (?, ?, ?)", photoID, userID, comment)
+	err := db.canInteract(photoID, userID, "comment")
 	if err != nil {
 		return Comment{}, err
 	}
+	res, err := db.exec("INSERT INTO comments (photoid, userid, comment) VALUES (?, ?, ?)", photoID, userID, comment)
+	if err != nil {
+		return Comment{}, classify(err, "comment")
+	}
 	id, err := res.LastInsertId()
 	if err != nil {
 		return Comment{}, err
@@ -634,123 +1049,140 @@ func (db *appdbimpl) AddComment(photoID int, userID int, comment string) (Commen
 	}, err
 }
 
+// AddCommentAt adds a comment written at a given time, e.g. when it's imported from another instance
+func (db *appdbimpl) AddCommentAt(photoID int, userID int, comment string, createdAt time.Time) (Comment, error) {
+	err := db.canInteract(photoID, userID, "comment")
+	if err != nil {
+		return Comment{}, err
+	}
+	res, err := db.exec("INSERT INTO comments (photoid, userid, comment, createdat) VALUES (?, ?, ?, ?)", photoID, userID, comment, createdAt.UTC())
+	if err != nil {
+		return Comment{}, classify(err, "comment")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Comment{}, err
+	}
+	return Comment{
+		ID:        int(id),
+		PhotoID:   photoID,
+		UserID:    userID,
+		Content:   comment,
+		CreatedAt: createdAt.UTC(),
+	}, err
+}
+
+// AddLike adds the like of the user to the photo, ErrConflict means that the user already likes it
 func (db *appdbimpl) AddLike(photoID int, userID int) (Like, error) {
-	_, err := db.c.Exec("INSERT INTO likes (photoid, userid) VALUES (?, ?)", photoID, userID)
+	err := db.canInteract(photoID, userID, "like")
 	if err != nil {
 		return Like{}, err
 	}
+	_, err = db.exec("INSERT INTO likes (photoid, userid) VALUES (?, ?)", photoID, userID)
+	if err != nil {
+		return Like{}, classify(err, "like")
+	}
 	return Like{
 		PhotoID: photoID,
 		UserID:  userID,
 	}, err
 }
 
+// AddFollow makes followerID follow followingID, ErrConflict means that it already does
 func (db *appdbimpl) AddFollow(followerID int, followingID int) (Follow, error) {
-	_, err := db.c.Exec("INSERT INTO follows (followerid, followingid) VALUES (?, ?)", followerID, followingID)
+	if followerID == followingID {
+		return Follow{}, newError(ErrSelfAction, "follow")
+	}
+	err := db.requireUser(followingID)
 	if err != nil {
 		return Follow{}, err
 	}
+	banned, err := db.UserIsBanned(followingID, followerID)
+	if err != nil {
+		return Follow{}, err
+	}
+	if banned {
+		return Follow{}, newError(ErrForbidden, "follow")
+	}
+	_, err = db.exec("INSERT INTO follows (followerid, followingid) VALUES (?, ?)", followerID, followingID)
+	if err != nil {
+		return Follow{}, classify(err, "follow")
+	}
 	return Follow{
 		FollowerID:  followerID,
 		FollowingID: followingID,
 	}, err
 }
 
+// AddBan makes bannerID ban bannedID, ErrConflict means that it already does
 func (db *appdbimpl) AddBan(bannedID int, bannerID int) (Ban, error) {
-	_, err := db.c.Exec("INSERT INTO bans (bannedid, bannerid) VALUES (?, ?)", bannedID, bannerID)
+	if bannedID == bannerID {
+		return Ban{}, newError(ErrSelfAction, "ban")
+	}
+	err := db.requireUser(bannedID)
 	if err != nil {
 		return Ban{}, err
 	}
+	_, err = db.exec("INSERT INTO bans (bannedid, bannerid) VALUES (?, ?)", bannedID, bannerID)
+	if err != nil {
+		return Ban{}, classify(err, "ban")
+	}
 	return Ban{
 		BannedID: bannedID,
 		BannerID: bannerID,
 	}, err
 }
 
-/*func (db *appdbimpl) DeleteUser(id int) (Status, error) {
-	flag, err := db.UserIsPresent(id)
-	if err != nil {
-		logrus.Error(err)
-	}
-	if !flag {
-		return Status{}, errors.New("USER NOT FOUND")
-	}
-	// delete all photos
-	photos, err := db.GetPhotos(id, id)
-	if err == nil {
-		for idx := range photos {
-			photo, err := db.GetPhoto(photos[idx].ID)
-			if err != nil {
-				logrus.Error(err)
-			}
-			if os.Remove(photo.Photourl) != nil {
-				logrus.Error("IMAGE NOT found")
-			}
-		}
-	}
-	_, err = db.c.Exec("DELETE FROM users WHERE id=?", id)
-	if err != nil {
-		logrus.Error(err)
-	}
-	return Status{Status: DELETED}, err
-}
-*/
-
+// DeletePhoto removes the photo with its comments and likes. The image is not removed from the storage.
 func (db *appdbimpl) DeletePhoto(id int) (Status, error) {
-	photo, err := db.GetPhoto(id)
-	if err != nil {
-		return Status{}, err
-	}
-	if os.Remove(photo.Photourl) != nil {
-		return Status{}, errors.New("IMAGE NOT found")
-	}
-	_, err = db.c.Exec("DELETE FROM photos WHERE id=?", id)
-	if err != nil {
-		return Status{}, err
-	}
-	return Status{Status: DELETED}, err
+	return db.deleteRow("photo", "DELETE FROM photos WHERE id=?", id)
 }
 
 func (db *appdbimpl) DeleteComment(id int) (Status, error) {
-	_, err := db.c.Exec("DELETE FROM comments WHERE id=?", id)
-	if err != nil {
-		return Status{}, err
-	}
-	return Status{Status: DELETED}, err
+	return db.deleteRow("comment", "DELETE FROM comments WHERE id=?", id)
 }
 
 func (db *appdbimpl) DeleteLike(photoID int, userID int) (Status, error) {
-	_, err := db.c.Exec("DELETE FROM likes WHERE photoid=? AND userid=?", photoID, userID)
-	if err != nil {
-		return Status{}, err
-	}
-	return Status{Status: DELETED}, err
+	return db.deleteRow("like", "DELETE FROM likes WHERE photoid=? AND userid=?", photoID, userID)
 }
 
 func (db *appdbimpl) DeleteFollow(followerID int, followingID int) (Status, error) {
 	if followerID == followingID {
-		return Status{}, errors.New("CAN'T UNFOLLOW YOURSELF")
-	}
-	_, err := db.c.Exec("DELETE FROM follows WHERE followerid=? AND followingid=?", followerID, followingID)
-	if err != nil {
-		return Status{}, err
+		return Status{}, newError(ErrSelfAction, "unfollow")
 	}
-	return Status{Status: DELETED}, err
+	return db.deleteRow("follow", "DELETE FROM follows WHERE followerid=? AND followingid=?", followerID, followingID)
 }
 
 func (db *appdbimpl) DeleteBan(bannedID int, bannerID int) (Status, error) {
 	if bannedID == bannerID {
-		return Status{}, errors.New("CAN'T UNBAN YOURSELF")
+		return Status{}, newError(ErrSelfAction, "unban")
 	}
-	_, err := db.c.Exec("DELETE FROM bans WHERE bannedid=? AND bannerid=?", bannedID, bannerID)
+	return db.deleteRow("ban", "DELETE FROM bans WHERE bannedid=? AND bannerid=?", bannedID, bannerID)
+}
+
+// deleteRow runs a DELETE statement that removes a single row, ErrNotFound means that there was no row to delete
+func (db *appdbimpl) deleteRow(what string, query string, args ...interface{}) (Status, error) {
+	res, err := db.exec(query, args...)
+	if err != nil {
+		return Status{}, err
+	}
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return Status{}, err
 	}
-	return Status{Status: DELETED}, err
+	if affected == 0 {
+		return Status{}, newError(ErrNotFound, what)
+	}
+	return Status{Status: DELETED}, nil
 }
 
 func (db *appdbimpl) UpdateUser(id int, username string) (User, error) {
-	_, err := db.c.Exec("UPDATE users SET username=? WHERE id=?", username, id)
+	key, skeleton := usernames.Key(username), usernames.Skeleton(username)
+	res, err := db.exec("UPDATE users SET username=?, usernamekey=?, usernameskeleton=? WHERE id=? AND "+usernameFree, username, key, skeleton, id, key, skeleton, id)
+	if err != nil {
+		return User{}, classify(err, "username")
+	}
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return User{}, err
 	}
@@ -758,11 +1190,14 @@ func (db *appdbimpl) UpdateUser(id int, username string) (User, error) {
 	if err != nil {
 		return User{}, err
 	}
+	if affected == 0 {
+		return User{}, newError(ErrConflict, "username")
+	}
 	return user, err
 }
 
 func (db *appdbimpl) SearchUser(search_username string, userID int) ([]UserBanFollow, error) {
-	rows, err := db.c.Query("SELECT id, username FROM users WHERE username LIKE ?", "%"+search_username+"%")
+	rows, err := db.query("SELECT id, username FROM users WHERE usernamekey LIKE ? AND deactivatedat IS NULL", "%"+usernames.Key(search_username)+"%")
 	if err != nil {
 		return nil, err
 	}
@@ -784,7 +1219,7 @@ func (db *appdbimpl) SearchUser(search_username string, userID int) ([]UserBanFo
 		if err != nil {
 			return nil, err
 		}
-		err = db.c.QueryRow("SELECT COUNT(*) FROM follows WHERE followerid=? AND followingid=?", userID, id).Scan(&followed)
+		err = db.queryRow("SELECT COUNT(*) FROM follows WHERE followerid=? AND followingid=?", userID, id).Scan(&followed)
 		if err != nil {
 			return nil, err
 		}
@@ -801,16 +1236,32 @@ func (db *appdbimpl) SearchUser(search_username string, userID int) ([]UserBanFo
 
 func (db *appdbimpl) GetCommentByID(id int) (Comment, error) {
 	var comment Comment
-	err := db.c.QueryRow("SELECT id, photoid, userid, comment, createdat FROM comments WHERE id=?", id).Scan(&comment.ID, &comment.PhotoID, &comment.UserID, &comment.Content, &comment.CreatedAt)
+	err := db.queryRow("SELECT id, photoid, userid, comment, createdat FROM comments WHERE id=?", id).Scan(&comment.ID, &comment.PhotoID, &comment.UserID, &comment.Content, &comment.CreatedAt)
 	if err != nil {
-		return Comment{}, err
+		return Comment{}, classify(err, "comment")
 	}
 	return comment, err
 }
 
+// usernameFree is the condition of the statements that write a username, with the key and the skeleton of the username
+// and the ID of the user as parameters: no other user has an equal or confusable username. It's checked by the same
+// statement, so that two requests can't take the same username between UsernameIsTaken and the write, also where the
+// unique index on usernamekey is missing.
+const usernameFree = "NOT EXISTS (SELECT 1 FROM users WHERE (usernamekey=? OR usernameskeleton=?) AND id!=?)"
+
+// UsernameIsTaken reports whether another user has a username equal to or confusable with username
+func (db *appdbimpl) UsernameIsTaken(username string, exceptID int) (bool, error) {
+	var count int
+	err := db.queryRow("SELECT COUNT(*) FROM users WHERE (usernamekey=? OR usernameskeleton=?) AND id!=?", usernames.Key(username), usernames.Skeleton(username), exceptID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, err
+}
+
 func (db *appdbimpl) UserIsPresent(id int) (bool, error) {
 	var count int
-	err := db.c.QueryRow("SELECT COUNT(*) FROM users WHERE id=?", id).Scan(&count)
+	err := db.queryRow("SELECT COUNT(*) FROM users WHERE id=? AND deactivatedat IS NULL", id).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -819,9 +1270,123 @@ func (db *appdbimpl) UserIsPresent(id int) (bool, error) {
 
 func (db *appdbimpl) UserIsBanned(idBanner int, idBanned int) (bool, error) {
 	var count int
-	err := db.c.QueryRow("SELECT COUNT(*) FROM bans WHERE bannerid=? AND bannedid=?", idBanner, idBanned).Scan(&count)
+	err := db.queryRow("SELECT COUNT(*) FROM bans WHERE bannerid=? AND bannedid=?", idBanner, idBanned).Scan(&count)
 	if err != nil {
 		return false, err
 	}
 	return count > 0, err
 }
+
+// requireUser returns ErrNotFound if the user does not exist or is deactivated
+func (db *appdbimpl) requireUser(id int) error {
+	present, err := db.UserIsPresent(id)
+	if err != nil {
+		return err
+	}
+	if !present {
+		return newError(ErrNotFound, "user")
+	}
+	return nil
+}
+
+// canInteract checks that the photo exists and that its owner did not ban the user, before adding a like or a comment
+func (db *appdbimpl) canInteract(photoID int, userID int, what string) error {
+	photo, err := db.GetPhoto(photoID)
+	if err != nil {
+		return err
+	}
+	banned, err := db.UserIsBanned(photo.UserID, userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return newError(ErrForbidden, what)
+	}
+	return nil
+}
+
+// GetObjectCounts counts the active users, and the photos, likes and comments
+func (db *appdbimpl) GetObjectCounts() (ObjectCounts, error) {
+	var counts ObjectCounts
+	err := db.queryRow(`SELECT
+		(SELECT COUNT(*) FROM users WHERE deactivatedat IS NULL),
+		(SELECT COUNT(*) FROM photos),
+		(SELECT COUNT(*) FROM likes),
+		(SELECT COUNT(*) FROM comments)`).Scan(&counts.Users, &counts.Photos, &counts.Likes, &counts.Comments)
+	return counts, err
+}
+
+// GetPhotoKeys returns the storage keys of the images of the photos of the user
+func (db *appdbimpl) GetPhotoKeys(userID int) ([]string, error) {
+	rows, err := db.query("SELECT photourl FROM photos WHERE userid=?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetCommentsByUserID returns the comments written by the user on the photos of other users
+func (db *appdbimpl) GetCommentsByUserID(userID int) ([]Comment, error) {
+	rows, err := db.query(`SELECT c.id, c.photoid, c.userid, u.username, c.comment, c.createdat FROM comments c
+		JOIN users u ON u.id=c.userid JOIN photos p ON p.id=c.photoid WHERE c.userid=? AND p.userid<>? ORDER BY c.id`,
+		userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var comments []Comment
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&comment.ID, &comment.PhotoID, &comment.UserID, &comment.Username, &comment.Content, &comment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// GetLikesByUserID returns the likes of the user on the photos of other users
+func (db *appdbimpl) GetLikesByUserID(userID int) ([]Like, error) {
+	rows, err := db.query("SELECT l.userid, l.photoid FROM likes l JOIN photos p ON p.id=l.photoid WHERE l.userid=? AND p.userid<>? ORDER BY l.photoid", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var likes []Like
+	for rows.Next() {
+		var like Like
+		if err := rows.Scan(&like.UserID, &like.PhotoID); err != nil {
+			return nil, err
+		}
+		likes = append(likes, like)
+	}
+	return likes, rows.Err()
+}
+
+// GetAllPhotoRefs returns the ID, owner, storage key and creation time of every photo, without the counters
+func (db *appdbimpl) GetAllPhotoRefs() ([]Photo, error) {
+	rows, err := db.query("SELECT id, userid, photourl, createdat FROM photos ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var photos []Photo
+	for rows.Next() {
+		var photo Photo
+		if err := rows.Scan(&photo.ID, &photo.UserID, &photo.Photourl, &photo.CreatedAt); err != nil {
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+	return photos, rows.Err()
+}
@@ -0,0 +1,25 @@
+package database
+
+// SetDerivativesStatus sets the status of the derivatives of the photo: PENDING, READY or FAILED
+func (db *appdbimpl) SetDerivativesStatus(photoID int, status string) error {
+	_, err := db.exec("UPDATE photos SET derivatives=? WHERE id=?", status, photoID)
+	return err
+}
+
+// GetPhotoIDsByDerivatives returns the IDs of the photos whose derivatives have the status, oldest first
+func (db *appdbimpl) GetPhotoIDsByDerivatives(status string) ([]int, error) {
+	rows, err := db.query("SELECT id FROM photos WHERE derivatives=? ORDER BY id", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
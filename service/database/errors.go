@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Sentinel errors returned by the AppDatabase methods, wrapped in an *Error. Check them with errors.Is.
+var (
+	// ErrNotFound means that the row to read, change or delete does not exist, or that a row it refers to (like the
+	// photo of a like) does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict means that the row already exists, like a second like of the same user to a photo
+	ErrConflict = errors.New("already exists")
+
+	// ErrSelfAction means that a user tried to do something to itself that is not allowed, like banning itself
+	ErrSelfAction = errors.New("not allowed on yourself")
+
+	// ErrForbidden means that the user is not allowed to do it, like commenting the photo of a user who banned them
+	ErrForbidden = errors.New("not allowed")
+)
+
+// Error is the error returned by the AppDatabase methods for the failures the caller can handle. It matches its Kind
+// with errors.Is, and wraps the cause (e.g. sql.ErrNoRows or the SQLite error), if any.
+type Error struct {
+	// Kind is one of the sentinel errors: ErrNotFound, ErrConflict, ErrSelfAction or ErrForbidden
+	Kind error
+
+	// What is the object of the error, like "photo" or "like"
+	What string
+
+	// Err is the cause, nil if the error was detected by the method itself
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.What + " " + e.Kind.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns an *Error detected by the method itself, without a cause
+func newError(kind error, what string) error {
+	return &Error{Kind: kind, What: what}
+}
+
+// classify converts sql.ErrNoRows and the violations of the SQLite constraints into an *Error about what. Other errors
+// are returned as they are.
+func classify(err error, what string) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, What: what, Err: err}
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique:
+			return &Error{Kind: ErrConflict, What: what, Err: err}
+		case sqlite3.ErrConstraintForeignKey:
+			return &Error{Kind: ErrNotFound, What: "referenced row of the " + what, Err: err}
+		}
+	}
+	return err
+}
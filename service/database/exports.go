@@ -0,0 +1,108 @@
+package database
+
+import (
+	"time"
+
+	"wasaPhoto/service/globaltime"
+)
+
+const (
+	PENDING    = "PENDING"
+	READY      = "READY"
+	FAILED     = "FAILED"
+	DOWNLOADED = "DOWNLOADED"
+)
+
+func (db *appdbimpl) AddExport(id string, userID int, tokenHash string, expiresAt time.Time) (Export, error) {
+	createdAt := globaltime.Now().UTC()
+	_, err := db.exec("INSERT INTO exports (id, userid, status, tokenhash, createdat, expiresat) VALUES (?, ?, ?, ?, ?, ?)", id, userID, PENDING, tokenHash, createdAt, expiresAt.UTC())
+	if err != nil {
+		return Export{}, err
+	}
+	return Export{
+		ID:        id,
+		UserID:    userID,
+		Status:    PENDING,
+		TokenHash: tokenHash,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt.UTC(),
+	}, err
+}
+
+func (db *appdbimpl) GetExport(id string) (Export, error) {
+	var export Export
+	err := db.queryRow("SELECT id, userid, status, path, size, tokenhash, error, createdat, expiresat FROM exports WHERE id=?", id).Scan(&export.ID, &export.UserID, &export.Status, &export.Path, &export.Size, &export.TokenHash, &export.Error, &export.CreatedAt, &export.ExpiresAt)
+	if err != nil {
+		return Export{}, classify(err, "export")
+	}
+	return export, err
+}
+
+func (db *appdbimpl) UserHasPendingExport(userID int) (bool, error) {
+	var count int
+	err := db.queryRow("SELECT COUNT(*) FROM exports WHERE userid=? AND status=?", userID, PENDING).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, err
+}
+
+func (db *appdbimpl) SetExportReady(id string, path string, size int64) error {
+	_, err := db.exec("UPDATE exports SET status=?, path=?, size=? WHERE id=?", READY, path, size, id)
+	return err
+}
+
+func (db *appdbimpl) SetExportFailed(id string, reason string) error {
+	_, err := db.exec("UPDATE exports SET status=?, error=? WHERE id=?", FAILED, reason, id)
+	return err
+}
+
+// FailPendingExports marks as failed the exports that were still being built when the service stopped
+func (db *appdbimpl) FailPendingExports(reason string) error {
+	_, err := db.exec("UPDATE exports SET status=?, error=? WHERE status=?", FAILED, reason, PENDING)
+	return err
+}
+
+// ConsumeExport marks a ready export as downloaded. It returns false if the export was not ready, e.g. because it was
+// already downloaded: the download link can be used only once.
+func (db *appdbimpl) ConsumeExport(id string) (bool, error) {
+	res, err := db.exec("UPDATE exports SET status=? WHERE id=? AND status=?", DOWNLOADED, id, READY)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, err
+}
+
+// GetExportsExpiredBefore returns the exports whose download link expired before t
+func (db *appdbimpl) GetExportsExpiredBefore(t time.Time) ([]Export, error) {
+	rows, err := db.query("SELECT id, userid, status, path, size, tokenhash, error, createdat, expiresat FROM exports WHERE expiresat < ?", t.UTC())
+	if err != nil {
+		return nil, err
+	}
+	var exports []Export
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err() // or modify return value
+	}()
+	for rows.Next() {
+		var export Export
+		err = rows.Scan(&export.ID, &export.UserID, &export.Status, &export.Path, &export.Size, &export.TokenHash, &export.Error, &export.CreatedAt, &export.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, nil
+}
+
+func (db *appdbimpl) DeleteExport(id string) (Status, error) {
+	_, err := db.exec("DELETE FROM exports WHERE id=?", id)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Status: DELETED}, err
+}
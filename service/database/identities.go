@@ -0,0 +1,22 @@
+package database
+
+func (db *appdbimpl) GetUserByIdentity(issuer string, subject string) (User, error) {
+	var user User
+	err := db.queryRow("SELECT users.id, users.username FROM identities JOIN users ON users.id = identities.userid WHERE identities.issuer=? AND identities.subject=?", issuer, subject).Scan(&user.ID, &user.Username)
+	if err != nil {
+		return User{}, classify(err, "identity")
+	}
+	return user, err
+}
+
+func (db *appdbimpl) AddIdentity(issuer string, subject string, userID int) (Identity, error) {
+	_, err := db.exec("INSERT INTO identities (issuer, subject, userid) VALUES (?, ?, ?)", issuer, subject, userID)
+	if err != nil {
+		return Identity{}, classify(err, "identity")
+	}
+	return Identity{
+		Issuer:  issuer,
+		Subject: subject,
+		UserID:  userID,
+	}, err
+}
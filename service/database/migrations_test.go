@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMigrateSessions(t *testing.T) {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the sessions table before the list of sessions
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, err = conn.Exec(`CREATE TABLE sessions (
+			id TEXT NOT NULL PRIMARY KEY,
+			userid INTEGER NOT NULL,
+			createdat DATETIME NOT NULL,
+			expiresat DATETIME NOT NULL,
+			revoked BOOLEAN NOT NULL DEFAULT 0
+		);
+		INSERT INTO sessions (id, userid, createdat, expiresat) VALUES ('old', 1, ?, ?);`,
+		createdAt, createdAt.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// twice, as on every start
+	var db AppDatabase
+	for i := 0; i < 2; i++ {
+		db, err = New(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, err := db.GetSession("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !old.LastSeen.Equal(createdAt) || old.UserAgent != "" || old.IP != "" {
+		t.Errorf("old session: %+v", old)
+	}
+	if _, err := db.AddSession("new", 1, createdAt.Add(time.Hour), "agent", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	added, err := db.GetSession("new")
+	if err != nil || added.UserAgent != "agent" || added.IP != "192.0.2.1" || added.LastSeen.IsZero() {
+		t.Errorf("new session: %+v, %v", added, err)
+	}
+}
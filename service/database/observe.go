@@ -0,0 +1,536 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Observer is called when an operation on the database starts, with its context and name (the name of the AppDatabase
+// method, or the SQL statement). It returns the context of the operation, and a function called when it ends with its
+// error.
+type Observer func(ctx context.Context, name string) (context.Context, func(err error))
+
+// Observe returns an AppDatabase that calls observer around every method of db, to measure them without changing
+// them. The Jsonifica functions do not use the database and are not observed.
+func Observe(db AppDatabase, observer Observer) AppDatabase {
+	return &observedDB{db: db, observe: observer, ctx: context.Background()}
+}
+
+type observedDB struct {
+	db      AppDatabase
+	observe Observer
+	ctx     context.Context
+}
+
+func (o *observedDB) WithContext(ctx context.Context) AppDatabase {
+	return &observedDB{db: o.db, observe: o.observe, ctx: ctx}
+}
+
+func (o *observedDB) GetUserByUsername(username string) (User, error) {
+	ctx, done := o.observe(o.ctx, "GetUserByUsername")
+	res, err := o.db.WithContext(ctx).GetUserByUsername(username)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetUserByID(id int) (User, error) {
+	ctx, done := o.observe(o.ctx, "GetUserByID")
+	res, err := o.db.WithContext(ctx).GetUserByID(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetUserExtendedByID(id int) (UserExtended, error) {
+	ctx, done := o.observe(o.ctx, "GetUserExtendedByID")
+	res, err := o.db.WithContext(ctx).GetUserExtendedByID(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetUsers() ([]User, error) {
+	ctx, done := o.observe(o.ctx, "GetUsers")
+	res, err := o.db.WithContext(ctx).GetUsers()
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetFollowersID(userID int) ([]User, error) {
+	ctx, done := o.observe(o.ctx, "GetFollowersID")
+	res, err := o.db.WithContext(ctx).GetFollowersID(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetFollowingID(userID int) ([]User, error) {
+	ctx, done := o.observe(o.ctx, "GetFollowingID")
+	res, err := o.db.WithContext(ctx).GetFollowingID(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) Ping() error {
+	ctx, done := o.observe(o.ctx, "Ping")
+	err := o.db.WithContext(ctx).Ping()
+	done(err)
+	return err
+}
+
+func (o *observedDB) GetPhotos(userPhoto int, iAmId int) ([]Photo, error) {
+	ctx, done := o.observe(o.ctx, "GetPhotos")
+	res, err := o.db.WithContext(ctx).GetPhotos(userPhoto, iAmId)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetPhoto(photoID int) (Photo, error) {
+	ctx, done := o.observe(o.ctx, "GetPhoto")
+	res, err := o.db.WithContext(ctx).GetPhoto(photoID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetCommentsByPhotoID(photoID int) ([]Comment, error) {
+	ctx, done := o.observe(o.ctx, "GetCommentsByPhotoID")
+	res, err := o.db.WithContext(ctx).GetCommentsByPhotoID(photoID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetLikes(photoID int) ([]User, error) {
+	ctx, done := o.observe(o.ctx, "GetLikes")
+	res, err := o.db.WithContext(ctx).GetLikes(photoID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetFeed(userID int) ([]Photo, error) {
+	ctx, done := o.observe(o.ctx, "GetFeed")
+	res, err := o.db.WithContext(ctx).GetFeed(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetBansID(userID int) ([]User, error) {
+	ctx, done := o.observe(o.ctx, "GetBansID")
+	res, err := o.db.WithContext(ctx).GetBansID(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddUser(username string) (User, error) {
+	ctx, done := o.observe(o.ctx, "AddUser")
+	res, err := o.db.WithContext(ctx).AddUser(username)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddComment(photoID int, userID int, comment string) (Comment, error) {
+	ctx, done := o.observe(o.ctx, "AddComment")
+	res, err := o.db.WithContext(ctx).AddComment(photoID, userID, comment)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddLike(photoID int, userID int) (Like, error) {
+	ctx, done := o.observe(o.ctx, "AddLike")
+	res, err := o.db.WithContext(ctx).AddLike(photoID, userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddFollow(followerID int, followingID int) (Follow, error) {
+	ctx, done := o.observe(o.ctx, "AddFollow")
+	res, err := o.db.WithContext(ctx).AddFollow(followerID, followingID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddBan(bannedID int, bannerID int) (Ban, error) {
+	ctx, done := o.observe(o.ctx, "AddBan")
+	res, err := o.db.WithContext(ctx).AddBan(bannedID, bannerID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeleteUser(id int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "DeleteUser")
+	res, err := o.db.WithContext(ctx).DeleteUser(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeletePhoto(id int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "DeletePhoto")
+	res, err := o.db.WithContext(ctx).DeletePhoto(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetPhotoKeys(userID int) ([]string, error) {
+	ctx, done := o.observe(o.ctx, "GetPhotoKeys")
+	res, err := o.db.WithContext(ctx).GetPhotoKeys(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetCommentsByUserID(userID int) ([]Comment, error) {
+	ctx, done := o.observe(o.ctx, "GetCommentsByUserID")
+	res, err := o.db.WithContext(ctx).GetCommentsByUserID(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetLikesByUserID(userID int) ([]Like, error) {
+	ctx, done := o.observe(o.ctx, "GetLikesByUserID")
+	res, err := o.db.WithContext(ctx).GetLikesByUserID(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetAllPhotoRefs() ([]Photo, error) {
+	ctx, done := o.observe(o.ctx, "GetAllPhotoRefs")
+	res, err := o.db.WithContext(ctx).GetAllPhotoRefs()
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) SetDerivativesStatus(photoID int, status string) error {
+	ctx, done := o.observe(o.ctx, "SetDerivativesStatus")
+	err := o.db.WithContext(ctx).SetDerivativesStatus(photoID, status)
+	done(err)
+	return err
+}
+
+func (o *observedDB) GetPhotoIDsByDerivatives(status string) ([]int, error) {
+	ctx, done := o.observe(o.ctx, "GetPhotoIDsByDerivatives")
+	res, err := o.db.WithContext(ctx).GetPhotoIDsByDerivatives(status)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeleteComment(id int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "DeleteComment")
+	res, err := o.db.WithContext(ctx).DeleteComment(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeleteLike(photoID int, userID int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "DeleteLike")
+	res, err := o.db.WithContext(ctx).DeleteLike(photoID, userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeleteFollow(followerID int, followingID int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "DeleteFollow")
+	res, err := o.db.WithContext(ctx).DeleteFollow(followerID, followingID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeleteBan(bannedID int, bannerID int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "DeleteBan")
+	res, err := o.db.WithContext(ctx).DeleteBan(bannedID, bannerID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) UpdateUser(id int, username string) (User, error) {
+	ctx, done := o.observe(o.ctx, "UpdateUser")
+	res, err := o.db.WithContext(ctx).UpdateUser(id, username)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddPhoto(id int, photourl string, mimeType string, width int, height int, title string, description string) (Photo, error) {
+	ctx, done := o.observe(o.ctx, "AddPhoto")
+	res, err := o.db.WithContext(ctx).AddPhoto(id, photourl, mimeType, width, height, title, description)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddPhotoAt(userID int, photourl string, mimeType string, width int, height int, title string, description string, createdAt time.Time) (Photo, error) {
+	ctx, done := o.observe(o.ctx, "AddPhotoAt")
+	res, err := o.db.WithContext(ctx).AddPhotoAt(userID, photourl, mimeType, width, height, title, description, createdAt)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddCommentAt(photoID int, userID int, comment string, createdAt time.Time) (Comment, error) {
+	ctx, done := o.observe(o.ctx, "AddCommentAt")
+	res, err := o.db.WithContext(ctx).AddCommentAt(photoID, userID, comment, createdAt)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) SearchUser(username string, UserID int) ([]UserBanFollow, error) {
+	ctx, done := o.observe(o.ctx, "SearchUser")
+	res, err := o.db.WithContext(ctx).SearchUser(username, UserID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetCommentByID(id int) (Comment, error) {
+	ctx, done := o.observe(o.ctx, "GetCommentByID")
+	res, err := o.db.WithContext(ctx).GetCommentByID(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) UserIsPresent(id int) (bool, error) {
+	ctx, done := o.observe(o.ctx, "UserIsPresent")
+	res, err := o.db.WithContext(ctx).UserIsPresent(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) UsernameIsTaken(username string, exceptID int) (bool, error) {
+	ctx, done := o.observe(o.ctx, "UsernameIsTaken")
+	res, err := o.db.WithContext(ctx).UsernameIsTaken(username, exceptID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) UserIsBanned(bannerID int, bannedID int) (bool, error) {
+	ctx, done := o.observe(o.ctx, "UserIsBanned")
+	res, err := o.db.WithContext(ctx).UserIsBanned(bannerID, bannedID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) JsonificaUsersFun(users []UserBanFollow) JsonificaUsersBanFollow {
+	return o.db.JsonificaUsersFun(users)
+}
+
+func (o *observedDB) JsonificaPhotosFun(photos []Photo) JsonificaPhotos {
+	return o.db.JsonificaPhotosFun(photos)
+}
+
+func (o *observedDB) JsonificaCommentsFun(comments []Comment) JsonificaComments {
+	return o.db.JsonificaCommentsFun(comments)
+}
+
+func (o *observedDB) AddUserWithPassword(username string, passwordHash string) (User, error) {
+	ctx, done := o.observe(o.ctx, "AddUserWithPassword")
+	res, err := o.db.WithContext(ctx).AddUserWithPassword(username, passwordHash)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetPasswordHash(id int) (string, error) {
+	ctx, done := o.observe(o.ctx, "GetPasswordHash")
+	res, err := o.db.WithContext(ctx).GetPasswordHash(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) SetPasswordHash(id int, passwordHash string, keepSessionID string) (Status, error) {
+	ctx, done := o.observe(o.ctx, "SetPasswordHash")
+	res, err := o.db.WithContext(ctx).SetPasswordHash(id, passwordHash, keepSessionID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetUserByIdentity(issuer string, subject string) (User, error) {
+	ctx, done := o.observe(o.ctx, "GetUserByIdentity")
+	res, err := o.db.WithContext(ctx).GetUserByIdentity(issuer, subject)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddIdentity(issuer string, subject string, userID int) (Identity, error) {
+	ctx, done := o.observe(o.ctx, "AddIdentity")
+	res, err := o.db.WithContext(ctx).AddIdentity(issuer, subject, userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddAPIKey(id string, userID int, name string, scopes []string, hash string) (APIKey, error) {
+	ctx, done := o.observe(o.ctx, "AddAPIKey")
+	res, err := o.db.WithContext(ctx).AddAPIKey(id, userID, name, scopes, hash)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetAPIKey(id string) (APIKey, error) {
+	ctx, done := o.observe(o.ctx, "GetAPIKey")
+	res, err := o.db.WithContext(ctx).GetAPIKey(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetAPIKeysByUserID(userID int) ([]APIKey, error) {
+	ctx, done := o.observe(o.ctx, "GetAPIKeysByUserID")
+	res, err := o.db.WithContext(ctx).GetAPIKeysByUserID(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) TouchAPIKey(id string) error {
+	ctx, done := o.observe(o.ctx, "TouchAPIKey")
+	err := o.db.WithContext(ctx).TouchAPIKey(id)
+	done(err)
+	return err
+}
+
+func (o *observedDB) RevokeAPIKey(id string) (Status, error) {
+	ctx, done := o.observe(o.ctx, "RevokeAPIKey")
+	res, err := o.db.WithContext(ctx).RevokeAPIKey(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddSession(id string, userID int, expiresAt time.Time, userAgent string, ip string) (Session, error) {
+	ctx, done := o.observe(o.ctx, "AddSession")
+	res, err := o.db.WithContext(ctx).AddSession(id, userID, expiresAt, userAgent, ip)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetSession(id string) (Session, error) {
+	ctx, done := o.observe(o.ctx, "GetSession")
+	res, err := o.db.WithContext(ctx).GetSession(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetSessionsByUserID(userID int) ([]Session, error) {
+	ctx, done := o.observe(o.ctx, "GetSessionsByUserID")
+	res, err := o.db.WithContext(ctx).GetSessionsByUserID(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) TouchSession(id string) error {
+	ctx, done := o.observe(o.ctx, "TouchSession")
+	err := o.db.WithContext(ctx).TouchSession(id)
+	done(err)
+	return err
+}
+
+func (o *observedDB) RevokeSession(id string) (Status, error) {
+	ctx, done := o.observe(o.ctx, "RevokeSession")
+	res, err := o.db.WithContext(ctx).RevokeSession(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) RevokeUserSessions(userID int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "RevokeUserSessions")
+	res, err := o.db.WithContext(ctx).RevokeUserSessions(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeactivateUser(id int) (time.Time, error) {
+	ctx, done := o.observe(o.ctx, "DeactivateUser")
+	res, err := o.db.WithContext(ctx).DeactivateUser(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) RestoreUser(id int) (Status, error) {
+	ctx, done := o.observe(o.ctx, "RestoreUser")
+	res, err := o.db.WithContext(ctx).RestoreUser(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetDeactivatedAt(id int) (time.Time, error) {
+	ctx, done := o.observe(o.ctx, "GetDeactivatedAt")
+	res, err := o.db.WithContext(ctx).GetDeactivatedAt(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetUsersDeactivatedBefore(t time.Time) ([]User, error) {
+	ctx, done := o.observe(o.ctx, "GetUsersDeactivatedBefore")
+	res, err := o.db.WithContext(ctx).GetUsersDeactivatedBefore(t)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) AddExport(id string, userID int, tokenHash string, expiresAt time.Time) (Export, error) {
+	ctx, done := o.observe(o.ctx, "AddExport")
+	res, err := o.db.WithContext(ctx).AddExport(id, userID, tokenHash, expiresAt)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetExport(id string) (Export, error) {
+	ctx, done := o.observe(o.ctx, "GetExport")
+	res, err := o.db.WithContext(ctx).GetExport(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) UserHasPendingExport(userID int) (bool, error) {
+	ctx, done := o.observe(o.ctx, "UserHasPendingExport")
+	res, err := o.db.WithContext(ctx).UserHasPendingExport(userID)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) SetExportReady(id string, path string, size int64) error {
+	ctx, done := o.observe(o.ctx, "SetExportReady")
+	err := o.db.WithContext(ctx).SetExportReady(id, path, size)
+	done(err)
+	return err
+}
+
+func (o *observedDB) SetExportFailed(id string, reason string) error {
+	ctx, done := o.observe(o.ctx, "SetExportFailed")
+	err := o.db.WithContext(ctx).SetExportFailed(id, reason)
+	done(err)
+	return err
+}
+
+func (o *observedDB) FailPendingExports(reason string) error {
+	ctx, done := o.observe(o.ctx, "FailPendingExports")
+	err := o.db.WithContext(ctx).FailPendingExports(reason)
+	done(err)
+	return err
+}
+
+func (o *observedDB) ConsumeExport(id string) (bool, error) {
+	ctx, done := o.observe(o.ctx, "ConsumeExport")
+	res, err := o.db.WithContext(ctx).ConsumeExport(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetExportsExpiredBefore(t time.Time) ([]Export, error) {
+	ctx, done := o.observe(o.ctx, "GetExportsExpiredBefore")
+	res, err := o.db.WithContext(ctx).GetExportsExpiredBefore(t)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) DeleteExport(id string) (Status, error) {
+	ctx, done := o.observe(o.ctx, "DeleteExport")
+	res, err := o.db.WithContext(ctx).DeleteExport(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetObjectCounts() (ObjectCounts, error) {
+	ctx, done := o.observe(o.ctx, "GetObjectCounts")
+	res, err := o.db.WithContext(ctx).GetObjectCounts()
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) GetUserSettings(id int) (UserSettings, error) {
+	ctx, done := o.observe(o.ctx, "GetUserSettings")
+	res, err := o.db.WithContext(ctx).GetUserSettings(id)
+	done(err)
+	return res, err
+}
+
+func (o *observedDB) SetUserSettings(id int, settings UserSettings) (UserSettings, error) {
+	ctx, done := o.observe(o.ctx, "SetUserSettings")
+	res, err := o.db.WithContext(ctx).SetUserSettings(id, settings)
+	done(err)
+	return res, err
+}
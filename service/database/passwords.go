@@ -0,0 +1,46 @@
+package database
+
+import (
+	"database/sql"
+	"wasaPhoto/service/usernames"
+)
+
+func (db *appdbimpl) AddUserWithPassword(username string, passwordHash string) (User, error) {
+	key, skeleton := usernames.Key(username), usernames.Skeleton(username)
+	res, err := db.exec("INSERT INTO users (username, usernamekey, usernameskeleton, passwordhash) SELECT ?, ?, ?, ? WHERE "+usernameFree, username, key, skeleton, passwordHash, key, skeleton, 0)
+	return insertedUser(res, err, username)
+}
+
+// GetPasswordHash returns the password hash of the user, or an empty string if the account has no password
+func (db *appdbimpl) GetPasswordHash(id int) (string, error) {
+	var hash sql.NullString
+	err := db.queryRow("SELECT passwordhash FROM users WHERE id=?", id).Scan(&hash)
+	if err != nil {
+		return "", classify(err, "user")
+	}
+	return hash.String, err
+}
+
+// SetPasswordHash changes the password hash of the user and revokes what could have been obtained with the old
+// password: the sessions of the user, except keepSessionID (empty to revoke all), and its API keys
+func (db *appdbimpl) SetPasswordHash(id int, passwordHash string, keepSessionID string) (Status, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return Status{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("UPDATE users SET passwordhash=? WHERE id=?", passwordHash, id)
+	if err != nil {
+		return Status{}, err
+	}
+	_, err = tx.Exec("UPDATE sessions SET revoked=1 WHERE userid=? AND id!=?", id, keepSessionID)
+	if err != nil {
+		return Status{}, err
+	}
+	_, err = tx.Exec("UPDATE apikeys SET revoked=1 WHERE userid=?", id)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Status: "UPDATED"}, tx.Commit()
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"time"
+
+	"wasaPhoto/service/globaltime"
+)
+
+func (db *appdbimpl) AddSession(id string, userID int, expiresAt time.Time, userAgent string, ip string) (Session, error) {
+	createdAt := globaltime.Now().UTC()
+	_, err := db.exec("INSERT INTO sessions (id, userid, createdat, lastseen, expiresat, useragent, ip) VALUES (?, ?, ?, ?, ?, ?, ?)", id, userID, createdAt, createdAt, expiresAt.UTC(), userAgent, ip)
+	if err != nil {
+		return Session{}, err
+	}
+	return Session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: createdAt,
+		LastSeen:  createdAt,
+		ExpiresAt: expiresAt.UTC(),
+		UserAgent: userAgent,
+		IP:        ip,
+	}, err
+}
+
+func (db *appdbimpl) GetSession(id string) (Session, error) {
+	var session Session
+	err := db.queryRow("SELECT id, userid, createdat, lastseen, expiresat, useragent, ip, revoked FROM sessions WHERE id=?", id).Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.LastSeen, &session.ExpiresAt, &session.UserAgent, &session.IP, &session.Revoked)
+	if err != nil {
+		return Session{}, classify(err, "session")
+	}
+	return session, err
+}
+
+// GetSessionsByUserID returns the sessions of the user that are not revoked nor expired, most recently used first
+func (db *appdbimpl) GetSessionsByUserID(userID int) ([]Session, error) {
+	rows, err := db.query("SELECT id, userid, createdat, lastseen, expiresat, useragent, ip, revoked FROM sessions WHERE userid=? AND revoked=0 ORDER BY lastseen DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	defer func() {
+		_ = rows.Close()
+		_ = rows.Err() // or modify return value
+	}()
+	now := globaltime.Now()
+	for rows.Next() {
+		var session Session
+		err = rows.Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.LastSeen, &session.ExpiresAt, &session.UserAgent, &session.IP, &session.Revoked)
+		if err != nil {
+			return nil, err
+		}
+		if !now.Before(session.ExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// TouchSession records that the session has just been used
+func (db *appdbimpl) TouchSession(id string) error {
+	_, err := db.exec("UPDATE sessions SET lastseen=? WHERE id=?", globaltime.Now().UTC(), id)
+	return err
+}
+
+func (db *appdbimpl) RevokeSession(id string) (Status, error) {
+	_, err := db.exec("UPDATE sessions SET revoked=1 WHERE id=?", id)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Status: DELETED}, err
+}
+
+// RevokeUserSessions revokes every session of the user ("log out everywhere")
+func (db *appdbimpl) RevokeUserSessions(userID int) (Status, error) {
+	_, err := db.exec("UPDATE sessions SET revoked=1 WHERE userid=?", userID)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{Status: DELETED}, err
+}
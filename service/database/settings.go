@@ -0,0 +1,27 @@
+package database
+
+// GetUserSettings returns the preferences of the user
+func (db *appdbimpl) GetUserSettings(id int) (UserSettings, error) {
+	var settings UserSettings
+	err := db.queryRow("SELECT keepmetadata FROM users WHERE id=?", id).Scan(&settings.KeepMetadata)
+	if err != nil {
+		return UserSettings{}, classify(err, "user")
+	}
+	return settings, nil
+}
+
+// SetUserSettings replaces the preferences of the user, and returns them
+func (db *appdbimpl) SetUserSettings(id int, settings UserSettings) (UserSettings, error) {
+	res, err := db.exec("UPDATE users SET keepmetadata=? WHERE id=?", settings.KeepMetadata, id)
+	if err != nil {
+		return UserSettings{}, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return UserSettings{}, err
+	}
+	if affected == 0 {
+		return UserSettings{}, newError(ErrNotFound, "user")
+	}
+	return settings, nil
+}
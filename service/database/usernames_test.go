@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestUsernameFree checks the usernames written on a database of an older version, where two usernames collide after
+// the normalization and the unique index on usernamekey can't be created
+func TestUsernameFree(t *testing.T) {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Exec(`CREATE TABLE users (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE
+		);
+		INSERT INTO users (id, username) VALUES (1, 'Alice'), (2, 'alice'), (3, 'bob');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name='users_usernamekey'").Scan(&index); err != nil || index != 0 {
+		t.Fatalf("unique index: %d, %v", index, err)
+	}
+
+	adds := []struct {
+		username string
+		err      error
+	}{
+		{"ALICE", ErrConflict},
+		{"аlice", ErrConflict},
+		{"B0B", ErrConflict},
+		{"carol", nil},
+		{"Carol", ErrConflict},
+	}
+	for _, tt := range adds {
+		if _, err := db.AddUser(tt.username); !errors.Is(err, tt.err) {
+			t.Errorf("AddUser(%q): error %v, want %v", tt.username, err, tt.err)
+		}
+	}
+	if _, err := db.AddUserWithPassword("bob", "hash"); !errors.Is(err, ErrConflict) {
+		t.Errorf("AddUserWithPassword(bob): error %v, want ErrConflict", err)
+	}
+
+	updates := []struct {
+		id       int
+		username string
+		err      error
+	}{
+		{3, "alice", ErrConflict},
+		{3, "Bob", nil},
+		{3, "robert", nil},
+		{1, "bob", nil},
+		{1, "robert", ErrConflict},
+		{99, "zed", ErrNotFound},
+	}
+	for _, tt := range updates {
+		user, err := db.UpdateUser(tt.id, tt.username)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("UpdateUser(%d, %q): error %v, want %v", tt.id, tt.username, err, tt.err)
+		} else if err == nil && user.Username != tt.username {
+			t.Errorf("UpdateUser(%d, %q): username %q", tt.id, tt.username, user.Username)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Package derivatives renders the smaller versions of the photos, so that clients don't download the original image for
+every tile of the feed. They are JPEG images stored next to the originals, under keys like
+"derivatives/<key of the original>/thumb.jpg" (see Key): they can be rendered again from the original at any time.
+
+The upload as it was sent, with its metadata, is kept next to them when it differs from the published image (see
+OriginalKey): only its owner can download it.
+
+Resizing is done in pure Go with golang.org/x/image/draw. Images are never enlarged: the derivatives of a small photo
+have its size.
+*/
+package derivatives
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"path"
+	"strings"
+	"wasaPhoto/service/storage"
+
+	"golang.org/x/image/draw"
+)
+
+// Size is a derivative of the photos
+type Size struct {
+	// Name is the value of the size parameter of GET /photos/:id, like "thumb"
+	Name string
+
+	// Width is the maximum width in pixels, the height keeps the proportions
+	Width int
+
+	// Square crops the center of the image to a square of Width pixels
+	Square bool
+}
+
+// Sizes are the derivatives rendered for every photo
+var Sizes = []Size{
+	{Name: "thumb", Width: 150, Square: true},
+	{Name: "small", Width: 640},
+	{Name: "large", Width: 1080},
+}
+
+// quality of the JPEG encoding of the derivatives
+const quality = 85
+
+// keyPrefix starts the keys of the derivatives in the storage
+const keyPrefix = "derivatives/"
+
+// ByName returns the size named name, false if there is none
+func ByName(name string) (Size, bool) {
+	for _, size := range Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return Size{}, false
+}
+
+// Key returns the storage key of the derivative of the photo whose image is photoKey
+func Key(photoKey string, size Size) string {
+	return keyPrefix + photoKey + "/" + size.Name + ".jpg"
+}
+
+// OriginalKey returns the storage key of the upload of the photo whose image is photoKey, before its metadata was
+// removed
+func OriginalKey(photoKey string) string {
+	return keyPrefix + photoKey + "/original" + path.Ext(photoKey)
+}
+
+// PhotoKey returns the key of the original image of the derivative key, false if key is not a derivative
+func PhotoKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return "", false
+	}
+	i := strings.LastIndex(key, "/")
+	if i <= len(keyPrefix) {
+		return "", false
+	}
+	return key[len(keyPrefix):i], true
+}
+
+// Render returns img reduced to size
+func Render(img image.Image, size Size) image.Image {
+	src := img.Bounds()
+	if size.Square {
+		// the largest centered square
+		side := src.Dx()
+		if src.Dy() < side {
+			side = src.Dy()
+		}
+		x := src.Min.X + (src.Dx()-side)/2
+		y := src.Min.Y + (src.Dy()-side)/2
+		src = image.Rect(x, y, x+side, y+side)
+	}
+	width, height := src.Dx(), src.Dy()
+	if width > size.Width {
+		height = height * size.Width / width
+		width = size.Width
+		if height < 1 {
+			height = 1
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	// JPEG has no transparency: transparent pixels become white
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+	return dst
+}
+
+// Generate renders every size of img, the decoded image of the photo photoKey, and writes them into store
+func Generate(ctx context.Context, store storage.BlobStore, photoKey string, img image.Image) error {
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, Render(img, size), &jpeg.Options{Quality: quality}); err != nil {
+			return err
+		}
+		if _, err := store.Put(ctx, Key(photoKey, size), &buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes every derivative of the photo photoKey, and its original upload, from store
+func Delete(ctx context.Context, store storage.BlobStore, photoKey string) error {
+	for _, size := range Sizes {
+		if err := store.Delete(ctx, Key(photoKey, size)); err != nil {
+			return err
+		}
+	}
+	return store.Delete(ctx, OriginalKey(photoKey))
+}
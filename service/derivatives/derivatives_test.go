@@ -0,0 +1,124 @@
+package derivatives
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// stripes returns an image of width x height pixels, red in the left third, green in the middle and blue in the right
+func stripes(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		c := color.RGBA{R: 255, A: 255}
+		if x >= 2*width/3 {
+			c = color.RGBA{B: 255, A: 255}
+		} else if x >= width/3 {
+			c = color.RGBA{G: 255, A: 255}
+		}
+		for y := 0; y < height; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestRender(t *testing.T) {
+	thumb := Size{Name: "thumb", Width: 150, Square: true}
+	small := Size{Name: "small", Width: 640}
+	tests := []struct {
+		name          string
+		src           image.Image
+		size          Size
+		width, height int
+	}{
+		{"square, landscape", stripes(900, 300), thumb, 150, 150},
+		{"square, portrait", stripes(300, 900), thumb, 150, 150},
+		{"square, smaller", stripes(120, 80), thumb, 80, 80},
+		{"square, not at the origin", stripes(400, 300).(*image.RGBA).SubImage(image.Rect(100, 50, 400, 300)), thumb, 150, 150},
+		{"landscape", stripes(1280, 720), small, 640, 360},
+		{"portrait", stripes(720, 1280), small, 640, 1137},
+		{"smaller", stripes(300, 200), small, 300, 200},
+		{"same width", stripes(640, 100), small, 640, 100},
+		{"thin", stripes(6400, 5), small, 640, 1},
+	}
+	for _, tt := range tests {
+		got := Render(tt.src, tt.size).Bounds()
+		if got.Min != (image.Point{}) || got.Dx() != tt.width || got.Dy() != tt.height {
+			t.Errorf("%s: %v, want %dx%d", tt.name, got, tt.width, tt.height)
+		}
+	}
+}
+
+func TestRenderCrop(t *testing.T) {
+	// the square is the center of the image: the green stripe, without red and blue
+	img := Render(stripes(900, 300), Size{Width: 150, Square: true})
+	for _, p := range []image.Point{{0, 0}, {75, 75}, {149, 149}} {
+		r, g, b, _ := img.At(p.X, p.Y).RGBA()
+		if r > 0x1000 || b > 0x1000 || g < 0xf000 {
+			t.Errorf("pixel %v of the square is %v", p, img.At(p.X, p.Y))
+		}
+	}
+	// the whole width is kept without the crop
+	img = Render(stripes(900, 300), Size{Width: 300})
+	if r, _, _, _ := img.At(0, 50).RGBA(); r < 0xf000 {
+		t.Errorf("left pixel is %v", img.At(0, 50))
+	}
+	if _, _, b, _ := img.At(299, 50).RGBA(); b < 0xf000 {
+		t.Errorf("right pixel is %v", img.At(299, 50))
+	}
+}
+
+func TestRenderTransparent(t *testing.T) {
+	img := Render(image.NewNRGBA(image.Rect(0, 0, 10, 10)), Size{Width: 10})
+	if r, g, b, a := img.At(5, 5).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff || a != 0xffff {
+		t.Errorf("transparent pixel is %v, want white", img.At(5, 5))
+	}
+}
+
+func TestKeys(t *testing.T) {
+	thumb, ok := ByName("thumb")
+	if !ok {
+		t.Fatal("no thumb size")
+	}
+	if _, ok := ByName("huge"); ok {
+		t.Error("size huge found")
+	}
+	if key := Key("1/photo.jpg", thumb); key != "derivatives/1/photo.jpg/thumb.jpg" {
+		t.Errorf("Key = %q", key)
+	}
+	if key := OriginalKey("1/photo.png"); key != "derivatives/1/photo.png/original.png" {
+		t.Errorf("OriginalKey = %q", key)
+	}
+}
+
+func TestPhotoKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		photoKey string
+		ok       bool
+	}{
+		{"derivatives/1/photo.jpg/thumb.jpg", "1/photo.jpg", true},
+		{"derivatives/photo.jpg/small.jpg", "photo.jpg", true},
+		{"derivatives/1/photo.png/original.png", "1/photo.png", true},
+		{"1/photo.jpg", "", false},
+		{"photo.jpg", "", false},
+		{"derivatives/thumb.jpg", "", false},
+		{"derivatives/", "", false},
+		{"derivatives//thumb.jpg", "", false},
+		{"derivatives", "", false},
+		{"other/derivatives/photo.jpg/thumb.jpg", "", false},
+		{"Derivatives/photo.jpg/thumb.jpg", "", false},
+	}
+	for _, tt := range tests {
+		if photoKey, ok := PhotoKey(tt.key); photoKey != tt.photoKey || ok != tt.ok {
+			t.Errorf("PhotoKey(%q) = %q, %v, want %q, %v", tt.key, photoKey, ok, tt.photoKey, tt.ok)
+		}
+	}
+	// the keys of the derivatives lead back to their photo
+	for _, size := range Sizes {
+		if photoKey, ok := PhotoKey(Key("1/photo.jpg", size)); photoKey != "1/photo.jpg" || !ok {
+			t.Errorf("PhotoKey(Key(%s)) = %q, %v", size.Name, photoKey, ok)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Package imaging recognizes the images of the photos. The format is detected from the content, never from the file
+name or the Content-Type of the upload, and the whole image is decoded, so that files that are not images, or are
+truncated or corrupt, are rejected before they are stored.
+
+The supported formats are JPEG, PNG, GIF (every frame is checked) and WebP.
+
+Sanitize removes the metadata of the images before they are published, applying the EXIF orientation of the JPEG
+images to their pixels.
+*/
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/webp"
+)
+
+// Format is a supported image format
+type Format struct {
+	// Name is the name used by the image package, like "jpeg"
+	Name string
+
+	// MimeType is the media type of the format, like "image/jpeg"
+	MimeType string
+
+	// Ext is the extension of the files, like ".jpg"
+	Ext string
+}
+
+// The supported formats
+var (
+	JPEG = Format{Name: "jpeg", MimeType: "image/jpeg", Ext: ".jpg"}
+	PNG  = Format{Name: "png", MimeType: "image/png", Ext: ".png"}
+	GIF  = Format{Name: "gif", MimeType: "image/gif", Ext: ".gif"}
+	WebP = Format{Name: "webp", MimeType: "image/webp", Ext: ".webp"}
+)
+
+// formats are the supported formats by the name used by the image package
+var formats = map[string]Format{JPEG.Name: JPEG, PNG.Name: PNG, GIF.Name: GIF, WebP.Name: WebP}
+
+func init() {
+	// image/jpeg, image/png and image/gif register themselves
+	image.RegisterFormat("webp", "RIFF????WEBPVP8", webp.Decode, webp.DecodeConfig)
+}
+
+var (
+	// ErrUnsupported is returned for content that is not an image in a supported format
+	ErrUnsupported = errors.New("not an image in a supported format (JPEG, PNG, GIF or WebP)")
+
+	// ErrCorrupt is returned for images that are truncated or can't be decoded
+	ErrCorrupt = errors.New("corrupt image")
+
+	// ErrTooLarge is returned for images with more pixels than allowed
+	ErrTooLarge = errors.New("image too large")
+)
+
+// Info describes an image
+type Info struct {
+	Format Format
+	Width  int
+	Height int
+}
+
+// FormatByMimeType returns the format of the media type, false if it's not supported
+func FormatByMimeType(mimeType string) (Format, bool) {
+	for _, format := range formats {
+		if format.MimeType == mimeType {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// Decode detects the format of data and decodes it. Images with more than maxPixels pixels are rejected with
+// ErrTooLarge before being decoded, zero means no limit. For a GIF, the image is the first frame.
+func Decode(data []byte, maxPixels int) (image.Image, Info, error) {
+	config, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, Info{}, ErrUnsupported
+	} else if err != nil {
+		return nil, Info{}, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	format, ok := formats[name]
+	if !ok {
+		return nil, Info{}, ErrUnsupported
+	}
+	info := Info{Format: format, Width: config.Width, Height: config.Height}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, Info{}, fmt.Errorf("%w: empty image", ErrCorrupt)
+	}
+	if maxPixels > 0 && config.Width > maxPixels/config.Height {
+		return nil, Info{}, fmt.Errorf("%w: %dx%d pixels", ErrTooLarge, config.Width, config.Height)
+	}
+
+	var img image.Image
+	switch format {
+	case GIF:
+		var all *gif.GIF
+		all, err = gif.DecodeAll(bytes.NewReader(data))
+		if err == nil && len(all.Image) == 0 {
+			err = errors.New("no frames")
+		} else if err == nil {
+			img = all.Image[0]
+		}
+	case JPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case PNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case WebP:
+		img, err = webp.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return img, info, nil
+}
+
+// Inspect checks that data is a valid image, like Decode, and returns its description
+func Inspect(data []byte, maxPixels int) (Info, error) {
+	_, info, err := Decode(data, maxPixels)
+	return info, err
+}
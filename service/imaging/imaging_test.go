@@ -0,0 +1,116 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// webpImage is a lossless WebP image of 1x1 pixels: the standard library has no encoder
+const webpImage = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// encode returns an image of width x height pixels in the format
+func encode(t *testing.T, format Format, width, height int) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, width, height), []color.Color{color.Black, color.White})
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case JPEG:
+		err = jpeg.Encode(&buf, img, nil)
+	case PNG:
+		err = png.Encode(&buf, img)
+	case GIF:
+		err = gif.Encode(&buf, img, nil)
+	default:
+		t.Fatalf("no encoder for %s", format.Name)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	webp, err := base64.StdEncoding.DecodeString(webpImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data          []byte
+		want          Info
+		width, height int
+	}{
+		{encode(t, JPEG, 30, 20), Info{Format: JPEG, Width: 30, Height: 20}, 30, 20},
+		{encode(t, PNG, 30, 20), Info{Format: PNG, Width: 30, Height: 20}, 30, 20},
+		{encode(t, GIF, 30, 20), Info{Format: GIF, Width: 30, Height: 20}, 30, 20},
+		{webp, Info{Format: WebP, Width: 1, Height: 1}, 1, 1},
+	}
+	for _, tt := range tests {
+		img, info, err := Decode(tt.data, 600)
+		if err != nil {
+			t.Errorf("%s: %v", tt.want.Format.Name, err)
+			continue
+		}
+		if info != tt.want {
+			t.Errorf("%s: info %+v, want %+v", tt.want.Format.Name, info, tt.want)
+		}
+		if size := img.Bounds().Size(); size.X != tt.width || size.Y != tt.height {
+			t.Errorf("%s: image of %v pixels", tt.want.Format.Name, size)
+		}
+		if info, err := Inspect(tt.data, 0); err != nil || info != tt.want {
+			t.Errorf("%s: Inspect: %+v, %v", tt.want.Format.Name, info, err)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	jpegImage := encode(t, JPEG, 30, 20)
+	pngImage := encode(t, PNG, 30, 20)
+	// a grayscale PNG that declares 100000x100000 pixels in its header, and has no pixels: it can't be decoded
+	header := appendUint32(binary.BigEndian, appendUint32(binary.BigEndian, nil, 100000), 100000)
+	hugePNG := append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", append(header, 8, 0, 0, 0, 0))...)
+	// the header and the screen descriptor of a GIF, and the trailer
+	emptyGIF := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x3b")
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrUnsupported},
+		{"text renamed .jpg", []byte("not a photo, just a text file called photo.jpg\n"), ErrUnsupported},
+		{"BMP", []byte("BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00"), ErrUnsupported},
+		{"truncated JPEG", jpegImage[:len(jpegImage)/2], ErrCorrupt},
+		{"truncated PNG", pngImage[:len(pngImage)/2], ErrCorrupt},
+		{"JPEG header only", jpegImage[:20], ErrCorrupt},
+		{"more pixels than allowed", encode(t, PNG, 30, 21), ErrTooLarge},
+		{"declared size above the limit", hugePNG, ErrTooLarge},
+		{"GIF without frames", emptyGIF, ErrCorrupt},
+	}
+	for _, tt := range tests {
+		if _, err := Inspect(tt.data, 30*20); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestFormatByMimeType(t *testing.T) {
+	for _, want := range []Format{JPEG, PNG, GIF, WebP} {
+		if format, ok := FormatByMimeType(want.MimeType); !ok || format != want {
+			t.Errorf("FormatByMimeType(%q) = %+v, %v", want.MimeType, format, ok)
+		}
+	}
+	for _, mimeType := range []string{"", "image/jpg", "IMAGE/PNG", "image/bmp", "text/plain"} {
+		if format, ok := FormatByMimeType(mimeType); ok {
+			t.Errorf("FormatByMimeType(%q) = %+v", mimeType, format)
+		}
+	}
+}
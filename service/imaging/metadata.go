@@ -0,0 +1,527 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+)
+
+// rotatedQuality is the quality of the JPEG images encoded again to apply their orientation
+const rotatedQuality = 92
+
+// SanitizeOptions changes what Sanitize keeps
+type SanitizeOptions struct {
+	// KeepExif keeps the EXIF data of the JPEG images, without the location (the GPS fields) and with the orientation
+	// already applied. The metadata of the other formats is always removed.
+	KeepExif bool
+}
+
+// Sanitize removes the metadata of the image data, decoded as img and described by info, which can reveal where and
+// with which device a photo was taken: EXIF, XMP, comments and text chunks. The EXIF orientation of JPEG images is
+// applied to the pixels, as it can't be kept. It returns the data to publish and its description, which changes when
+// the image is rotated; the pixels are encoded again only in that case, and for GIF.
+func Sanitize(data []byte, img image.Image, info Info, opts SanitizeOptions) ([]byte, Info, error) {
+	switch info.Format {
+	case JPEG:
+		return sanitizeJPEG(data, img, info, opts)
+	case PNG:
+		clean, err := stripPNG(data)
+		return clean, info, err
+	case GIF:
+		clean, err := stripGIF(data)
+		return clean, info, err
+	case WebP:
+		clean, err := stripWebP(data)
+		return clean, info, err
+	}
+	return nil, Info{}, ErrUnsupported
+}
+
+// JPEG markers
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP0 = 0xe0
+	markerAPP1 = 0xe1
+	markerAPP2 = 0xe2
+	markerAPPE = 0xee
+	markerCOM  = 0xfe
+	markerTEM  = 0x01
+	markerRST0 = 0xd0
+	markerRST7 = 0xd7
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+// jpegSegment is a marker segment before the scan. Data is the payload, without the marker and the length.
+type jpegSegment struct {
+	Marker byte
+	Data   []byte
+}
+
+// splitJPEG returns the segments before the first scan, and the scans up to the EOI marker. What follows EOI, like the
+// secondary images of MPF and the trailers appended by some cameras, is dropped: it can carry its own metadata.
+func splitJPEG(data []byte) ([]jpegSegment, []byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, nil, errors.New("missing SOI marker")
+	}
+	var segments []jpegSegment
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return nil, nil, errors.New("invalid marker")
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == markerSOS {
+			scans, err := readScans(data, i)
+			return segments, scans, err
+		}
+		if marker == markerTEM || (marker >= markerRST0 && marker <= markerRST7) {
+			i += 2
+			continue
+		}
+		if marker == markerEOI {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, nil, errors.New("truncated segment")
+		}
+		segments = append(segments, jpegSegment{Marker: marker, Data: data[i+4 : i+2+length]})
+		i += 2 + length
+	}
+	return nil, nil, errors.New("missing scan")
+}
+
+// readScans copies the scans from the SOS marker at i to the EOI marker included. The application segments and the
+// comments between the scans of a progressive image are dropped.
+func readScans(data []byte, i int) ([]byte, error) {
+	var buf bytes.Buffer
+	for i+2 <= len(data) {
+		if data[i] != 0xff {
+			return nil, errors.New("invalid marker")
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff:
+			i++
+			continue
+		case marker == markerEOI:
+			buf.Write(data[i : i+2])
+			return buf.Bytes(), nil
+		case marker == markerTEM || (marker >= markerRST0 && marker <= markerRST7):
+			buf.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, errors.New("truncated segment")
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return nil, errors.New("truncated segment")
+		}
+		if !isMetadata(marker) {
+			buf.Write(data[i:end])
+		}
+		i = end
+		if marker != markerSOS {
+			continue
+		}
+		// the entropy-coded data ends at the first marker: 0xff is followed there only by 0x00 (a 0xff byte of the
+		// data) or by a restart marker
+		j := i
+		for j+1 < len(data) && (data[j] != 0xff || data[j+1] == 0 || (data[j+1] >= markerRST0 && data[j+1] <= markerRST7)) {
+			j++
+		}
+		buf.Write(data[i:j])
+		i = j
+	}
+	return nil, errors.New("missing EOI marker")
+}
+
+// isMetadata returns true for the application segments and the comments
+func isMetadata(marker byte) bool {
+	return (marker >= markerAPP0 && marker <= 0xef) || marker == markerCOM
+}
+
+func writeSegment(buf *bytes.Buffer, marker byte, payload []byte) {
+	buf.Write([]byte{0xff, marker})
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(payload)+2))
+	buf.Write(payload)
+}
+
+func sanitizeJPEG(data []byte, img image.Image, info Info, opts SanitizeOptions) ([]byte, Info, error) {
+	segments, scan, err := splitJPEG(data)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	var exif *tiff
+	for _, segment := range segments {
+		if segment.Marker == markerAPP1 && bytes.HasPrefix(segment.Data, exifHeader) {
+			// a malformed EXIF block is dropped, with its orientation
+			exif, _ = parseTIFF(segment.Data[len(exifHeader):])
+			break
+		}
+	}
+	orientation := 1
+	if exif != nil {
+		orientation = exif.orientation()
+	}
+	var exifSegment []byte
+	if exif != nil && opts.KeepExif {
+		exif.removeGPS()
+		// the thumbnail is not oriented, and may show what was cropped
+		exif.removeThumbnail()
+		exif.setOrientation(1)
+		exifSegment = append(append([]byte{}, exifHeader...), exif.data...)
+		if len(exifSegment)+2 > 0xffff {
+			exifSegment = nil
+		}
+	}
+
+	var buf bytes.Buffer
+	if orientation != 1 {
+		rotated := orient(img, orientation)
+		if err := jpeg.Encode(&buf, rotated, &jpeg.Options{Quality: rotatedQuality}); err != nil {
+			return nil, Info{}, err
+		}
+		info.Width, info.Height = rotated.Bounds().Dx(), rotated.Bounds().Dy()
+		// the encoder writes no application segment: the EXIF data and the color profile follow SOI
+		encoded := buf.Bytes()
+		var out bytes.Buffer
+		out.Write(encoded[:2])
+		if exifSegment != nil {
+			writeSegment(&out, markerAPP1, exifSegment)
+		}
+		for _, segment := range segments {
+			if segment.Marker == markerAPP2 && bytes.HasPrefix(segment.Data, iccHeader) {
+				writeSegment(&out, markerAPP2, segment.Data)
+			}
+		}
+		out.Write(encoded[2:])
+		return out.Bytes(), info, nil
+	}
+
+	// the pixels are kept as they are: only the segments that are not metadata are copied
+	buf.Write([]byte{0xff, markerSOI})
+	for _, segment := range segments {
+		switch {
+		case segment.Marker == markerAPP0, segment.Marker == markerAPPE:
+			// JFIF and Adobe, needed to decode the colors
+		case segment.Marker == markerAPP2 && bytes.HasPrefix(segment.Data, iccHeader):
+			// color profile
+		case segment.Marker == markerAPP1 && bytes.HasPrefix(segment.Data, exifHeader):
+			if exifSegment != nil {
+				writeSegment(&buf, markerAPP1, exifSegment)
+			}
+			continue
+		case isMetadata(segment.Marker):
+			// the other application segments (XMP, IPTC...) and the comments
+			continue
+		}
+		writeSegment(&buf, segment.Marker, segment.Data)
+	}
+	buf.Write(scan)
+	return buf.Bytes(), info, nil
+}
+
+// orient returns img transformed as described by the EXIF orientation, from 2 to 8
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// the source pixel of (x, y)
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// EXIF tags
+const (
+	tagOrientation = 0x0112
+	tagGPSInfo     = 0x8825
+
+	tagThumbnailOffset = 0x0201
+	tagThumbnailLength = 0x0202
+)
+
+// tiffTypeSizes are the sizes in bytes of the TIFF field types, by type
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiff is the TIFF structure of an EXIF block, changed in place
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+	ifd0  uint32
+}
+
+func parseTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, errors.New("short TIFF header")
+	}
+	t := &tiff{data: append([]byte{}, data...)}
+	switch string(data[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid TIFF header")
+	}
+	t.ifd0 = t.order.Uint32(data[4:])
+	if _, err := t.entries(t.ifd0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// entries returns the offsets of the entries of the IFD at offset
+func (t *tiff) entries(offset uint32) ([]uint32, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, errors.New("IFD out of bounds")
+	}
+	count := uint32(t.order.Uint16(t.data[offset:]))
+	if uint64(offset)+2+uint64(count)*12 > uint64(len(t.data)) {
+		return nil, errors.New("IFD out of bounds")
+	}
+	entries := make([]uint32, count)
+	for i := range entries {
+		entries[i] = offset + 2 + uint32(i)*12
+	}
+	return entries, nil
+}
+
+// find returns the offset of the entry of IFD0 with the tag, false if there is none
+func (t *tiff) find(tag uint16) (uint32, bool) {
+	entries, _ := t.entries(t.ifd0)
+	for _, entry := range entries {
+		if t.order.Uint16(t.data[entry:]) == tag {
+			return entry, true
+		}
+	}
+	return 0, false
+}
+
+// orientation returns the Orientation tag, 1 (normal) if missing or invalid
+func (t *tiff) orientation() int {
+	entry, ok := t.find(tagOrientation)
+	if !ok || t.order.Uint16(t.data[entry+2:]) != 3 {
+		return 1
+	}
+	value := int(t.order.Uint16(t.data[entry+8:]))
+	if value < 1 || value > 8 {
+		return 1
+	}
+	return value
+}
+
+func (t *tiff) setOrientation(value uint16) {
+	entry, ok := t.find(tagOrientation)
+	if ok && t.order.Uint16(t.data[entry+2:]) == 3 {
+		t.order.PutUint16(t.data[entry+8:], value)
+	}
+}
+
+// removeGPS overwrites the GPS fields with zeros and empties their IFD
+func (t *tiff) removeGPS() {
+	pointer, ok := t.find(tagGPSInfo)
+	if !ok {
+		return
+	}
+	if !t.clearIFD(t.order.Uint32(t.data[pointer+8:])) {
+		// unreadable: the pointer is removed instead
+		t.order.PutUint16(t.data[pointer:], 0)
+		t.order.PutUint32(t.data[pointer+8:], 0)
+	}
+}
+
+// removeThumbnail overwrites IFD1, the thumbnail, and its JPEG image with zeros, and unlinks it from IFD0
+func (t *tiff) removeThumbnail() {
+	entries, _ := t.entries(t.ifd0)
+	next := uint64(t.ifd0) + 2 + uint64(len(entries))*12
+	if next+4 > uint64(len(t.data)) {
+		return
+	}
+	ifd1 := t.order.Uint32(t.data[next:])
+	if ifd1 == 0 {
+		return
+	}
+	t.order.PutUint32(t.data[next:], 0)
+	thumbnail, _ := t.entries(ifd1)
+	var offset, length uint64
+	for _, entry := range thumbnail {
+		switch t.order.Uint16(t.data[entry:]) {
+		case tagThumbnailOffset:
+			offset = uint64(t.order.Uint32(t.data[entry+8:]))
+		case tagThumbnailLength:
+			length = uint64(t.order.Uint32(t.data[entry+8:]))
+		}
+	}
+	if offset+length <= uint64(len(t.data)) {
+		zero(t.data[offset : offset+length])
+	}
+	t.clearIFD(ifd1)
+}
+
+// clearIFD overwrites the fields of the IFD at offset with zeros and empties it. It returns false if the IFD can't
+// be read.
+func (t *tiff) clearIFD(offset uint32) bool {
+	entries, err := t.entries(offset)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		size := uint64(tiffTypeSizes[t.order.Uint16(t.data[entry+2:])]) * uint64(t.order.Uint32(t.data[entry+4:]))
+		if size > 4 {
+			value := uint64(t.order.Uint32(t.data[entry+8:]))
+			if value+size <= uint64(len(t.data)) {
+				zero(t.data[value : value+size])
+			}
+		}
+		zero(t.data[entry : entry+12])
+	}
+	t.order.PutUint16(t.data[offset:], 0)
+	return true
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngKeptChunks are the PNG chunks copied by stripPNG: the image, its colors and the animation. The text, time and
+// EXIF chunks, and the unknown ones, are removed.
+var pngKeptChunks = map[string]bool{
+	"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true, "tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true,
+	"iCCP": true, "sBIT": true, "bKGD": true, "pHYs": true, "acTL": true, "fcTL": true, "fdAT": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("%w: missing PNG signature", ErrCorrupt)
+	}
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", ErrCorrupt)
+		}
+		length := uint64(binary.BigEndian.Uint32(data[i:]))
+		end := uint64(i) + 12 + length
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", ErrCorrupt)
+		}
+		if pngKeptChunks[string(data[i+4:i+8])] {
+			buf.Write(data[i:end])
+		}
+		if string(data[i+4:i+8]) == "IEND" {
+			break
+		}
+		i = int(end)
+	}
+	return buf.Bytes(), nil
+}
+
+// stripGIF encodes the frames again, without the comments and the application data of the file
+func stripGIF(data []byte) ([]byte, error) {
+	all, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, all); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// VP8X flags of the metadata chunks
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP removes the EXIF and XMP chunks of the RIFF container
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("%w: invalid RIFF header", ErrCorrupt)
+	}
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, fmt.Errorf("%w: truncated RIFF chunk", ErrCorrupt)
+		}
+		fourcc := string(data[i : i+4])
+		size := uint64(binary.LittleEndian.Uint32(data[i+4:]))
+		end := uint64(i) + 8 + size + size%2
+		if end > uint64(len(data)) {
+			if uint64(i)+8+size != uint64(len(data)) {
+				return nil, fmt.Errorf("%w: truncated RIFF chunk", ErrCorrupt)
+			}
+			// the padding byte of the last chunk is missing
+			end = uint64(len(data))
+		}
+		chunk := append([]byte{}, data[i:end]...)
+		switch fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			if len(chunk) > 8 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			body.Write(chunk)
+		default:
+			body.Write(chunk)
+		}
+		i = int(end)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
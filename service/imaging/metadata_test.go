@@ -0,0 +1,337 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	secretLatitude  = []byte("LATITUDE-SECRET-24-BYTES")
+	secretThumbnail = []byte("THUMBNAIL-SECRET")
+	secretXMP       = []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>XMP-SECRET</x:xmpmeta>")
+	secretComment   = []byte("COMMENT-SECRET")
+	secretTrailer   = []byte("\xff\xd8\xff\xe1\x00\x0cMPF-SECRET\xff\xd9")
+	iccSegment      = []byte("ICC_PROFILE\x00\x01\x01PROFILE-DATA")
+)
+
+// testEXIF returns an EXIF block with the orientation, a GPS latitude and a thumbnail
+func testEXIF(orientation uint16) []byte {
+	var b bytes.Buffer
+	w := func(v interface{}) { _ = binary.Write(&b, binary.LittleEndian, v) }
+	b.WriteString("II*\x00")
+	w(uint32(8))
+	// IFD0 at 8: orientation and GPS pointer, then IFD1 at 80
+	w(uint16(2))
+	w(uint16(tagOrientation))
+	w(uint16(3))
+	w(uint32(1))
+	w(uint32(orientation))
+	w(uint16(tagGPSInfo))
+	w(uint16(4))
+	w(uint32(1))
+	w(uint32(38))
+	w(uint32(80))
+	// GPS IFD at 38: the latitude, three rationals at 56
+	w(uint16(1))
+	w(uint16(2))
+	w(uint16(5))
+	w(uint32(3))
+	w(uint32(56))
+	w(uint32(0))
+	b.Write(secretLatitude)
+	// IFD1 at 80: the thumbnail at 110
+	w(uint16(2))
+	w(uint16(tagThumbnailOffset))
+	w(uint16(4))
+	w(uint32(1))
+	w(uint32(110))
+	w(uint16(tagThumbnailLength))
+	w(uint16(4))
+	w(uint32(1))
+	w(uint32(len(secretThumbnail)))
+	w(uint32(0))
+	b.Write(secretThumbnail)
+	return append(append([]byte{}, exifHeader...), b.Bytes()...)
+}
+
+func segment(marker byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	writeSegment(&buf, marker, payload)
+	return buf.Bytes()
+}
+
+// testJPEG returns a 40x20 blue JPEG with a red square in the top left corner, and its metadata
+func testJPEG(t *testing.T, orientation uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.RGBA{B: 255, A: 255})
+			if x < 10 && y < 10 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	var data []byte
+	data = append(data, encoded[:2]...)
+	data = append(data, segment(markerAPP1, testEXIF(orientation))...)
+	data = append(data, segment(markerAPP1, secretXMP)...)
+	data = append(data, segment(markerAPP2, iccSegment)...)
+	data = append(data, segment(markerCOM, secretComment)...)
+	// a comment after the scan, before EOI
+	data = append(data, encoded[2:len(encoded)-2]...)
+	data = append(data, segment(markerCOM, secretComment)...)
+	data = append(data, encoded[len(encoded)-2:]...)
+	return append(data, secretTrailer...)
+}
+
+func assertNoSecrets(t *testing.T, data []byte) {
+	t.Helper()
+	for _, secret := range [][]byte{secretLatitude, secretThumbnail, []byte("XMP-SECRET"), secretComment, []byte("MPF-SECRET")} {
+		if bytes.Contains(data, secret) {
+			t.Errorf("the output contains %q", secret)
+		}
+	}
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xc000 && g < 0x4000 && b < 0x4000
+}
+
+func TestSanitizeJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		orientation   uint16
+		width, height int
+		// the corner where the red square ends up
+		redX, redY int
+	}{
+		{1, 40, 20, 4, 4},
+		{2, 40, 20, 35, 4},
+		{3, 40, 20, 35, 15},
+		{4, 40, 20, 4, 15},
+		{5, 20, 40, 4, 4},
+		{6, 20, 40, 15, 4},
+		{7, 20, 40, 15, 35},
+		{8, 20, 40, 4, 35},
+	}
+	for _, tt := range tests {
+		for _, keep := range []bool{false, true} {
+			data := testJPEG(t, tt.orientation)
+			img, info, err := Decode(data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, outInfo, err := Sanitize(data, img, info, SanitizeOptions{KeepExif: keep})
+			if err != nil {
+				t.Fatalf("orientation %d: %v", tt.orientation, err)
+			}
+			assertNoSecrets(t, out)
+			if !bytes.Contains(out, iccSegment) {
+				t.Errorf("orientation %d: the color profile was removed", tt.orientation)
+			}
+			if bytes.Contains(out, exifHeader) != keep {
+				t.Errorf("orientation %d, keep %v: EXIF present = %v", tt.orientation, keep, !keep)
+			}
+
+			decoded, decodedInfo, err := Decode(out, 0)
+			if err != nil {
+				t.Fatalf("orientation %d: the output can't be decoded: %v", tt.orientation, err)
+			}
+			if outInfo.Width != tt.width || outInfo.Height != tt.height || decodedInfo != outInfo {
+				t.Errorf("orientation %d: got %+v, decoded %+v, want %dx%d", tt.orientation, outInfo, decodedInfo, tt.width, tt.height)
+			}
+			if !isRed(decoded.At(tt.redX, tt.redY)) {
+				t.Errorf("orientation %d: the red square is not at %d,%d", tt.orientation, tt.redX, tt.redY)
+			}
+		}
+	}
+}
+
+func TestSanitizeJPEGKeepExif(t *testing.T) {
+	data := testJPEG(t, 6)
+	img, info, err := Decode(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := Sanitize(data, img, info, SanitizeOptions{KeepExif: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, _, err := splitJPEG(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exif *tiff
+	for _, s := range segments {
+		if s.Marker == markerAPP1 && bytes.HasPrefix(s.Data, exifHeader) {
+			exif, err = parseTIFF(s.Data[len(exifHeader):])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if exif == nil {
+		t.Fatal("the EXIF data was removed")
+	}
+	if got := exif.orientation(); got != 1 {
+		t.Errorf("orientation = %d, want 1", got)
+	}
+	pointer, ok := exif.find(tagGPSInfo)
+	if !ok {
+		t.Fatal("the GPS pointer was removed")
+	}
+	if gps, _ := exif.entries(exif.order.Uint32(exif.data[pointer+8:])); len(gps) != 0 {
+		t.Errorf("the GPS IFD has %d fields", len(gps))
+	}
+	entries, _ := exif.entries(exif.ifd0)
+	if next := exif.ifd0 + 2 + uint32(len(entries))*12; exif.order.Uint32(exif.data[next:]) != 0 {
+		t.Error("IFD1 is still linked")
+	}
+}
+
+func TestSanitizeJPEGUnchanged(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	img, info, err := Decode(buf.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := Sanitize(buf.Bytes(), img, info, SanitizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf.Bytes()) {
+		t.Error("a JPEG without metadata was changed")
+	}
+}
+
+func TestSplitJPEG(t *testing.T) {
+	valid := testJPEG(t, 1)
+	eoi := bytes.LastIndex(valid, []byte{0xff, markerEOI, 0xff, markerSOI})
+	tests := []struct {
+		name string
+		data []byte
+		err  bool
+	}{
+		{"valid", valid, false},
+		{"no SOI", valid[2:], true},
+		{"no EOI", valid[:eoi], true},
+		{"truncated segment", valid[:10], true},
+		{"garbage", []byte("not a jpeg at all"), true},
+	}
+	for _, tt := range tests {
+		segments, scans, err := splitJPEG(tt.data)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(segments) == 0 || !bytes.HasSuffix(scans, []byte{0xff, markerEOI}) {
+			t.Errorf("%s: %d segments, scans end with % x", tt.name, len(segments), scans[len(scans)-2:])
+		}
+		if bytes.Contains(scans, secretComment) {
+			t.Errorf("%s: the comment between the scans was kept", tt.name)
+		}
+	}
+}
+
+func TestSanitizeCorrupt(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		data   []byte
+	}{
+		{"JPEG without EOI", JPEG, []byte{0xff, markerSOI, 0xff, markerSOS, 0x00, 0x02, 0x01, 0x02}},
+		{"PNG without signature", PNG, []byte("not a png")},
+		{"truncated PNG chunk", PNG, append(append([]byte{}, pngSignature...), 0, 0, 0, 99, 'I', 'H', 'D', 'R')},
+		{"WebP without header", WebP, []byte("RIFF\x00\x00\x00\x00WAVE")},
+		{"truncated WebP chunk", WebP, []byte("RIFF\x10\x00\x00\x00WEBPVP8 \xff\x00\x00\x00")},
+	}
+	for _, tt := range tests {
+		_, _, err := Sanitize(tt.data, image.NewGray(image.Rect(0, 0, 1, 1)), Info{Format: tt.format}, SanitizeOptions{})
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: error = %v, want ErrCorrupt", tt.name, err)
+		}
+	}
+}
+
+func appendUint32(order binary.ByteOrder, b []byte, v uint32) []byte {
+	var buf [4]byte
+	order.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func pngChunk(name string, payload []byte) []byte {
+	chunk := appendUint32(binary.BigEndian, nil, uint32(len(payload)))
+	chunk = append(chunk, name...)
+	chunk = append(chunk, payload...)
+	return appendUint32(binary.BigEndian, chunk, crc32.ChecksumIEEE(append([]byte(name), payload...)))
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 5, 5))); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+	// after the signature and IHDR
+	var data []byte
+	data = append(data, clean[:33]...)
+	data = append(data, pngChunk("tEXt", []byte("Comment\x00PNG-SECRET"))...)
+	data = append(data, pngChunk("eXIf", []byte("EXIF-SECRET"))...)
+	data = append(data, clean[33:]...)
+	data = append(data, "TRAILER-SECRET"...)
+
+	out, err := stripPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, clean) {
+		t.Errorf("got %d bytes, want the %d bytes of the clean image", len(out), len(clean))
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	riff := func(chunks ...[]byte) []byte {
+		body := []byte("WEBP")
+		for _, chunk := range chunks {
+			body = append(body, chunk...)
+		}
+		return append(appendUint32(binary.LittleEndian, []byte("RIFF"), uint32(len(body))), body...)
+	}
+	chunk := func(fourcc string, payload []byte) []byte {
+		c := appendUint32(binary.LittleEndian, []byte(fourcc), uint32(len(payload)))
+		c = append(c, payload...)
+		if len(payload)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	vp8x := func(flags byte) []byte { return chunk("VP8X", []byte{flags, 0, 0, 0, 0, 0, 0, 0, 0, 0}) }
+	image := chunk("VP8L", []byte("IMAGE-DATA"))
+
+	data := riff(vp8x(webpFlagEXIF|webpFlagXMP|0x10), image, chunk("EXIF", []byte("EXIF-SECRET")), chunk("XMP ", []byte("XMP-SECRET")))
+	out, err := stripWebP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := riff(vp8x(0x10), image); !bytes.Equal(out, want) {
+		t.Errorf("got % x, want % x", out, want)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Package integrity compares the photos in the database with the images in the blob storage, and repairs what doesn't
+match: photos whose image is missing, and images that no photo refers to (orphans), left by uploads interrupted between
+the two writes. The derivatives of a photo belong to it: they are orphans once the photo is gone.
+
+The photos are read before the images are listed, so an upload that completes during the check is never taken for a
+photo without image. Its image may be taken for an orphan instead: images younger than Options.Grace are never reported.
+*/
+package integrity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/derivatives"
+	"wasaPhoto/service/storage"
+)
+
+// Options changes how the check runs
+type Options struct {
+	// Repair deletes the photos without image and the orphan images. Photos sharing an image are only reported.
+	Repair bool
+
+	// Grace is the minimum age of an orphan image, so that uploads in progress are not reported
+	Grace time.Duration
+}
+
+// MissingImage is a photo whose image is not in the storage
+type MissingImage struct {
+	PhotoID  int
+	UserID   int
+	Key      string
+	Repaired bool
+}
+
+// SharedImage is an image used by more than one photo, saved by the versions that named the images by upload second
+type SharedImage struct {
+	Key      string
+	PhotoIDs []int
+}
+
+// Orphan is an image in the storage that no photo refers to
+type Orphan struct {
+	Key      string
+	Size     int64
+	ModTime  time.Time
+	Repaired bool
+}
+
+// Report is the result of the check
+type Report struct {
+	Photos        int
+	Images        int
+	MissingImages []MissingImage
+	SharedImages  []SharedImage
+	Orphans       []Orphan
+}
+
+// Consistent returns true if nothing is left to repair
+func (r Report) Consistent() bool {
+	for _, missing := range r.MissingImages {
+		if !missing.Repaired {
+			return false
+		}
+	}
+	for _, orphan := range r.Orphans {
+		if !orphan.Repaired {
+			return false
+		}
+	}
+	return len(r.SharedImages) == 0
+}
+
+// Check compares the database with the storage and, with opts.Repair, fixes what doesn't match
+func Check(ctx context.Context, db database.AppDatabase, store storage.BlobStore, opts Options) (Report, error) {
+	photos, err := db.GetAllPhotoRefs()
+	if err != nil {
+		return Report{}, fmt.Errorf("reading the photos: %w", err)
+	}
+	byKey := make(map[string][]database.Photo, len(photos))
+	for _, photo := range photos {
+		byKey[photo.Photourl] = append(byKey[photo.Photourl], photo)
+	}
+
+	report := Report{Photos: len(photos)}
+	found := make(map[string]bool, len(photos))
+	// derivativeKeys are the listed derivatives, by key of their photo
+	derivativeKeys := make(map[string][]string)
+	cutoff := time.Now().Add(-opts.Grace)
+	err = store.List(ctx, func(info storage.BlobInfo) error {
+		report.Images++
+		photoKey, isDerivative := derivatives.PhotoKey(info.Key)
+		if isDerivative {
+			derivativeKeys[photoKey] = append(derivativeKeys[photoKey], info.Key)
+		}
+		switch {
+		case len(byKey[info.Key]) > 0:
+			found[info.Key] = true
+		case isDerivative && len(byKey[photoKey]) > 0:
+			// a derivative of an existing photo
+		case info.ModTime.Before(cutoff):
+			report.Orphans = append(report.Orphans, Orphan{Key: info.Key, Size: info.Size, ModTime: info.ModTime})
+		}
+		return nil
+	})
+	if err != nil {
+		return Report{}, fmt.Errorf("listing the images: %w", err)
+	}
+
+	for key, shared := range byKey {
+		if len(shared) > 1 {
+			ids := make([]int, 0, len(shared))
+			for _, photo := range shared {
+				ids = append(ids, photo.ID)
+			}
+			report.SharedImages = append(report.SharedImages, SharedImage{Key: key, PhotoIDs: ids})
+		}
+	}
+	sort.Slice(report.SharedImages, func(i, j int) bool { return report.SharedImages[i].Key < report.SharedImages[j].Key })
+	for _, photo := range photos {
+		if !found[photo.Photourl] {
+			report.MissingImages = append(report.MissingImages, MissingImage{PhotoID: photo.ID, UserID: photo.UserID, Key: photo.Photourl})
+		}
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].Key < report.Orphans[j].Key })
+
+	if opts.Repair {
+		if err := repair(ctx, db, store, &report, derivativeKeys); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// repair deletes the photos without image and the orphans, with their derivatives: also the ones younger than the
+// grace, which are not reported
+func repair(ctx context.Context, db database.AppDatabase, store storage.BlobStore, report *Report,
+	derivativeKeys map[string][]string) error {
+	for i, missing := range report.MissingImages {
+		// the image may have been uploaded again since the listing
+		_, err := store.Stat(ctx, missing.Key)
+		if err == nil {
+			continue
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("checking the image of photo %d: %w", missing.PhotoID, err)
+		}
+		_, err = db.DeletePhoto(missing.PhotoID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return fmt.Errorf("deleting photo %d: %w", missing.PhotoID, err)
+		}
+		if err := deleteDerivatives(ctx, store, missing.Key, derivativeKeys[missing.Key]); err != nil {
+			return fmt.Errorf("deleting the derivatives of photo %d: %w", missing.PhotoID, err)
+		}
+		report.MissingImages[i].Repaired = true
+	}
+	for i, orphan := range report.Orphans {
+		// the derivatives first, so that they are found again with their original if the deletion fails
+		if _, isDerivative := derivatives.PhotoKey(orphan.Key); !isDerivative {
+			if err := deleteDerivatives(ctx, store, orphan.Key, derivativeKeys[orphan.Key]); err != nil {
+				return fmt.Errorf("deleting the derivatives of the orphan image %s: %w", orphan.Key, err)
+			}
+		}
+		if err := store.Delete(ctx, orphan.Key); err != nil {
+			return fmt.Errorf("deleting the orphan image %s: %w", orphan.Key, err)
+		}
+		report.Orphans[i].Repaired = true
+	}
+	return nil
+}
+
+// deleteDerivatives deletes the derivatives of the image photoKey: the listed ones, and the ones written since the
+// listing
+func deleteDerivatives(ctx context.Context, store storage.BlobStore, photoKey string, listed []string) error {
+	for _, key := range listed {
+		if err := store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return derivatives.Delete(ctx, store, photoKey)
+}
@@ -0,0 +1,182 @@
+package integrity
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"wasaPhoto/service/database"
+	"wasaPhoto/service/derivatives"
+	"wasaPhoto/service/storage"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newDatabase returns a database in memory, with one connection so that every query sees the same database
+func newDatabase(t *testing.T) database.AppDatabase {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// put writes the blobs, with their key as content
+func put(t *testing.T, store storage.BlobStore, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if _, err := store.Put(context.Background(), key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// keys returns the sorted keys of the blobs in the store
+func keys(t *testing.T, store storage.BlobStore) []string {
+	t.Helper()
+	var keys []string
+	err := store.List(context.Background(), func(info storage.BlobInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// derivativesOf returns the keys of every derivative of the image photoKey
+func derivativesOf(photoKey string) []string {
+	keys := []string{derivatives.OriginalKey(photoKey)}
+	for _, size := range derivatives.Sizes {
+		keys = append(keys, derivatives.Key(photoKey, size))
+	}
+	return keys
+}
+
+func TestCheck(t *testing.T) {
+	db := newDatabase(t)
+	store := storage.NewMemoryStore()
+	user, err := db.AddUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPhoto := func(key string) database.Photo {
+		photo, err := db.AddPhoto(user.ID, key, "image/jpeg", 1, 1, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return photo
+	}
+	addPhoto("ok.jpg")
+	missing := addPhoto("missing.jpg")
+	put(t, store, "ok.jpg", "orphan.jpg")
+	put(t, store, derivativesOf("ok.jpg")...)
+	put(t, store, derivativesOf("missing.jpg")...)
+	put(t, store, derivativesOf("orphan.jpg")...)
+	// a derivative of a size that is not rendered anymore
+	put(t, store, "derivatives/orphan.jpg/medium.jpg")
+
+	// within the grace, nothing is an orphan
+	report, err := Check(context.Background(), db, store, Options{Grace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMissing := []MissingImage{{PhotoID: missing.ID, UserID: user.ID, Key: "missing.jpg"}}
+	if report.Photos != 2 || report.Images != 15 || !reflect.DeepEqual(report.MissingImages, wantMissing) ||
+		len(report.Orphans) != 0 || len(report.SharedImages) != 0 || report.Consistent() {
+		t.Errorf("report within the grace: %+v", report)
+	}
+
+	report, err = Check(context.Background(), db, store, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var orphans []string
+	for _, orphan := range report.Orphans {
+		if orphan.Size != int64(len(orphan.Key)) || orphan.Repaired {
+			t.Errorf("orphan %+v", orphan)
+		}
+		orphans = append(orphans, orphan.Key)
+	}
+	wantOrphans := append([]string{"orphan.jpg", "derivatives/orphan.jpg/medium.jpg"}, derivativesOf("orphan.jpg")...)
+	sort.Strings(wantOrphans)
+	if !reflect.DeepEqual(orphans, wantOrphans) || !reflect.DeepEqual(report.MissingImages, wantMissing) || report.Consistent() {
+		t.Errorf("report: %+v", report)
+	}
+
+	// checking doesn't change anything
+	if got := len(keys(t, store)); got != 15 {
+		t.Errorf("%d images after the check", got)
+	}
+	if _, err := db.GetPhoto(missing.ID); err != nil {
+		t.Errorf("photo without image after the check: %v", err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	db := newDatabase(t)
+	store := storage.NewMemoryStore()
+	user, err := db.AddUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := db.AddPhoto(user.ID, "ok.jpg", "image/jpeg", 1, 1, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := db.AddPhoto(user.ID, "missing.jpg", "image/jpeg", 1, 1, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, store, "ok.jpg", "orphan.jpg")
+	put(t, store, derivativesOf("ok.jpg")...)
+	put(t, store, derivativesOf("missing.jpg")...)
+	// the derivatives of the orphan are written after it, and are younger than the grace
+	const grace = 200 * time.Millisecond
+	time.Sleep(2 * grace)
+	put(t, store, derivativesOf("orphan.jpg")...)
+	put(t, store, "derivatives/orphan.jpg/medium.jpg")
+
+	report, err := Check(context.Background(), db, store, Options{Repair: true, Grace: grace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Key != "orphan.jpg" || !report.Orphans[0].Repaired {
+		t.Errorf("orphans %+v", report.Orphans)
+	}
+	if len(report.MissingImages) != 1 || report.MissingImages[0].PhotoID != missing.ID || !report.MissingImages[0].Repaired {
+		t.Errorf("missing images %+v", report.MissingImages)
+	}
+	if !report.Consistent() {
+		t.Errorf("report %+v is not consistent after the repair", report)
+	}
+
+	want := append([]string{"ok.jpg"}, derivativesOf("ok.jpg")...)
+	sort.Strings(want)
+	if got := keys(t, store); !reflect.DeepEqual(got, want) {
+		t.Errorf("images after the repair: %v, want %v", got, want)
+	}
+	if _, err := db.GetPhoto(missing.ID); err == nil {
+		t.Error("the photo without image was not deleted")
+	}
+	if _, err := db.GetPhoto(ok.ID); err != nil {
+		t.Errorf("photo with image: %v", err)
+	}
+
+	report, err = Check(context.Background(), db, store, Options{})
+	if err != nil || !report.Consistent() || report.Photos != 1 || report.Images != len(want) {
+		t.Errorf("check after the repair: %+v, %v", report, err)
+	}
+}
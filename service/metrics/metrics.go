@@ -0,0 +1,325 @@
+/*
+Package metrics implements the counters, histograms and gauges of the service, exposed in the Prometheus text format.
+
+Metrics are created in a Registry, which serves them all with Handler. Counters and histograms can have labels, whose
+values are given in the same order as their names; gauges are read from a function at every scrape.
+*/
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histograms of the durations of the requests
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric that writes its samples in the text format
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics of the service
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds c to the registry. A name can be registered once.
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.collectors {
+		if other.name() == c.name() {
+			panic(fmt.Sprintf("metric %s registered twice", c.name()))
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		collectors := append([]collector(nil), r.collectors...)
+		r.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, c := range collectors {
+			c.write(bw)
+		}
+		_ = bw.Flush()
+	})
+}
+
+// vec keeps the values of a metric for each combination of the label values
+type vec struct {
+	metric string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+func (v *vec) name() string {
+	return v.metric
+}
+
+// get returns the value for the label values, created by newValue the first time
+func (v *vec) get(labelValues []string, newValue func() interface{}) interface{} {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.metric, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	value, ok := v.values[key]
+	if !ok {
+		value = newValue()
+		v.values[key] = value
+	}
+	return value
+}
+
+// each calls fn with the label pairs and the value of every combination of label values, sorted
+func (v *vec) each(fn func(labels string, value interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	values := make(map[string]interface{}, len(v.values))
+	for key, value := range v.values {
+		values[key] = value
+	}
+	v.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		var labelValues []string
+		if len(v.labels) > 0 {
+			labelValues = strings.Split(key, "\xff")
+		}
+		fn(formatLabels(v.labels, labelValues), values[key])
+	}
+}
+
+func (v *vec) writeHeader(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metric, escapeHelp(v.help), v.metric, v.kind)
+}
+
+// CounterVec is a counter for each combination of the label values
+type CounterVec struct {
+	vec
+}
+
+type counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// NewCounterVec registers a counter with the given labels. A counter without labels is used without label values.
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec{metric: name, help: help, kind: "counter", labels: labels, values: map[string]interface{}{}}}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the counter of the label values
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(errors.New("counters can't decrease"))
+	}
+	value := c.get(labelValues, func() interface{} { return &counter{} }).(*counter)
+	value.mu.Lock()
+	value.value += delta
+	value.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(labels string, value interface{}) {
+		cnt := value.(*counter)
+		cnt.mu.Lock()
+		v := cnt.value
+		cnt.mu.Unlock()
+		writeSample(w, c.metric, labels, v)
+	})
+}
+
+// HistogramVec is a histogram for each combination of the label values
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a histogram with the given upper bounds of the buckets, in increasing order
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of metric %s are not sorted", name))
+	}
+	h := &HistogramVec{
+		vec:     vec{metric: name, help: help, kind: "histogram", labels: labels, values: map[string]interface{}{}},
+		buckets: buckets,
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds a value to the histogram of the label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	hist := h.get(labelValues, func() interface{} {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}).(*histogram)
+	i := sort.SearchFloat64s(h.buckets, value)
+	hist.mu.Lock()
+	if i < len(hist.counts) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += value
+	hist.mu.Unlock()
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(labels string, value interface{}) {
+		hist := value.(*histogram)
+		hist.mu.Lock()
+		counts := append([]uint64(nil), hist.counts...)
+		count, sum := hist.count, hist.sum
+		hist.mu.Unlock()
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, h.metric+"_bucket", withLabel(labels, "le", formatFloat(upper)), float64(cumulative))
+		}
+		writeSample(w, h.metric+"_bucket", withLabel(labels, "le", "+Inf"), float64(count))
+		writeSample(w, h.metric+"_sum", labels, sum)
+		writeSample(w, h.metric+"_count", labels, float64(count))
+	})
+}
+
+// gaugeFunc is a gauge read at every scrape, with a sample for each value of its label (if any)
+type gaugeFunc struct {
+	metric string
+	help   string
+	label  string
+	fn     func() map[string]float64
+}
+
+func (g *gaugeFunc) name() string {
+	return g.metric
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(&gaugeFunc{metric: name, help: help, fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// NewGaugeVecFunc registers a gauge whose values, by value of the label, are returned by fn. A nil map (e.g., on
+// error) writes no samples.
+func (r *Registry) NewGaugeVecFunc(name string, help string, label string, fn func() map[string]float64) {
+	r.register(&gaugeFunc{metric: name, help: help, label: label, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.metric, escapeHelp(g.help), g.metric)
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		labels := ""
+		if g.label != "" {
+			labels = formatLabels([]string{g.label}, []string{key})
+		}
+		writeSample(w, g.metric, labels, values[key])
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels string, value float64) {
+	_, _ = w.WriteString(name)
+	_, _ = w.WriteString(labels)
+	_ = w.WriteByte(' ')
+	_, _ = w.WriteString(formatFloat(value))
+	_ = w.WriteByte('\n')
+}
+
+// formatLabels returns the label pairs in braces, or an empty string without labels
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i := range names {
+		pairs[i] = names[i] + `="` + escapeLabel(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label pair to the labels formatted by formatLabels
+func withLabel(labels string, name string, value string) string {
+	pair := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// scrape returns the metrics served by the registry
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	return w.Body.String()
+}
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests, by \\method\\\nand status", "method", "status")
+	requests.Inc("POST", "201")
+	requests.Inc("GET", "200")
+	requests.Add(2.5, "GET", "200")
+	requests.Inc("GET", "404")
+	requests.Inc("GET", `C:\photos "new"`+"\n")
+	r.NewCounterVec("errors_total", "Errors").Inc()
+
+	want := `# HELP requests_total Requests, by \\method\\\nand status
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 3.5
+requests_total{method="GET",status="404"} 1
+requests_total{method="GET",status="C:\\photos \"new\"\n"} 1
+requests_total{method="POST",status="201"} 1
+# HELP errors_total Errors
+# TYPE errors_total counter
+errors_total 1
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// the output doesn't change between the scrapes
+	if got := scrape(t, r); got != want {
+		t.Errorf("second scrape:\n%s", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	durations := r.NewHistogramVec("duration_seconds", "Duration", []float64{.1, 1, 10}, "route")
+	for _, v := range []float64{.05, .1, .5, 3, 20} {
+		durations.Observe(v, "/photos")
+	}
+	durations.Observe(2, "/")
+	r.NewHistogramVec("empty_seconds", "Nothing observed", []float64{1})
+	r.NewHistogramVec("total_seconds", "No labels", []float64{1}).Observe(.25)
+
+	want := `# HELP duration_seconds Duration
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/",le="0.1"} 0
+duration_seconds_bucket{route="/",le="1"} 0
+duration_seconds_bucket{route="/",le="10"} 1
+duration_seconds_bucket{route="/",le="+Inf"} 1
+duration_seconds_sum{route="/"} 2
+duration_seconds_count{route="/"} 1
+duration_seconds_bucket{route="/photos",le="0.1"} 2
+duration_seconds_bucket{route="/photos",le="1"} 3
+duration_seconds_bucket{route="/photos",le="10"} 4
+duration_seconds_bucket{route="/photos",le="+Inf"} 5
+duration_seconds_sum{route="/photos"} 23.65
+duration_seconds_count{route="/photos"} 5
+# HELP empty_seconds Nothing observed
+# TYPE empty_seconds histogram
+# HELP total_seconds No labels
+# TYPE total_seconds histogram
+total_seconds_bucket{le="1"} 1
+total_seconds_bucket{le="+Inf"} 1
+total_seconds_sum 0.25
+total_seconds_count 1
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("uptime_seconds", "Uptime", func() float64 { return 12.5 })
+	r.NewGaugeVecFunc("photos", "Photos, by status", "status", func() map[string]float64 {
+		return map[string]float64{"ready": 3, "failed": 0, "pending": 1e21}
+	})
+	r.NewGaugeVecFunc("broken", "Read with an error", "status", func() map[string]float64 { return nil })
+
+	want := `# HELP uptime_seconds Uptime
+# TYPE uptime_seconds gauge
+uptime_seconds 12.5
+# HELP photos Photos, by status
+# TYPE photos gauge
+photos{status="failed"} 0
+photos{status="pending"} 1e+21
+photos{status="ready"} 3
+# HELP broken Read with an error
+# TYPE broken gauge
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		s, label, help string
+	}{
+		{`plain`, `plain`, `plain`},
+		{`back\slash`, `back\\slash`, `back\\slash`},
+		{`"quoted"`, `\"quoted\"`, `"quoted"`},
+		{"new\nline", `new\nline`, `new\nline`},
+		{`\n`, `\\n`, `\\n`},
+	}
+	for _, tt := range tests {
+		if got := escapeLabel(tt.s); got != tt.label {
+			t.Errorf("escapeLabel(%q) = %q, want %q", tt.s, got, tt.label)
+		}
+		if got := escapeHelp(tt.s); got != tt.help {
+			t.Errorf("escapeHelp(%q) = %q, want %q", tt.s, got, tt.help)
+		}
+	}
+}
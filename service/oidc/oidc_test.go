@@ -0,0 +1,144 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"wasaPhoto/service/oidc"
+	"wasaPhoto/service/oidc/oidctest"
+)
+
+func newProvider(t *testing.T) (*oidctest.Issuer, *oidc.Provider) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       issuer.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://app.test/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer, provider
+}
+
+func TestDiscover(t *testing.T) {
+	issuer, err := oidctest.NewIssuer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer issuer.Close()
+	tests := []struct {
+		name string
+		cfg  oidc.Config
+		err  bool
+	}{
+		{"valid", oidc.Config{Issuer: issuer.URL, ClientID: "client", RedirectURL: "http://app.test/callback"}, false},
+		{"issuer mismatch", oidc.Config{Issuer: issuer.URL + "/other", ClientID: "client", RedirectURL: "http://app.test/callback"}, true},
+		{"no client", oidc.Config{Issuer: issuer.URL, RedirectURL: "http://app.test/callback"}, true},
+		{"unreachable", oidc.Config{Issuer: "http://127.0.0.1:1", ClientID: "client", RedirectURL: "http://app.test/callback"}, true},
+	}
+	for _, tt := range tests {
+		_, err := oidc.Discover(context.Background(), tt.cfg)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	issuer, provider := newProvider(t)
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		claims := issuer.DefaultClaims()
+		claims["sub"] = "alice"
+		claims["nonce"] = "nonce"
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+	valid := issuer.Sign("RS256", oidctest.KeyRSA, with(nil))
+	tampered := strings.Split(valid, ".")
+	tampered[1] = strings.Split(issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"sub": "mallory"})), ".")[1]
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"RS256", valid, ""},
+		{"ES256", issuer.Sign("ES256", oidctest.KeyEC, with(nil)), ""},
+		{"audience list", issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"aud": []string{"other", "client"}})), ""},
+		{"expired within the skew", issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"exp": time.Now().Add(-30 * time.Second).Unix()})), ""},
+		{"malformed", "not.a-token", "malformed"},
+		{"tampered claims", strings.Join(tampered, "."), "signature"},
+		{"algorithm of another key", issuer.Sign("ES256", oidctest.KeyRSA, with(nil)), "signature"},
+		{"none algorithm", issuer.Sign("none", oidctest.KeyRSA, with(nil)), "signature"},
+		{"unknown key", issuer.Sign("RS256", "other", with(nil)), "unknown signing key"},
+		{"other issuer", issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"iss": "https://evil.test"})), "another provider"},
+		{"other client", issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"aud": "other"})), "another client"},
+		{"expired", issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), "expired"},
+		{"other nonce", issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"nonce": "other"})), "nonce"},
+		{"no subject", issuer.Sign("RS256", oidctest.KeyRSA, with(map[string]interface{}{"sub": nil})), "subject"},
+	}
+	for _, tt := range tests {
+		claims, err := provider.Verify(context.Background(), tt.token, "nonce")
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err == "" && (claims.Subject != "alice" || claims.Issuer != issuer.URL):
+			t.Errorf("%s: got claims %+v", tt.name, claims)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer, provider := newProvider(t)
+	issuer.Subject = "alice"
+	issuer.Claims = map[string]interface{}{"preferred_username": "Alice"}
+
+	// the browser goes to the provider, which sends it back with the code
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(provider.AuthCodeURL("state", "nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Query().Get("state") != "state" {
+		t.Fatalf("state = %q", callback.Query().Get("state"))
+	}
+	code := callback.Query().Get("code")
+
+	claims, err := provider.Exchange(context.Background(), code, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || claims.PreferredUsername != "Alice" {
+		t.Errorf("got claims %+v", claims)
+	}
+	// codes can be used once
+	if _, err := provider.Exchange(context.Background(), code, "nonce"); err == nil {
+		t.Error("a code was exchanged twice")
+	}
+	if _, err := provider.Exchange(context.Background(), issuer.Code(issuer.DefaultClaims()), "nonce"); err == nil {
+		t.Error("a token without subject and nonce was accepted")
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Package oidctest provides an OpenID Connect provider for the tests, like net/http/httptest provides servers: it serves
+the discovery document, the JWKS, the authorization endpoint and the token endpoint on a local address.
+
+The authorization endpoint logs in Issuer.Subject without asking anything, and sends the browser back to the client
+with a code; the token endpoint exchanges it for an ID token signed with RS256.
+*/
+package oidctest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Key IDs of the signing keys
+const (
+	KeyRSA = "rsa"
+	KeyEC  = "ec"
+)
+
+// Issuer is a running provider. Close stops it.
+type Issuer struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	// Subject is the user logged in by the authorization endpoint, and the claims added to its ID token
+	Subject string
+	Claims  map[string]interface{}
+
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]map[string]interface{}
+}
+
+// NewIssuer starts a provider for the client. Its issuer is the URL of the server.
+func NewIssuer(clientID string, clientSecret string) (*Issuer, error) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	i := &Issuer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Subject:      "subject",
+		rsaKey:       rsaKey,
+		ecKey:        ecKey,
+		codes:        make(map[string]map[string]interface{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("/jwks", i.jwks)
+	mux.HandleFunc("/authorize", i.authorize)
+	mux.HandleFunc("/token", i.token)
+	i.Server = httptest.NewServer(mux)
+	return i, nil
+}
+
+// DefaultClaims returns the claims of a valid ID token for the client: issuer, audience and expiration in an hour
+func (i *Issuer) DefaultClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss": i.URL,
+		"aud": i.ClientID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+}
+
+// Sign returns an ID token with the claims, signed with the key kid (KeyRSA or KeyEC) by the algorithm alg. An
+// unknown kid is kept in the header, and the token is signed with the RSA key.
+func (i *Issuer) Sign(alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	if kid == KeyEC {
+		r, s, _ := ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	} else {
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Code returns an authorization code that the token endpoint exchanges, once, for an ID token with the claims
+func (i *Issuer) Code(claims map[string]interface{}) string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	code := base64.RawURLEncoding.EncodeToString(buf)
+	i.mu.Lock()
+	i.codes[code] = claims
+	i.mu.Unlock()
+	return code
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	writeJSON(w, map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": KeyRSA, "use": "sig", "n": b64(i.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(i.rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": KeyEC, "use": "sig", "crv": "P-256", "x": b64(i.ecKey.X.FillBytes(make([]byte, 32))), "y": b64(i.ecKey.Y.FillBytes(make([]byte, 32)))},
+	}})
+}
+
+// authorize logs in Subject and redirects to redirect_uri with a code and the state
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != i.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	claims := i.DefaultClaims()
+	for name, value := range i.Claims {
+		claims[name] = value
+	}
+	claims["sub"] = i.Subject
+	claims["nonce"] = q.Get("nonce")
+	values := redirect.Query()
+	values.Set("code", i.Code(claims))
+	values.Set("state", q.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if r.Method != http.MethodPost || id != i.ClientID || secret != i.ClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	code := r.PostFormValue("code")
+	i.mu.Lock()
+	claims, ok := i.codes[code]
+	delete(i.codes, code)
+	i.mu.Unlock()
+	if r.PostFormValue("grant_type") != "authorization_code" || !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]string{"token_type": "Bearer", "access_token": "access", "id_token": i.Sign("RS256", KeyRSA, claims)})
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is how often full buckets are dropped from a MemoryStore
+const cleanupInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// refill adds the tokens accumulated since the last request
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.rate())
+		b.last = now
+	}
+}
+
+// MemoryStore keeps the buckets in memory. It's suitable for a single instance of the service.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	done    chan struct{}
+}
+
+// NewMemoryStore returns an empty MemoryStore. Close must be called to stop the cleanup goroutine.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		buckets: make(map[string]*bucket),
+		done:    make(chan struct{}),
+	}
+	go s.cleanup()
+	return s
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+
+	res := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) / limit.rate() * float64(time.Second))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(limit.Burst) - b.tokens) / limit.rate() * float64(time.Second))
+	return res, nil
+}
+
+// cleanup drops the buckets that are full, as they are equivalent to a missing one
+func (s *MemoryStore) cleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, b := range s.buckets {
+				b.refill(now)
+				if b.tokens >= float64(b.limit.Burst) {
+					delete(s.buckets, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	close(s.done)
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Package ratelimit implements token bucket rate limiting with per-group policies.
+
+Buckets live in a Store. MemoryStore keeps them in the process; a shared Store (e.g., backed by Redis) can be plugged in
+to share counters between several instances of the service.
+*/
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"wasaPhoto/service/globaltime"
+)
+
+// Limit is the policy of a group of routes: Burst requests can be done at once, then the bucket is refilled with
+// Requests tokens every Period.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// rate returns the tokens added to the bucket every second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Policy returns the limit in the format of the RateLimit-Policy header, e.g. `300;w=60;burst=60`
+func (l Limit) Policy() string {
+	return fmt.Sprintf("%d;w=%d;burst=%d", l.Requests, int(l.Period.Seconds()), l.Burst)
+}
+
+// Result is the outcome of a request against a bucket
+type Result struct {
+	// Allowed is false if the request must be refused
+	Allowed bool
+
+	// Remaining is the number of requests that can be done right now
+	Remaining int
+
+	// RetryAfter is how long to wait before the next request is allowed (zero if Allowed)
+	RetryAfter time.Duration
+
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the buckets. Take must consume one token of the bucket identified by key atomically, creating a full
+// bucket if it does not exist.
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+
+	// Close releases the resources of the store
+	Close() error
+}
+
+// Limiter applies the limit of a group to a key (like a user or an IP address)
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+}
+
+// New returns a Limiter with a limit for each group. Groups without a limit are not limited.
+func New(store Store, limits map[string]Limit) (*Limiter, error) {
+	if store == nil {
+		return nil, errors.New("store is required")
+	}
+	for group, limit := range limits {
+		if limit.Requests <= 0 || limit.Period <= 0 || limit.Burst <= 0 {
+			return nil, fmt.Errorf("invalid limit for group %q: requests, period and burst must be positive", group)
+		}
+	}
+	return &Limiter{store: store, limits: limits}, nil
+}
+
+// Allow takes a token from the bucket of key in group. The returned Limit is the policy applied; ok is false if the
+// group is not limited.
+func (l *Limiter) Allow(group string, key string) (res Result, limit Limit, ok bool, err error) {
+	limit, ok = l.limits[group]
+	if !ok {
+		return Result{Allowed: true}, limit, false, nil
+	}
+	res, err = l.store.Take(group+":"+key, limit, globaltime.Now())
+	return res, limit, true, err
+}
+
+// Close closes the store
+func (l *Limiter) Close() error {
+	return l.store.Close()
+}
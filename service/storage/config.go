@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Backends of Open
+const (
+	BackendLocal  = "local"
+	BackendS3     = "s3"
+	BackendMemory = "memory"
+)
+
+// Config selects the store of an instance, like Storage in the configuration of webapi
+type Config struct {
+	// Backend is BackendLocal (files in Directory), BackendS3 (the bucket of S3) or BackendMemory
+	Backend   string
+	Directory string
+	S3        S3Config
+}
+
+// RegisterFlags defines the flags of the tools that open the store of an instance, with the defaults of webapi:
+// -backend, -images, -s3-endpoint, -s3-region, -s3-bucket, -s3-access-key and -s3-path-style. The secret key is not a
+// flag, so that it doesn't end up in the process list: the tools read it from CFG_STORAGE_S3_SECRETKEY, like webapi.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Backend, "backend", BackendLocal, "storage of the images: local, s3 or memory")
+	fs.StringVar(&c.Directory, "images", "/tmp/images", "folder of the images, with the local storage")
+	fs.StringVar(&c.S3.Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "endpoint of the S3 service")
+	fs.StringVar(&c.S3.Region, "s3-region", "us-east-1", "region of the bucket")
+	fs.StringVar(&c.S3.Bucket, "s3-bucket", "", "bucket of the images")
+	fs.StringVar(&c.S3.AccessKey, "s3-access-key", "", "access key of the bucket")
+	fs.BoolVar(&c.S3.PathStyle, "s3-path-style", true, "put the bucket in the path")
+	c.S3.Timeout = 30 * time.Second
+}
+
+// Open returns the store of the backend of cfg
+func Open(cfg Config) (BlobStore, error) {
+	switch cfg.Backend {
+	case BackendLocal:
+		return NewLocalStore(cfg.Directory)
+	case BackendS3:
+		return NewS3Store(cfg.S3)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, use local, s3 or memory", cfg.Backend)
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-images", dir}, "*storage.LocalStore"},
+		{[]string{"-backend", "memory"}, "*storage.MemoryStore"},
+		{[]string{"-backend", "s3", "-s3-bucket", "images", "-s3-access-key", "key"}, "*storage.S3Store"},
+		{[]string{"-backend", "s3", "-s3-bucket", "images"}, ""},
+		{[]string{"-backend", "ftp"}, ""},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.S3.AccessKey != "" {
+			cfg.S3.SecretKey = "secret"
+		}
+		store, err := Open(cfg)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%q: opened %T, want an error", tt.args, store)
+		case tt.want != "" && err != nil:
+			t.Errorf("%q: %v", tt.args, err)
+		case tt.want != "" && fmt.Sprintf("%T", store) != tt.want:
+			t.Errorf("%q: opened %T, want %s", tt.args, store, tt.want)
+		}
+		if s3, ok := store.(*S3Store); ok && err == nil {
+			if s3.cfg.Endpoint != "https://s3.amazonaws.com" || s3.cfg.Region != "us-east-1" || !s3.cfg.PathStyle || s3.cfg.Timeout == 0 {
+				t.Errorf("%q: the defaults of webapi are not used: %+v", tt.args, s3.cfg)
+			}
+		}
+	}
+}
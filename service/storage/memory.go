@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the blobs in memory, for tests and demos: they are lost when the process ends
+type MemoryStore struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blobs: make(map[string]memoryBlob)}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, r io.Reader) (BlobInfo, error) {
+	if err := ValidKey(key); err != nil {
+		return BlobInfo{}, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	blob := memoryBlob{data: data, modTime: time.Now()}
+	s.mu.Lock()
+	s.blobs[key] = blob
+	s.mu.Unlock()
+	return blob.info(key), nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	s.mu.RLock()
+	blob, ok := s.blobs[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, BlobInfo{}, ErrNotFound
+	}
+	// blobs are never changed in place, so the data can be shared
+	return io.NopCloser(bytes.NewReader(blob.data)), blob.info(key), nil
+}
+
+func (s *MemoryStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	s.mu.RLock()
+	blob, ok := s.blobs[key]
+	s.mu.RUnlock()
+	if !ok {
+		return BlobInfo{}, ErrNotFound
+	}
+	return blob.info(key), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.blobs, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	s.mu.RLock()
+	infos := make([]BlobInfo, 0, len(s.blobs))
+	for key, blob := range s.blobs {
+		infos = append(infos, blob.info(key))
+	}
+	s.mu.RUnlock()
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b memoryBlob) info(key string) BlobInfo {
+	return BlobInfo{Key: key, Size: int64(len(b.data)), ModTime: b.modTime}
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config is the configuration of an S3Store
+type S3Config struct {
+	// Endpoint is the base URL of the service, like https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint string
+
+	// Region is the region of the bucket, used in the signature (MinIO accepts any, by default us-east-1)
+	Region string
+
+	// Bucket is where the blobs are stored, it must exist
+	Bucket string
+
+	// AccessKey and SecretKey are the credentials of the requests
+	AccessKey string
+	SecretKey string
+
+	// PathStyle puts the bucket in the path (Endpoint/bucket/key) instead of the host name (bucket.host/key), as
+	// needed by MinIO and most of the self-hosted services
+	PathStyle bool
+
+	// Timeout is the timeout of each request, zero means no timeout
+	Timeout time.Duration
+}
+
+// S3Store keeps the blobs in a bucket of an S3-compatible service, signing the requests with AWS Signature Version 4
+type S3Store struct {
+	cfg    S3Config
+	base   *url.URL
+	client *http.Client
+
+	// now returns the time of the signatures
+	now func() time.Time
+}
+
+// NewS3Store returns an S3Store for the bucket. The bucket is not contacted until the first request.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("region is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("credentials are required")
+	}
+	base, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+	if !cfg.PathStyle {
+		base.Host = cfg.Bucket + "." + base.Host
+	}
+	return &S3Store{
+		cfg:    cfg,
+		base:   base,
+		client: &http.Client{Timeout: cfg.Timeout},
+		now:    time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of the object key, or of the bucket if key is empty, with the query
+func (s *S3Store) objectURL(key string, query url.Values) *url.URL {
+	segments := []string{s.base.Path}
+	if s.cfg.PathStyle {
+		segments = append(segments, s.cfg.Bucket)
+	}
+	if key != "" {
+		segments = append(segments, key)
+	}
+	path := strings.Join(segments, "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u := *s.base
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// s3Error is the body of the error responses
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+// do sends a signed request, and returns the response if its status is one of ok. A 404 status is ErrNotFound.
+func (s *S3Store) do(ctx context.Context, method string, key string, query url.Values, body []byte, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL = s.objectURL(key, query)
+	req.Host = req.URL.Host
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), s.now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if res.StatusCode == code {
+			return res, nil
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var e s3Error
+	_ = xml.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&e)
+	if e.Code != "" {
+		return nil, fmt.Errorf("S3 %s %s: %s (%s)", method, key, e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("S3 %s %s: %s", method, key, res.Status)
+}
+
+// Put uploads the blob with a single request, which is atomic: the content is read in memory to sign it
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) (BlobInfo, error) {
+	if err := ValidKey(key); err != nil {
+		return BlobInfo{}, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	res, err := s.do(ctx, http.MethodPut, key, nil, data, http.StatusOK)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	_ = res.Body.Close()
+	return BlobInfo{Key: key, Size: int64(len(data)), ModTime: s.now()}, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, BlobInfo{}, err
+	}
+	res, err := s.do(ctx, http.MethodGet, key, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return res.Body, responseInfo(key, res), nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	if err := ValidKey(key); err != nil {
+		return BlobInfo{}, err
+	}
+	res, err := s.do(ctx, http.MethodHead, key, nil, nil, http.StatusOK)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	_ = res.Body.Close()
+	return responseInfo(key, res), nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	res, err := s.do(ctx, http.MethodDelete, key, nil, nil, http.StatusNoContent, http.StatusOK)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	return nil
+}
+
+// listResult is the body of a ListObjectsV2 response
+type listResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List lists the bucket a page at a time
+func (s *S3Store) List(ctx context.Context, fn func(BlobInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		res, err := s.do(ctx, http.MethodGet, "", query, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var page listResult
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		_ = res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding the object list: %w", err)
+		}
+		for _, object := range page.Contents {
+			if err := fn(BlobInfo{Key: object.Key, Size: object.Size, ModTime: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func responseInfo(key string, res *http.Response) BlobInfo {
+	info := BlobInfo{Key: key, Size: res.ContentLength}
+	if size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64); err == nil {
+		info.Size = size
+	}
+	if modTime, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
+
+// sign adds the AWS Signature Version 4 of the request to its headers. Every header of the request is signed, with
+// the host.
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by name, as required by the signature
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters of RFC 3986, and "/" unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
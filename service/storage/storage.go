@@ -0,0 +1,71 @@
+/*
+Package storage keeps the images of the photos in a blob store: a local folder, an S3-compatible bucket (AWS S3,
+MinIO...) or the memory, for tests.
+
+Blobs are identified by keys like "1_6ba7b810-9dad-11d1-80b4-00c04fd430c8.jpg" (see NewKey), relative to the root of
+the store: the database stores the keys, so the same rows work with any store. A key is made of path segments separated
+by "/", without empty, "." or ".." segments.
+*/
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrNotFound is returned when the blob with the key does not exist
+var ErrNotFound = errors.New("blob not found")
+
+// BlobInfo describes a blob
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore stores blobs by key. The implementations are safe for concurrent use.
+type BlobStore interface {
+	// Put writes the content of r as the blob key, replacing it if it exists. Readers see either the previous or the
+	// new content, never a partial blob.
+	Put(ctx context.Context, key string, r io.Reader) (BlobInfo, error)
+
+	// Get returns the content of the blob key, which must be closed, or ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error)
+
+	// Stat returns the description of the blob key, or ErrNotFound
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+
+	// Delete removes the blob key. Deleting a blob that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List calls fn for every blob in the store, in no particular order, stopping at the first error
+	List(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// ValidKey returns an error if key can't be used as the key of a blob
+func ValidKey(key string) error {
+	if key == "" {
+		return errors.New("empty blob key")
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "\\\x00") {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
+
+// NewKey returns a key that no other blob has, made of prefix, a random UUID and ext (like ".jpg")
+func NewKey(prefix string, ext string) (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	return prefix + "_" + id.String() + ext, nil
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceID identifies a trace, shared by all its spans
+type TraceID [16]byte
+
+// SpanID identifies a span in a trace
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero reports whether the ID is the invalid all-zero one
+func (id TraceID) IsZero() bool {
+	return id == TraceID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero reports whether the ID is the invalid all-zero one, which is also used for a span without parent
+func (id SpanID) IsZero() bool {
+	return id == SpanID{}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id.IsZero() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// SpanContext is the part of a span that is propagated to other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return !sc.TraceID.IsZero() && !sc.SpanID.IsZero()
+}
+
+// Traceparent returns the value of the W3C traceparent header for the span
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header. Later versions of the format are accepted as long as they start
+// like version 00. The fields are lowercase hex: uppercase makes the header invalid, as required by the format.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	for _, part := range parts[:4] {
+		if strings.ToLower(part) != part {
+			return sc, false
+		}
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Extract returns a context with the remote parent in the traceparent header of h, if valid
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := ParseTraceparent(h.Get("traceparent")); ok {
+		return ContextWithRemoteParent(ctx, sc)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent header of an outgoing request to the span in ctx, if any
+func Inject(ctx context.Context, h http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		h.Set("traceparent", span.SpanContext().Traceparent())
+	}
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import "testing"
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "0af7651916cd43dd8448eb211c80319c"
+		spanID  = "b7ad6b7169203331"
+	)
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + spanID + "-02", true, false},
+		{"spaces", " 00-" + traceID + "-" + spanID + "-01\t", true, true},
+		{"later version", "cc-" + traceID + "-" + spanID + "-01", true, true},
+		{"later version with more fields", "cc-" + traceID + "-" + spanID + "-01-what-the-future-will-be-like", true, true},
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-more", false, false},
+		{"later version with longer flags", "cc-" + traceID + "-" + spanID + "-01.more", false, false},
+		{"invalid version", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"uppercase trace ID", "00-0AF7651916CD43DD8448EB211C80319C-" + spanID + "-01", false, false},
+		{"uppercase span ID", "00-" + traceID + "-B7AD6B7169203331-01", false, false},
+		{"uppercase version", "CC-" + traceID + "-" + spanID + "-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"zero span ID", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"not hex", "00-" + traceID + "-" + spanID + "-0g", false, false},
+		{"short trace ID", "00-" + traceID[1:] + "-" + spanID + "-01", false, false},
+		{"short span ID", "00-" + traceID + "-" + spanID[1:] + "-01", false, false},
+		{"missing flags", "00-" + traceID + "-" + spanID, false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.valid {
+			t.Errorf("%s: valid %v, want %v", tt.name, ok, tt.valid)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != tt.sampled {
+			t.Errorf("%s: got %+v", tt.name, sc)
+		}
+		// version 00 and the sampled flag survive a round trip
+		if back, ok := ParseTraceparent(sc.Traceparent()); !ok || back != sc {
+			t.Errorf("%s: %q parsed as %+v", tt.name, sc.Traceparent(), back)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Package tracing records the spans of the requests, compatible with OpenTelemetry: trace and span IDs, W3C trace context
+propagation (traceparent header) and export in the OTLP format.
+
+A Tracer starts root spans, for example one per HTTP request; StartChild starts the child spans of the span in a
+context, like the calls to the database. Ended spans are exported in batches by an Exporter. A nil *Tracer and a nil
+*Span are valid and do nothing, so the code does not need to check whether tracing is enabled.
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize is the number of ended spans waiting to be exported, more are dropped
+	queueSize = 2048
+
+	// batchSize is the maximum number of spans sent to the exporter at once
+	batchSize = 512
+
+	// flushInterval is how often the spans are exported when the batch is not full
+	flushInterval = 5 * time.Second
+)
+
+// SpanKind is the role of the span in the trace, with the values of OpenTelemetry
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Config is used to provide the dependencies and the configuration to New
+type Config struct {
+	// ServiceName is the service.name resource attribute of the spans
+	ServiceName string
+
+	// Exporter receives the ended spans
+	Exporter Exporter
+
+	// SampleRatio is the fraction of the traces started here that are recorded, from 0 to 1. Traces started by a
+	// remote parent follow its sampling decision.
+	SampleRatio float64
+
+	// Logger receives the export errors
+	Logger logrus.FieldLogger
+}
+
+// Tracer starts the spans and exports them
+type Tracer struct {
+	serviceName string
+	exporter    Exporter
+	sampleRatio float64
+	logger      logrus.FieldLogger
+
+	queue   chan *Span
+	done    chan struct{}
+	wg      sync.WaitGroup
+	dropped uint64
+}
+
+// New returns a Tracer. Shutdown must be called to export the last spans and stop the export goroutine.
+func New(cfg Config) (*Tracer, error) {
+	if cfg.Exporter == nil {
+		return nil, errors.New("exporter is required")
+	}
+	if cfg.Logger == nil {
+		return nil, errors.New("logger is required")
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, errors.New("sample ratio must be between 0 and 1")
+	}
+	t := &Tracer{
+		serviceName: cfg.ServiceName,
+		exporter:    cfg.Exporter,
+		sampleRatio: cfg.SampleRatio,
+		logger:      cfg.Logger,
+		queue:       make(chan *Span, queueSize),
+		done:        make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.export()
+	return t, nil
+}
+
+// Start starts a root span, child of the remote parent in ctx if any (see ContextWithRemoteParent). The returned
+// context carries the span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, Name: name, Kind: kind, StartTime: time.Now()}
+	if parent, ok := ctx.Value(remoteParentKey{}).(SpanContext); ok && parent.IsValid() {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+		span.sampled = parent.Sampled
+	} else {
+		span.TraceID = newTraceID()
+		span.sampled = t.sample()
+	}
+	span.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartChild starts a child of the span in ctx. Without a recorded span in ctx, no span is started and the returned
+// span is nil.
+func StartChild(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil || !parent.sampled {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer:       parent.tracer,
+		Name:         name,
+		Kind:         kind,
+		TraceID:      parent.TraceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: parent.SpanID,
+		StartTime:    time.Now(),
+		sampled:      true,
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Shutdown exports the spans still in the queue, and stops the export goroutine. Spans ended later are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	close(t.done)
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if dropped := atomic.LoadUint64(&t.dropped); dropped > 0 {
+		t.logger.WithField("dropped", dropped).Warning("some spans were dropped because the export queue was full")
+	}
+	return t.exporter.Close()
+}
+
+// sample decides if a new trace is recorded
+func (t *Tracer) sample() bool {
+	switch {
+	case t.sampleRatio >= 1:
+		return true
+	case t.sampleRatio <= 0:
+		return false
+	}
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return float64(binary.BigEndian.Uint64(b[:])>>11)/(1<<53) < t.sampleRatio
+}
+
+// enqueue sends an ended span to the export goroutine, or drops it if the queue is full
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case <-t.done:
+		atomic.AddUint64(&t.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case t.queue <- span:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// export sends the spans to the exporter in batches, every flushInterval or when the batch is full
+func (t *Tracer) export() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(t.serviceName, batch); err != nil {
+			t.logger.WithError(err).WithField("spans", len(batch)).Warning("can't export the spans")
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+					if len(batch) == batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Attribute is a key-value pair describing a span. Values are strings, integers, floats or booleans.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is an operation of a trace. Its fields are read by the exporters, and must not be changed directly.
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+	mu      sync.Mutex
+	ended   bool
+
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   []Attribute
+
+	// Error is the description of the error of the operation, empty if it succeeded
+	Error string
+}
+
+// SpanContext returns the identity of the span, to propagate it
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.TraceID, SpanID: s.SpanID, Sampled: s.sampled}
+}
+
+// SetAttribute adds an attribute to the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the operation as failed with err. A nil err does nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// End ends the span and queues it for the export, if recorded. Calls after the first do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	if s.sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+
+type remoteParentKey struct{}
+
+// SpanFromContext returns the span in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a context where the next root span is a child of parent, a span of another service
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, parent)
+}
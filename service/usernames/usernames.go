@@ -0,0 +1,194 @@
+/*
+Package usernames normalizes usernames and checks them against a Policy.
+
+A username is stored in its NFKC form (Normalize), so that e.g. full-width letters become the usual ones. Two usernames
+are the same if they have the same Key (NFKC plus case folding), and they are confusable if they have the same Skeleton,
+a simplified version of the skeleton of Unicode TS #39 that maps characters that look alike (like the Cyrillic "а"
+and the Latin "a") to the same one.
+*/
+package usernames
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ValidationError is returned by Policy.Validate, Reason is meant to be shown to the user
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Normalize returns the form in which the username is stored: NFKC, without leading and trailing spaces
+func Normalize(name string) string {
+	return strings.TrimSpace(norm.NFKC.String(name))
+}
+
+// Key returns the form used to check that usernames are unique: usernames that differ only by case or by Unicode
+// compatibility characters have the same key.
+func Key(name string) string {
+	// case folding can produce characters that are not NFKC, so it's normalized again
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(name)))
+}
+
+// Skeleton returns the form used to detect confusable usernames
+func Skeleton(name string) string {
+	mapped := strings.Map(func(r rune) rune {
+		if c, ok := confusables[r]; ok {
+			return c
+		}
+		return r
+	}, norm.NFKC.String(name))
+	return confusableSequences.Replace(Key(mapped))
+}
+
+// Policy defines the usernames that can be chosen
+type Policy struct {
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+	// reserved maps the key and the skeleton of the reserved names to the names
+	reserved map[string]string
+}
+
+// NewPolicy returns a policy that allows usernames of minLength to maxLength characters, matching pattern (a regular
+// expression checked on the normalized username) and not confusable with one of the reserved names.
+func NewPolicy(minLength int, maxLength int, pattern string, reserved []string) (*Policy, error) {
+	if minLength < 1 || maxLength < minLength {
+		return nil, fmt.Errorf("invalid username length: %d to %d", minLength, maxLength)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username pattern: %w", err)
+	}
+	p := &Policy{
+		minLength: minLength,
+		maxLength: maxLength,
+		pattern:   re,
+		reserved:  make(map[string]string, len(reserved)),
+	}
+	for _, name := range reserved {
+		if name = strings.TrimSpace(name); name != "" {
+			p.reserved[Key(name)] = name
+			p.reserved[Skeleton(name)] = name
+		}
+	}
+	return p, nil
+}
+
+// Validate checks a username, which must be already normalized with Normalize. The error is a *ValidationError if the
+// username does not respect the policy.
+func (p *Policy) Validate(name string) error {
+	if name == "" {
+		return invalid("username is empty")
+	}
+	length := utf8.RuneCountInString(name)
+	if length < p.minLength || length > p.maxLength {
+		return invalid("username must be %d to %d characters long", p.minLength, p.maxLength)
+	}
+	for _, r := range name {
+		if !unicode.IsGraphic(r) || unicode.In(r, unicode.Cf) {
+			return invalid("username can't contain invisible or control characters")
+		}
+	}
+	if !p.pattern.MatchString(name) {
+		return invalid("username contains characters that are not allowed")
+	}
+	if mixedScripts(name) {
+		return invalid("username can't mix letters of different alphabets")
+	}
+	for _, form := range []string{Key(name), Skeleton(name)} {
+		if reserved, ok := p.reserved[form]; ok {
+			return invalid("username %q is reserved", reserved)
+		}
+	}
+	return nil
+}
+
+// scripts are the writing systems detected by mixedScripts, letters of other scripts are not checked
+var scripts = map[string]*unicode.RangeTable{
+	"Latin":      unicode.Latin,
+	"Greek":      unicode.Greek,
+	"Cyrillic":   unicode.Cyrillic,
+	"Armenian":   unicode.Armenian,
+	"Hebrew":     unicode.Hebrew,
+	"Arabic":     unicode.Arabic,
+	"Devanagari": unicode.Devanagari,
+	"Thai":       unicode.Thai,
+	"Georgian":   unicode.Georgian,
+	"Cherokee":   unicode.Cherokee,
+	"Han":        unicode.Han,
+	"Hiragana":   unicode.Hiragana,
+	"Katakana":   unicode.Katakana,
+	"Hangul":     unicode.Hangul,
+	"Bopomofo":   unicode.Bopomofo,
+}
+
+// allowedMixes are the sets of scripts that are commonly written together ("Highly Restrictive" in Unicode TS #39)
+var allowedMixes = []map[string]bool{
+	{"Latin": true, "Han": true, "Hiragana": true, "Katakana": true},
+	{"Latin": true, "Han": true, "Bopomofo": true},
+	{"Latin": true, "Han": true, "Hangul": true},
+}
+
+// mixedScripts reports whether name has letters of scripts that are not used together, like "pаypal" with a
+// Cyrillic "а"
+func mixedScripts(name string) bool {
+	found := make(map[string]bool)
+	for _, r := range name {
+		for script, table := range scripts {
+			if unicode.Is(table, r) {
+				found[script] = true
+				break
+			}
+		}
+	}
+	if len(found) <= 1 {
+		return false
+	}
+	for _, allowed := range allowedMixes {
+		ok := true
+		for script := range found {
+			if !allowed[script] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return false
+		}
+	}
+	return true
+}
+
+// confusables maps characters to a Latin character that looks alike. It's applied before case folding, as some
+// characters are confusable only in one case (e.g. "I" and "l").
+var confusables = map[rune]rune{
+	// digits and symbols
+	'0': 'o', '1': 'l', 'I': 'l', '|': 'l', 'ı': 'i',
+	// Cyrillic
+	'а': 'a', 'А': 'a', 'в': 'b', 'В': 'b', 'е': 'e', 'Е': 'e', 'ё': 'e', 'Ё': 'e', 'з': '3', 'к': 'k', 'К': 'k',
+	'м': 'm', 'М': 'm', 'н': 'h', 'Н': 'h', 'о': 'o', 'О': 'o', 'р': 'p', 'Р': 'p', 'с': 'c', 'С': 'c', 'т': 't',
+	'Т': 't', 'у': 'y', 'У': 'y', 'х': 'x', 'Х': 'x', 'ѕ': 's', 'Ѕ': 's', 'і': 'i', 'І': 'l', 'ј': 'j', 'Ј': 'j',
+	'ԁ': 'd', 'һ': 'h', 'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l', 'Ӏ': 'l', 'ѵ': 'v', 'ү': 'y', 'ɡ': 'g',
+	// Greek
+	'α': 'a', 'Α': 'a', 'Β': 'b', 'ε': 'e', 'Ε': 'e', 'Ζ': 'z', 'Η': 'h', 'ι': 'i', 'Ι': 'l', 'κ': 'k', 'Κ': 'k',
+	'Μ': 'm', 'ν': 'v', 'Ν': 'n', 'ο': 'o', 'Ο': 'o', 'ρ': 'p', 'Ρ': 'p', 'τ': 't', 'Τ': 't', 'υ': 'u', 'Υ': 'y',
+	'χ': 'x', 'Χ': 'x', 'ϲ': 'c', 'Ϲ': 'c',
+}
+
+// confusableSequences replaces sequences of characters that look like a single one
+var confusableSequences = strings.NewReplacer("rn", "m", "vv", "w")
@@ -0,0 +1,136 @@
+package usernames
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"alice", "alice"},
+		{"  alice\t", "alice"},
+		{"ａｌｉｃｅ", "alice"},
+		{"ﬁona", "fiona"},
+		{"Alice", "Alice"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.name); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"alice", "ALICE", true},
+		{"Alice", "ａｌｉｃｅ", true},
+		{"straße", "STRASSE", true},
+		{"ΣΊΣΥΦΟΣ", "σίσυφος", true},
+		{"ﬁona", "FIONA", true},
+		{"alice", "alicia", false},
+		{"rnary", "mary", false},
+	}
+	for _, tt := range tests {
+		if same := Key(tt.a) == Key(tt.b); same != tt.same {
+			t.Errorf("Key(%q) = %q, Key(%q) = %q, same should be %v", tt.a, Key(tt.a), tt.b, Key(tt.b), tt.same)
+		}
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		confusing bool
+	}{
+		{"paypal", "pаypal", true},
+		{"paypal", "PAYPAL", true},
+		{"login", "l0gin", true},
+		{"Ilya", "llya", true},
+		{"mary", "rnary", true},
+		{"walter", "vvalter", true},
+		{"alice", "аlicе", true},
+		{"alice", "bob", false},
+		{"mary", "marty", false},
+	}
+	for _, tt := range tests {
+		if confusing := Skeleton(tt.a) == Skeleton(tt.b); confusing != tt.confusing {
+			t.Errorf("Skeleton(%q) = %q, Skeleton(%q) = %q, confusable should be %v", tt.a, Skeleton(tt.a), tt.b,
+				Skeleton(tt.b), tt.confusing)
+		}
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		pattern  string
+	}{
+		{"no minimum", 0, 20, `^.+$`},
+		{"maximum below the minimum", 5, 4, `^.+$`},
+		{"invalid pattern", 3, 20, `^[a-z`},
+	}
+	for _, tt := range tests {
+		if _, err := NewPolicy(tt.min, tt.max, tt.pattern, nil); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	policy, err := NewPolicy(3, 20, `^[\p{L}\p{N}_.-]+$`, []string{"admin", "support", " "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		username string
+		// reason is a part of the reason of the error, empty if the username is valid
+		reason string
+	}{
+		{"valid", "alice", ""},
+		{"shortest", "bob", ""},
+		{"longest", strings.Repeat("a", 20), ""},
+		{"length in characters", "ééé", ""},
+		{"empty", "", "empty"},
+		{"too short", "al", "3 to 20 characters"},
+		{"too long", strings.Repeat("a", 21), "3 to 20 characters"},
+		{"too long in characters", strings.Repeat("é", 21), "3 to 20 characters"},
+		{"zero width space", "al\u200bice", "invisible"},
+		{"control character", "al\x07ice", "invisible"},
+		{"space", "al ice", "not allowed"},
+		{"symbol", "alice!", "not allowed"},
+		{"Cyrillic", "алиса", ""},
+		{"Greek", "αλίκη", ""},
+		{"Latin and Han", "tokyo東京", ""},
+		{"Latin, Han and Hiragana", "tokyoとうきょう東京", ""},
+		{"Latin and Hangul", "seoul서울", ""},
+		{"Latin and Cyrillic", "pаypal", "different alphabets"},
+		{"Latin and Greek", "alicε", "different alphabets"},
+		{"Hangul and Hiragana", "서울とうきょう", "different alphabets"},
+		{"reserved", "admin", `"admin" is reserved`},
+		{"reserved, other case", "ADMIN", `"admin" is reserved`},
+		{"reserved, confusable", "adrnin", `"admin" is reserved`},
+		{"reserved, digit", "supp0rt", `"support" is reserved`},
+	}
+	for _, tt := range tests {
+		err := policy.Validate(tt.username)
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("%s: %q: %v", tt.name, tt.username, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) || !strings.Contains(verr.Reason, tt.reason) {
+			t.Errorf("%s: %q: error %v, want a ValidationError containing %q", tt.name, tt.username, err, tt.reason)
+		}
+	}
+}
@@ -6,13 +6,17 @@ const instance = axios.create({
 });
 
 const axiosUpdate = () => {
-	instance.defaults.headers.common['Authorization'] = 'Bearer ' + getCurrentSession();
+	instance.defaults.headers.common['Authorization'] = 'Bearer ' + getStored('token');
 }
-function getCurrentSession() {
-	if (localStorage.getItem('token') == null){
-        return sessionStorage.getItem('token');
+function getStored(key) {
+	if (localStorage.getItem(key) == null){
+        return sessionStorage.getItem(key);
     }
-    return localStorage.getItem('token');
+    return localStorage.getItem(key);
+}
+// getCurrentSession returns the ID of the logged user
+function getCurrentSession() {
+	return getStored('userid');
 }
 export {
 	instance as axios,